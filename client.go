@@ -40,6 +40,18 @@ type Client struct {
 	// Backoff configures the backoff strategy. See the documentation of
 	// each field for more information.
 	Backoff Backoff
+	// If RejectInvalidUTF8 is set, connections fail with an error wrapping ErrInvalidUTF8
+	// when the server sends a field which is not valid UTF-8, as the specification requires
+	// event streams to be UTF-8 encoded. No reconnections are attempted, as the server would
+	// most likely send the same stream again. This is useful when proxying streams from
+	// untrusted servers. By default the fields are received as they are.
+	RejectInvalidUTF8 bool
+	// MaxDataLines, if >0, is the maximum number of data fields an event can have. Connections fail
+	// with an error wrapping ErrTooManyDataLines when the server sends an event with more, before
+	// it is dispatched. Just like for invalid UTF-8, no reconnections are attempted. It protects
+	// against servers which send events made of a huge number of tiny data fields, for which
+	// limiting the buffer size (see Connection.Buffer) is not enough. By default there is no limit.
+	MaxDataLines int
 }
 
 // Backoff configures the reconnection strategy of a Connection.
@@ -55,7 +67,10 @@ type Backoff struct {
 	// same time, as it makes the wait times distinct.
 	// Must be in range (0, 1); -1 = no randomization. Defaults to 0.5.
 	Jitter float64
-	// How much can the wait time grow.
+	// How much can the wait time grow. The wait time never exceeds it, jitter included.
+	// It also bounds the reconnection time sent by the server using the retry field,
+	// so a misconfigured server can't make clients wait for too long – if it is not set,
+	// the reconnection time sent by the server is bounded to 1 minute instead.
 	// If <=0 = the wait time can infinitely grow. Defaults to infinite growth.
 	MaxInterval time.Duration
 	// How much time can retries be attempted.
@@ -143,7 +158,7 @@ func mergeDefaults(c *Client) {
 	if c.Backoff.Multiplier < 1 {
 		c.Backoff.Multiplier = DefaultClient.Backoff.Multiplier
 	}
-	if c.Backoff.Jitter <= 0 || c.Backoff.Jitter >= 1 {
+	if c.Backoff.Jitter != -1 && (c.Backoff.Jitter <= 0 || c.Backoff.Jitter >= 1) {
 		c.Backoff.Jitter = DefaultClient.Backoff.Jitter
 	}
 	if c.ResponseValidator == nil {
@@ -180,11 +195,22 @@ func (b *Backoff) new() backoffController {
 	}
 }
 
+// maxServerInterval bounds the reconnection time sent by the server when Backoff.MaxInterval is not set.
+const maxServerInterval = time.Minute
+
 // reset the backoff to the initial state, i.e. as if no retries have occurred.
-// If newInterval is greater than 0, the initial interval is changed to it.
+// If newInterval is greater than 0, the initial interval is changed to it,
+// but not above the maximum interval.
 func (c *backoffController) reset(newInterval time.Duration) {
 	if newInterval > 0 {
+		maxInterval := c.b.MaxInterval
+		if maxInterval <= 0 {
+			maxInterval = maxServerInterval
+		}
 		c.interval = newInterval
+		if c.interval > maxInterval {
+			c.interval = maxInterval
+		}
 	} else {
 		c.interval = c.b.InitialInterval
 	}
@@ -200,6 +226,9 @@ func (c *backoffController) next() (interval time.Duration, shouldRetry bool) {
 	c.numRetries++
 	elapsed := time.Since(c.start)
 	next := nextInterval(c.b.Jitter, c.rng, c.interval)
+	if c.b.MaxInterval > 0 && next > c.b.MaxInterval {
+		next = c.b.MaxInterval
+	}
 	c.interval = growInterval(c.interval, c.b.MaxInterval, c.b.Multiplier)
 
 	if c.b.MaxElapsedTime > 0 && elapsed+next > c.b.MaxElapsedTime {
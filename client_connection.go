@@ -2,6 +2,7 @@ package sse
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/tmaxmax/go-sse/internal/parser"
 )
@@ -114,6 +116,12 @@ func (c *Connection) addSubscriber(event string, cb EventCallback) EventCallback
 // Use this if you need to read very large events (bigger than the default
 // of 65K bytes).
 //
+// The maximum size is also a safeguard against untrusted servers: events are
+// scanned whole, so it limits the total size of all the fields of a single event,
+// including comments and unknown fields. If the server sends an event which doesn't
+// end before the limit is reached, reading stops and the connection fails with an
+// error that wraps bufio.ErrTooLong – memory usage never grows beyond the limit.
+//
 // Read the documentation of bufio.Scanner.Buffer for more information.
 func (c *Connection) Buffer(buf []byte, maxSize int) {
 	c.buf = buf
@@ -184,10 +192,18 @@ func (c *Connection) read(r io.Reader, setRetry func(time.Duration)) error {
 	}
 
 	ev, dirty := Event{}, false
+	dataLines := 0
 
 	for f := (parser.Field{}); p.Next(&f); {
+		if c.client.RejectInvalidUTF8 && !utf8.ValidString(f.Value) {
+			return fmt.Errorf("%w: invalid %s field %q", ErrInvalidUTF8, f.Name, f.Value)
+		}
+
 		switch f.Name { //nolint:exhaustive // Comment fields are not parsed.
 		case parser.FieldNameData:
+			if dataLines++; c.client.MaxDataLines > 0 && dataLines > c.client.MaxDataLines {
+				return fmt.Errorf("%w: the limit is %d", ErrTooManyDataLines, c.client.MaxDataLines)
+			}
 			ev.Data += f.Value + "\n"
 			dirty = true
 		case parser.FieldNameEvent:
@@ -213,14 +229,21 @@ func (c *Connection) read(r io.Reader, setRetry func(time.Duration)) error {
 			dirty = true
 		default:
 			c.dispatch(ev)
+			if err := checkEndOfStream(ev); err != nil {
+				return err
+			}
 			ev = Event{}
 			dirty = false
+			dataLines = 0
 		}
 	}
 
 	err := p.Err()
 	if dirty && err == io.EOF { //nolint:errorlint // Our scanner returns io.EOF unwrapped
 		c.dispatch(ev)
+		if err := checkEndOfStream(ev); err != nil {
+			return err
+		}
 	}
 
 	return err
@@ -231,9 +254,14 @@ func (c *Connection) read(r io.Reader, setRetry func(time.Duration)) error {
 // is blocked until the request's context is done or an error occurs.
 //
 // If the request's context is cancelled, Connect returns its error.
+// If the server sends an event of type CloseEventType (see CloseMessage),
+// the event is dispatched and Connect returns nil without reconnecting.
+// If it sends an event of type ErrorEventType (see ErrorMessage), the event
+// is dispatched and Connect returns an error wrapping a *ServerError, also
+// without reconnecting.
 // Otherwise, if the maximum number or retries is made, the last error
 // that occurred is returned. Connect never returns otherwise – either
-// the context is cancelled, or it's done retrying.
+// the context is cancelled, the server closes the stream, or it's done retrying.
 //
 // All errors returned other than the context errors will be wrapped
 // inside a *ConnectionError.
@@ -294,13 +322,59 @@ func (c *Connection) doConnect(ctx context.Context, setRetry func(time.Duration)
 	setRetry(0)
 
 	err = c.read(res.Body, setRetry)
+	if errors.Is(err, errClosedByServer) {
+		return false, nil
+	}
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return false, &ConnectionError{Req: c.request, Reason: "server sent an error", Err: err}
+	}
 	if errors.Is(err, ctx.Err()) {
 		return false, err
 	}
+	if errors.Is(err, ErrInvalidUTF8) || errors.Is(err, ErrTooManyDataLines) {
+		return false, &ConnectionError{Req: c.request, Reason: "invalid stream", Err: err}
+	}
 
 	return true, &ConnectionError{Req: c.request, Reason: "connection to server lost", Err: err}
 }
 
+// errClosedByServer is returned by read when a close event is received.
+var errClosedByServer = errors.New("go-sse: stream closed by server")
+
+// ServerError is wrapped by the error returned by Connection.Connect when the server
+// sends an event of type ErrorEventType – see ErrorMessage.
+type ServerError struct {
+	// The message of the error, as sent by the server. If the event's data is not
+	// the JSON object sent by ErrorMessage, it is the data itself.
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return "go-sse: server error: " + e.Message
+}
+
+// checkEndOfStream returns the error read must return after the given event is dispatched,
+// if the event ends the stream.
+func checkEndOfStream(ev Event) error {
+	switch ev.Type {
+	case CloseEventType:
+		return errClosedByServer
+	case ErrorEventType:
+		var e jsonError
+		if err := json.Unmarshal([]byte(ev.Data), &e); err != nil {
+			return &ServerError{Message: ev.Data}
+		}
+		return &ServerError{Message: e.Message}
+	default:
+		return nil
+	}
+}
+
+// ErrInvalidUTF8 is wrapped by the errors returned by Connection.Connect when the server
+// sends fields which are not valid UTF-8 and Client.RejectInvalidUTF8 is set.
+var ErrInvalidUTF8 = errors.New("go-sse: stream is not valid UTF-8")
+
 // ErrNoGetBody is a sentinel error returned when the connection cannot be reattempted
 // due to GetBody not existing on the original request.
 var ErrNoGetBody = errors.New("the GetBody function doesn't exist on the request")
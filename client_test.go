@@ -1,6 +1,7 @@
 package sse_test
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
@@ -111,6 +112,83 @@ func TestConnection_Connect_retry(t *testing.T) {
 	tests.Expect(t, c.Backoff.InitialInterval-timeDelta <= firstReconnectionTime && firstReconnectionTime <= c.Backoff.InitialInterval+timeDelta, "reconnection time incorrectly set")
 }
 
+func TestConnection_Connect_retryBounds(t *testing.T) {
+	testErr := errors.New("done")
+
+	var intervals []time.Duration
+	c := &sse.Client{
+		HTTPClient: &http.Client{
+			Transport: roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+				return nil, testErr
+			}),
+		},
+		OnRetry: func(_ error, duration time.Duration) { intervals = append(intervals, duration) },
+		Backoff: sse.Backoff{
+			InitialInterval: time.Millisecond,
+			Multiplier:      2,
+			Jitter:          -1,
+			MaxInterval:     time.Millisecond * 4,
+			MaxRetries:      5,
+		},
+	}
+
+	tests.ErrorIs(t, c.NewConnection(req(t, "", "", http.NoBody)).Connect(), testErr, "invalid error received from Connect")
+	ms := time.Millisecond
+	tests.DeepEqual(t, intervals, []time.Duration{ms, 2 * ms, 4 * ms, 4 * ms, 4 * ms}, "intervals should grow up to the maximum")
+
+	intervals = nil
+	c.Backoff.Jitter = 0.5
+	tests.ErrorIs(t, c.NewConnection(req(t, "", "", http.NoBody)).Connect(), testErr, "invalid error received from Connect")
+	tests.Equal(t, len(intervals), 5, "connection was not retried enough times")
+	for i, interval := range intervals {
+		base := c.Backoff.MaxInterval
+		if i < 2 {
+			base = ms << i
+		}
+		tests.Expect(t, interval >= base/2 && interval <= base*3/2, "interval %d (%v) is not within the jitter of %v", i, interval, base)
+		tests.Expect(t, interval <= c.Backoff.MaxInterval, "interval %d (%v) exceeds the maximum", i, interval)
+	}
+}
+
+func TestConnection_Connect_retryFieldBound(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "retry: 3600000\ndata: hello\n\n")
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var intervals []time.Duration
+	c := &sse.Client{
+		HTTPClient: ts.Client(),
+		OnRetry: func(_ error, duration time.Duration) {
+			intervals = append(intervals, duration)
+			if len(intervals) == 3 {
+				cancel()
+			}
+		},
+		Backoff: sse.Backoff{Jitter: -1, MaxInterval: time.Millisecond * 5},
+	}
+
+	tests.ErrorIs(t, c.NewConnection(reqCtx(t, ctx, "", ts.URL, http.NoBody)).Connect(), context.Canceled, "invalid error received from Connect")
+	tests.DeepEqual(t, intervals, []time.Duration{c.Backoff.MaxInterval, c.Backoff.MaxInterval, c.Backoff.MaxInterval}, "server retry should be bounded by the maximum interval")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	intervals = nil
+	c.OnRetry = func(_ error, duration time.Duration) {
+		intervals = append(intervals, duration)
+		cancel()
+	}
+	c.Backoff.MaxInterval = 0
+
+	tests.ErrorIs(t, c.NewConnection(reqCtx(t, ctx, "", ts.URL, http.NoBody)).Connect(), context.Canceled, "invalid error received from Connect")
+	tests.DeepEqual(t, intervals, []time.Duration{time.Minute}, "server retry should be bounded by default")
+}
+
 func TestConnection_Connect_noRetryCtxErr(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ticker := time.NewTicker(time.Millisecond)
@@ -466,6 +544,55 @@ func TestConnection_dispatchDirty(t *testing.T) {
 	tests.Equal(t, got, expected, "unexpected event received")
 }
 
+func TestConnection_Connect_maxDataLines(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "data: a\ndata: b\n\ndata: c\ndata: d\ndata: e\n\n")
+	}))
+	defer ts.Close()
+
+	c := &sse.Client{HTTPClient: ts.Client(), ResponseValidator: sse.NoopValidator, MaxDataLines: 2}
+
+	var got []string
+	conn := c.NewConnection(req(t, "", ts.URL, nil))
+	conn.SubscribeMessages(func(e sse.Event) { got = append(got, e.Data) })
+
+	tests.ErrorIs(t, conn.Connect(), sse.ErrTooManyDataLines, "events with too many data lines should be rejected without retrying")
+	tests.DeepEqual(t, got, []string{"a\nb"}, "events at the limit should be dispatched")
+}
+
+func TestConnection_Connect_encoding(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "\xEF\xBB\xBFdata: with BOM\n\ndata: \xff\n\n")
+	}))
+	defer ts.Close()
+
+	c := &sse.Client{
+		HTTPClient:        ts.Client(),
+		ResponseValidator: sse.NoopValidator,
+	}
+
+	var got []string
+	subscribe := func(conn *sse.Connection) *sse.Connection {
+		got = nil
+		conn.SubscribeMessages(func(e sse.Event) { got = append(got, e.Data) })
+		return conn
+	}
+
+	c.RejectInvalidUTF8 = true
+	conn := subscribe(c.NewConnection(req(t, "", ts.URL, nil)))
+	err := conn.Connect()
+	tests.ErrorIs(t, err, sse.ErrInvalidUTF8, "invalid UTF-8 should be rejected without retrying")
+	tests.DeepEqual(t, got, []string{"with BOM"}, "BOM should be removed")
+
+	c.RejectInvalidUTF8 = false
+	c.Backoff.MaxRetries = -1
+	conn = subscribe(c.NewConnection(req(t, "", ts.URL, nil)))
+	tests.ErrorIs(t, conn.Connect(), io.EOF, "unexpected Connect error")
+	tests.DeepEqual(t, got, []string{"with BOM", "\xff"}, "invalid UTF-8 should be received as it is by default")
+}
+
 func TestConnection_Unsubscriptions(t *testing.T) {
 	evs := make(chan string)
 
@@ -629,3 +756,84 @@ func TestConnection_reconnect(t *testing.T) {
 	tests.Equal(t, err, ctx.Err(), "expected context error")
 	tests.DeepEqual(t, lastEventIDs, []string{"", "1", "2"}, "incorrect last event IDs")
 }
+
+func TestConnection_Buffer_maxSize(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		// An event which doesn't end: many data lines without a blank line.
+		line := []byte(": padding\ndata: " + strings.Repeat("a", 100) + "\n")
+		for i := 0; i < 100; i++ {
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	c := &sse.Client{HTTPClient: ts.Client(), Backoff: sse.Backoff{MaxRetries: -1}}
+	conn := c.NewConnection(req(t, "", ts.URL, http.NoBody))
+	conn.Buffer(nil, 1024)
+
+	err := conn.Connect()
+	tests.ErrorIs(t, err, bufio.ErrTooLong, "too large events should not be read")
+}
+
+func TestConnection_Connect_closeMessage(t *testing.T) {
+	t.Parallel()
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		sess, _ := sse.Upgrade(w, r)
+		m := &sse.Message{}
+		m.AppendData("hello")
+		_ = sess.Send(m)
+		// Application events named "close" must not stop the connection.
+		_ = sess.Send(sse.NewMessage().Type("close").Data("order").Build())
+		_ = sess.Send(sse.CloseMessage())
+		_ = sess.Flush()
+	}))
+	defer ts.Close()
+
+	var received []sse.Event
+	c := &sse.Client{Backoff: sse.Backoff{InitialInterval: time.Millisecond}}
+	conn := c.NewConnection(req(t, "", ts.URL, http.NoBody))
+	conn.SubscribeToAll(func(e sse.Event) { received = append(received, e) })
+
+	tests.Equal(t, conn.Connect(), nil, "close message should end the connection without error")
+	tests.Equal(t, requests, 1, "connection should not be reattempted")
+	tests.DeepEqual(t, received, []sse.Event{{Data: "hello"}, {Type: "close", Data: "order"}, {Type: sse.CloseEventType}}, "invalid events received")
+	tests.Equal(t, sse.CloseMessage().String(), "event: go-sse.close\ndata: \n\n", "invalid close message representation")
+}
+
+func TestConnection_Connect_errorMessage(t *testing.T) {
+	t.Parallel()
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		sess, _ := sse.Upgrade(w, r)
+		_ = sess.Send(sse.NewMessage().Data("partial").Build())
+		_ = sess.Send(sse.ErrorMessage(errors.New("query \"x\" failed")))
+		_ = sess.Flush()
+	}))
+	defer ts.Close()
+
+	var received []sse.Event
+	c := &sse.Client{Backoff: sse.Backoff{InitialInterval: time.Millisecond}}
+	conn := c.NewConnection(req(t, "", ts.URL, http.NoBody))
+	conn.SubscribeToAll(func(e sse.Event) { received = append(received, e) })
+
+	err := conn.Connect()
+	var serverErr *sse.ServerError
+	tests.Expect(t, errors.As(err, &serverErr), "error message should make Connect return a ServerError")
+	tests.Equal(t, serverErr.Message, `query "x" failed`, "invalid error message")
+	tests.Equal(t, requests, 1, "connection should not be reattempted")
+	tests.Equal(t, len(received), 2, "error event should be dispatched")
+	tests.Equal(t, sse.ErrorMessage(errors.New("oops")).String(), "event: go-sse.error\ndata: {\"message\":\"oops\"}\n\n", "invalid error message representation")
+	tests.Equal(t, sse.ErrorMessage(nil).String(), "event: go-sse.error\ndata: {\"message\":\"\"}\n\n", "nil error should have an empty message")
+}
@@ -13,6 +13,7 @@ type FieldParser struct {
 	started bool
 
 	keepComments bool
+	keepUnknown  bool
 	removeBOM    bool
 }
 
@@ -32,7 +33,7 @@ func trimFirstSpace(c string) string {
 
 func (f *FieldParser) scanSegment(chunk string, out *Field) bool {
 	colonPos, l := strings.IndexByte(chunk, ':'), len(chunk)
-	if colonPos > maxFieldNameLength {
+	if colonPos > maxFieldNameLength && !f.keepUnknown {
 		return false
 	}
 	if colonPos == -1 {
@@ -55,6 +56,10 @@ func (f *FieldParser) scanSegment(chunk string, out *Field) bool {
 		out.Name = FieldNameComment
 		out.Value = trimFirstSpace(chunk[min(1, l):])
 		return true
+	} else if colonPos != 0 && f.keepUnknown {
+		out.Name = FieldName(chunk[:colonPos])
+		out.Value = trimFirstSpace(chunk[min(colonPos+1, l):])
+		return true
 	}
 
 	return false
@@ -113,6 +118,12 @@ func (f *FieldParser) KeepComments(shouldKeep bool) {
 	f.keepComments = shouldKeep
 }
 
+// KeepUnknownFields configures the FieldParser to parse/ignore fields with unknown names.
+// The returned unknown fields have their name as is. By default unknown fields are ignored.
+func (f *FieldParser) KeepUnknownFields(shouldKeep bool) {
+	f.keepUnknown = shouldKeep
+}
+
 // RemoveBOM configures the FieldParser to try and remove the Unicode BOM
 // when parsing the first field, if it exists.
 // If, at the time this option is set, the input is untouched (no fields were parsed),
@@ -30,6 +30,7 @@ func TestFieldParser(t *testing.T) {
 		data         string
 		expected     []parser.Field
 		keepComments bool
+		keepUnknown  bool
 	}
 
 	tests := []testCase{
@@ -88,6 +89,17 @@ func TestFieldParser(t *testing.T) {
 				newEventField(t, "test"),
 			},
 		},
+		{
+			name:        "Unknown fields",
+			data:        "trace-id: abc\ndata: hello\nflag\n: comm\n\n",
+			keepUnknown: true,
+			expected: []parser.Field{
+				{Name: "trace-id", Value: "abc"},
+				newDataField(t, "hello"),
+				{Name: "flag"},
+				{},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -98,6 +110,7 @@ func TestFieldParser(t *testing.T) {
 
 			p := parser.NewFieldParser(test.data)
 			p.KeepComments(test.keepComments)
+			p.KeepUnknownFields(test.keepUnknown)
 
 			var segments []parser.Field
 
@@ -6,10 +6,10 @@ import (
 	"unsafe"
 )
 
-// splitFunc is a split function for a bufio.Scanner that splits a sequence of
+// SplitFunc is a split function for a bufio.Scanner that splits a sequence of
 // bytes into SSE events. Each event ends with two consecutive newline sequences,
 // where a newline sequence is defined as either "\n", "\r", or "\r\n".
-func splitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
+func SplitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if len(data) == 0 {
 		return 0, nil, nil
 	}
@@ -108,7 +108,7 @@ func (r *Parser) Buffer(buf []byte, max int) {
 // New returns a Parser that extracts fields from a reader.
 func New(r io.Reader) *Parser {
 	sc := bufio.NewScanner(r)
-	sc.Split(splitFunc)
+	sc.Split(SplitFunc)
 
 	fsc := NewFieldParser("")
 	fsc.RemoveBOM(true)
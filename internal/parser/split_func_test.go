@@ -47,7 +47,7 @@ func TestSplitFunc(t *testing.T) {
 
 			r := strings.NewReader(tc.input)
 			s := bufio.NewScanner(r)
-			s.Split(splitFunc)
+			s.Split(SplitFunc)
 
 			tokens := make([]string, 0, len(tc.expected))
 
@@ -3,9 +3,13 @@ package sse
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"math"
 	"runtime/debug"
+	"sort"
 	"sync"
+	"time"
 )
 
 // A ReplayProvider is a type that can replay older published events to new subscribers.
@@ -70,33 +74,232 @@ type (
 	messageWithTopics struct {
 		message *Message
 		topics  []string
+		// cursor is the position of the message in a replay provider's buffer, see RangeFromCursor.
+		cursor int64
+	}
+
+	joeMessage struct {
+		// delivered is closed after the message is sent to all subscribers.
+		// It is nil if the publisher doesn't wait for the delivery.
+		delivered chan struct{}
+		messageWithTopics
+	}
+
+	joeSubscription struct {
+		// limiter is nil if the subscription has no rate limit.
+		limiter *rateLimiter
+		// resumeFrom is the ID of the last message published before the subscriber was paused.
+		resumeFrom EventID
+		// lastSent is the ID of the last message with an ID sent to the subscriber.
+		lastSent EventID
+		Subscription
+		paused bool
 	}
 )
 
+// rateLimiter is a token bucket which refills at a constant rate up to its burst size.
+type rateLimiter struct {
+	last   time.Time
+	rate   float64
+	burst  float64
+	tokens float64
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// allow takes a token from the bucket, if there is any.
+func (r *rateLimiter) allow(now time.Time) bool {
+	r.tokens = math.Min(r.burst, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	r.last = now
+
+	if r.tokens < 1 {
+		return false
+	}
+
+	r.tokens--
+	return true
+}
+
+// A Tracer traces the operations of a provider, for example by adapting an OpenTelemetry tracer.
+//
+// Joe creates the following spans, from his goroutine:
+//
+//   - "sse.publish", around sending a message to the subscribers. It starts with the "topics" attribute,
+//     of type []string, and ends with the "subscribers" attribute, of type int – the number of subscribers
+//     the message was sent to –, and with the "failed" attribute, of type int – the number of subscribers
+//     removed because sending failed.
+//   - "sse.replay", around replaying messages to a new subscriber. It starts with the "topics" and
+//     "lastEventID" attributes, the latter of type sse.EventID, and ends with the "err" attribute,
+//     of type error, which is nil if the replay succeeded.
+//
+// The attribute maps must not be retained after the methods return.
+type Tracer interface {
+	// StartSpan starts a span with the given name and attributes. The returned function
+	// is called with the final attributes of the span to end it.
+	StartSpan(name string, attributes map[string]any) (end func(attributes map[string]any))
+}
+
 // Joe is a basic server provider that synchronously executes operations by queueing them in channels.
 // Events are also sent synchronously to subscribers, so if a subscriber's callback blocks, the others
 // have to wait.
 //
 // Joe optionally supports event replaying with the help of a replay provider.
+// He also enforces the subscriptions' rate limits, if they are set: messages over
+// a subscriber's limit are not sent to it, without affecting the other subscribers.
 //
-// If the replay provider panics, the subscription for which it panicked is considered failed
-// and an error is returned, and thereafter the replay provider is not used anymore – no replays
-// will be attempted for future subscriptions.
+// If the replay provider panics while replaying, the subscription for which it panicked is considered
+// failed and ErrReplayPanicked is returned; the other subscribers are not affected. If it panics while
+// a message is put, the replay provider is not used anymore – no replays will be attempted for future
+// subscriptions, until another replay provider is set.
 // If due to some other unexpected scenario something panics internally, Joe will remove all subscribers
 // and close itself, so subscribers don't end up blocked.
 //
 // He serves simple use-cases well, as he's light on resources, and does not require any external
 // services. Also, he is the default provider for Servers.
 type Joe struct {
-	message        chan messageWithTopics
+	message        chan joeMessage
+	urgent         chan joeMessage
 	subscription   chan subscription
 	unsubscription chan subscriber
+	replayProvider chan ReplayProvider
+	direct         chan joeDirectMessage
+	pause          chan joePause
+	replayRequest  chan joeReplay
+	topics         chan chan []string
+	export         chan chan []SubscriberState
+	closeTopic     chan joeCloseTopic
+	hasSubscribers chan joeHasSubscribers
+	replayed       chan joeReplayed
 	done           chan struct{}
 	closed         chan struct{}
-	subscribers    map[subscriber]Subscription
+	subscribers    map[subscriber]joeSubscription
+	// byID holds the subscribers which have a subscription ID.
+	byID map[string][]subscriber
+	// replays holds the subscribers which are being replayed asynchronously.
+	replays map[subscriber]*joeAsyncReplay
+	// retained holds the last message published to each retained topic.
+	retained map[string]retainedMessage
+	// published counts the retained messages, to order them.
+	published uint64
 
 	// An optional replay provider that Joe uses to resend older messages to new subscribers.
+	// Use SetReplayProvider to change it after Joe is used.
+	//
+	// If the provider panics while replaying to a subscriber, the panic is logged and only that
+	// subscriber is removed: its Subscribe call returns an error wrapping ErrReplayPanicked.
+	// If it panics while a message is put, Joe stops using it until SetReplayProvider is called.
 	ReplayProvider ReplayProvider
+	// The default maximum duration of sending a message to a subscriber. If sending takes longer,
+	// the subscriber is removed and the write error is returned by Subscribe.
+	// Subscriptions can override it using their SendTimeout field. If <=0, there is no timeout.
+	//
+	// The timeout is enforced only for clients which implement a
+	//	SetWriteDeadline(time.Time) error
+	// method, such as Session. The deadline is removed after the message is sent.
+	// Messages replayed to new subscribers are also subject to the timeout: the deadline
+	// is renewed before each replayed message.
+	SendTimeout time.Duration
+	// The maximum duration Subscribe waits for Joe to accept the subscription.
+	// If Joe is too busy to accept it in time, Subscribe returns ErrSubscribeTimeout.
+	// If <=0, Subscribe waits until the subscription is accepted or Joe is stopped.
+	SubscribeTimeout time.Duration
+	// By default, messages which have only comment fields, such as keep-alive heartbeats,
+	// are not put into the replay provider – they carry no data and have no ID, so they
+	// would only cause valid events to be evicted sooner. Set this to true to replay them, too.
+	ReplayHeartbeats bool
+	// If AsyncReplay is set, the messages are replayed to new subscribers from a separate goroutine,
+	// so a large replay or a slow client doesn't delay sending the messages to all the other subscribers.
+	// Joe takes a snapshot of the messages to replay, by calling the replay provider from his goroutine,
+	// as usual, and then sends them in the background. The messages published meanwhile are buffered
+	// for the new subscriber and sent after the replayed ones and the ReplayDone message, in the order
+	// they were published, so it receives every message once and in order, just like without AsyncReplay.
+	// The buffer is not limited, so use a SendTimeout to bound how long a replay can take.
+	//
+	// The subscriber is added only after it is replayed: until then, it doesn't receive messages sent
+	// with SendTo, it can't be paused and it isn't included by Topics, HasSubscribers, CloseTopic or
+	// ExportSubscribers, and OnSubscribe is not called. Subscribers for which there is nothing to replay
+	// are added right away.
+	AsyncReplay bool
+	// If DeliveryTimeout is set, Publish returns only after the message is sent to all
+	// the subscribers, which applies backpressure: publishers are slowed down to the pace
+	// of the slowest subscriber, instead of publishing faster than the messages are sent.
+	// If the message isn't received and sent to all subscribers within the timeout,
+	// Publish returns ErrDeliveryTimeout – the message is still sent to the remaining
+	// subscribers afterwards. Use SendTimeout together with it, so a stuck subscriber
+	// is removed instead of blocking all publishers.
+	//
+	// By default, Publish returns as soon as Joe receives the message.
+	DeliveryTimeout time.Duration
+	// QueueSize is the number of published messages Joe queues while he is busy. By default
+	// messages are not queued, so Publish waits for Joe to receive each message. With a queue,
+	// publishers are not slowed down by short bursts, at the cost of memory and of messages
+	// being sent later – and lost, if they are still queued when Joe is stopped.
+	// QueueFull tells what Publish does when the queue is full.
+	QueueSize int
+	// QueueFull is the policy applied when the publish queue is full. It has effect only if
+	// QueueSize is set; by default Publish waits until there is room in the queue.
+	QueueFull OverflowPolicy
+	// If AllowTopic is set, Subscribe returns an error wrapping ErrUnknownTopic for subscriptions
+	// to any topic for which it returns false, so clients which subscribe to a mistyped topic
+	// fail instead of never receiving anything. Subscriptions to all topics are always allowed.
+	// Publish is not affected. By default any topic is allowed.
+	AllowTopic func(topic string) bool
+	// The maximum number of subscribers to each topic, so a single topic can't take all the resources
+	// of a server shared by many. Subscriptions to a topic which has this many subscribers fail with
+	// an error wrapping ErrTopicFull. A subscription to multiple topics is rejected entirely if any
+	// of them is full – it is never subscribed only to some of its topics. Subscribers to all topics
+	// are neither limited nor counted, and subscribers which are replayed asynchronously are counted.
+	// If <=0, there is no limit.
+	MaxSubscribersPerTopic int
+	// If Tracer is not nil, Joe traces sending the messages and replaying. See the Tracer
+	// documentation for the spans created. By default nothing is traced.
+	Tracer Tracer
+	// OnSubscribe and OnUnsubscribe, if set, are called when a subscriber is added to Joe,
+	// after the replay, and when it is removed – because it unsubscribed, sending to it failed or
+	// Joe is shut down. They are called for each subscriber exactly once and in this order,
+	// so they can be used, for example, to keep track of the connected clients.
+	//
+	// The callbacks are called from Joe's goroutine, so no messages are sent while they run.
+	// Make sure they are fast and don't call Joe's methods, as that would block forever.
+	OnSubscribe   func(sub Subscription)
+	OnUnsubscribe func(sub Subscription)
+	// If IDFunc is set, Joe sets the ID of each published message to the one it returns,
+	// before putting the message into the replay provider and sending it. This way messages
+	// have IDs regardless of the replay provider – for example, sequential IDs, so clients
+	// can always tell where they stopped. The function receives a copy of the message,
+	// including the ID it was published with, if any; return it to keep the ID.
+	// If the replay provider also sets IDs automatically, its IDs take precedence.
+	// Messages which have only comments, such as heartbeats, are not given an ID.
+	//
+	// IDFunc is called from Joe's goroutine, in the order the messages are sent.
+	IDFunc func(message *Message) EventID
+	// If Retain is set, Joe keeps the last message published to each topic for which it returns
+	// true and sends it to new subscribers to that topic, so they receive the current state right
+	// away – like MQTT's retained messages. The retained messages are sent only if the replay
+	// provider replays nothing, which is the case for clients without a valid last event ID,
+	// in the order they were published and before the subscription's ReplayDone message.
+	// Subscribers to all topics receive the messages retained for all the topics.
+	// Messages which have only comments, such as heartbeats, and messages sent with SendTo
+	// are not retained. Use a function which always returns true to retain all topics.
+	//
+	// Retain is called from Joe's goroutine, for each topic of each published message.
+	Retain func(topic string) bool
+	// If Goodbye is set, Joe sends it to all the subscribers, including the paused ones, when he is
+	// stopped, right before removing them – for example, a message with a "server-closing" event type,
+	// so clients can back off before reconnecting, instead of retrying right away after the stream ends.
+	// It is sent best-effort: errors are ignored, as the subscribers are removed anyway. The message
+	// must not be modified after Joe is used.
+	Goodbye *Message
+	// The maximum duration of sending the Goodbye message to all the subscribers, so a slow client
+	// doesn't delay the shutdown. As with SendTimeout, it is enforced only for clients which can
+	// set a write deadline, such as Session. Defaults to one second.
+	GoodbyeTimeout time.Duration
 
 	initDone sync.Once
 }
@@ -104,48 +307,597 @@ type Joe struct {
 // Subscribe tells Joe to send new messages to this subscriber. The subscription
 // is automatically removed when the context is done, a callback error occurs
 // or Joe is stopped.
+//
+// If the context is done, Subscribe returns nil only after Joe removed the subscriber,
+// so no messages are sent to it afterwards. If at the same time a message fails to be
+// sent, the error is returned instead. Either way, the subscriber is removed exactly once.
+// The same happens when the subscription's TTL elapses, if it has one.
+//
+// If the subscription is not accepted within the SubscribeTimeout,
+// ErrSubscribeTimeout is returned. If Joe is stopped, ErrProviderClosed
+// is returned, regardless of the timeout – also if the subscriber was already
+// added, so it can be told apart from unsubscribing. Subscriptions to topics
+// which are not allowed by AllowTopic fail immediately with ErrUnknownTopic.
 func (j *Joe) Subscribe(ctx context.Context, sub Subscription) error {
 	j.init()
 
-	done := make(chan error, 1)
+	done, err := j.subscribe(sub)
+	if err != nil {
+		return err
+	}
+
+	return j.wait(ctx, done, sub.TTL)
+}
+
+// Stream subscribes to the given topics, or to the DefaultTopic if none are given,
+// and returns a channel on which the messages are received. It is a convenience over
+// Subscribe for code which consumes messages in the same process, for example to
+// forward them elsewhere.
+//
+// The returned channel is closed by Joe, exactly once, when the context is done, Joe is stopped
+// or the subscription fails – the caller must not close it. Joe waits for each message
+// to be received, just like it waits for any other client, so receive from the channel
+// until it is closed or cancel the context. The messages must not be modified.
+//
+// Stream returns the same errors as Subscribe does before the subscriber is added.
+// Nothing is replayed, as there is no last event ID – use Subscribe to resume streams.
+func (j *Joe) Stream(ctx context.Context, topics ...string) (<-chan *Message, error) {
+	j.init()
+
+	ch := make(chan *Message)
+	done, err := j.subscribe(Subscription{Client: chanClient{ctx: ctx, ch: ch}, Topics: getTopics(topics)})
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer close(ch)
+		_ = j.wait(ctx, done, 0)
+	}()
+
+	return ch, nil
+}
+
+// chanClient sends the messages on a channel, until the context is done.
+type chanClient struct {
+	ctx context.Context
+	ch  chan<- *Message
+}
 
+func (c chanClient) Send(m *Message) error {
 	select {
-	case <-j.done:
-		return ErrProviderClosed
-	case j.subscription <- subscription{done: done, Subscription: sub}:
+	case c.ch <- m:
+		return nil
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+func (c chanClient) Flush() error { return nil }
+
+// subscribe checks the subscription's topics and registers it,
+// returning the channel on which its removal is signaled.
+func (j *Joe) subscribe(sub Subscription) (chan error, error) {
+	if j.AllowTopic != nil && !sub.AllTopics {
+		for _, topic := range sub.Topics {
+			if !j.AllowTopic(topic) {
+				return nil, fmt.Errorf("%w: %q", ErrUnknownTopic, topic)
+			}
+		}
+	}
+
+	done := make(chan error, 1)
+
+	if err := j.register(subscription{done: done, Subscription: sub}); err != nil {
+		return nil, err
+	}
+
+	return done, nil
+}
+
+// wait waits for the subscriber to be removed, unsubscribing it when the context is done.
+func (j *Joe) wait(ctx context.Context, done chan error, ttl time.Duration) error {
+	var expired <-chan time.Time
+	if ttl > 0 {
+		t := time.NewTimer(ttl)
+		defer t.Stop()
+		expired = t.C
 	}
 
 	select {
 	case err := <-done:
 		return err
 	case <-ctx.Done():
+	case <-expired:
 	}
 
 	select {
 	case err := <-done:
 		return err
 	case j.unsubscription <- done:
+		// The subscriber may be removed later, if it is replayed asynchronously.
+		<-done
 		return nil
 	}
 }
 
+func (j *Joe) register(sub subscription) error {
+	select {
+	case <-j.done:
+		return ErrProviderClosed
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if j.SubscribeTimeout > 0 {
+		t := time.NewTimer(j.SubscribeTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case <-j.done:
+		return ErrProviderClosed
+	case j.subscription <- sub:
+		return nil
+	case <-timeout:
+		return ErrSubscribeTimeout
+	}
+}
+
+// ErrSubscribeTimeout is returned by Joe.Subscribe when the subscription
+// is not accepted within the configured timeout.
+var ErrSubscribeTimeout = errors.New("go-sse.server: subscription timed out")
+
+// ErrUnknownTopic is returned by Joe.Subscribe when the subscription
+// has a topic which is not allowed.
+var ErrUnknownTopic = errors.New("go-sse.server: unknown topic")
+
+// ErrTopicFull is returned by Joe.Subscribe when the subscription has a topic
+// which already has the maximum number of subscribers. See Joe.MaxSubscribersPerTopic.
+var ErrTopicFull = errors.New("go-sse.server: topic has too many subscribers")
+
 // Publish tells Joe to send the given message to the subscribers.
 // When a message is published to multiple topics, Joe makes sure to
 // not send the Message multiple times to clients that are subscribed
 // to more than one topic that receive the given Message. Every client
 // receives each unique message once, regardless of how many topics it
 // is subscribed to or to how many topics the message is published.
+// The replay provider also receives all the topics, so the message is replayed
+// to new subscribers of any of them – again, only once.
+//
+// Publish is safe to call concurrently from multiple goroutines. It returns only after
+// Joe has received the message – or queued it, if QueueSize is set. If DeliveryTimeout is set,
+// Publish also waits for the message to be sent to all the subscribers – if the message is
+// dropped from a full queue, ErrDeliveryTimeout is returned after the timeout.
+//
+// Joe sends each message to all the subscribers before the next one, in the order he takes
+// them from his queues, which are first-in, first-out. This guarantees the following order:
+//   - the messages published by the same goroutine using Publish or PublishFunc are sent to each
+//     subscriber in the order they were published, with or without a publish queue. The same holds
+//     for the messages published by the same goroutine using PublishUrgent;
+//   - an urgent message can be sent before the messages published before it with Publish, even by
+//     the same goroutine, if they are still queued – see PublishUrgent;
+//   - messages can be missing, because they were dropped from a full queue (see QueueFull) or
+//     because of a subscriber's RateLimit, but the ones which are sent keep their order. Subscribers
+//     replayed asynchronously (see AsyncReplay) receive the messages published meanwhile in order too;
+//   - messages published concurrently from different goroutines are interleaved in no particular order.
+//
+// There is no sequence number per publisher: if clients must detect reordering across publishers,
+// number the messages yourself, for example using a non-standard field (see Message.SetField).
 func (j *Joe) Publish(msg *Message, topics []string) error {
+	return j.publish(msg, topics, false)
+}
+
+// PublishUrgent publishes the message just like Publish does, but the message jumps ahead
+// of the queued messages, if QueueSize is set: Joe sends it right after he is done with
+// the message he is sending, if any. This ensures control messages, such as a notice
+// that the server shuts down, are not delayed by a backlog of stale updates.
+//
+// Urgent messages have their own queue, of the same size. They are never dropped, regardless
+// of QueueFull – PublishUrgent waits for room in their queue instead – and Joe sends them
+// in the order they are published, but before the messages published before them which are
+// still queued, so use them sparingly. Sending to each subscriber
+// is not affected: Joe sends each message to all the subscribers before the next one.
+// Without a publish queue, PublishUrgent is the same as Publish.
+func (j *Joe) PublishUrgent(msg *Message, topics []string) error {
+	return j.publish(msg, topics, true)
+}
+
+// PublishFunc publishes the message returned by build just like Publish does, but only if
+// any of the topics has subscribers – see HasSubscribers. This way messages which are expensive
+// to compute are not built if nobody would receive them; if so, PublishFunc returns nil without
+// calling build.
+//
+// The check is a best-effort optimization: a subscriber can be added or removed after it and
+// before the message is sent, so new subscribers can miss the message or it can be sent to no one.
+// Skipped messages are not put into the replay provider either, so don't use PublishFunc for
+// messages which must be replayed to subscribers added later.
+func (j *Joe) PublishFunc(build func() *Message, topics []string) error {
 	if len(topics) == 0 {
 		return ErrNoTopic
 	}
 
+	ok, err := j.anySubscribed(topics)
+	if err != nil || !ok {
+		return err
+	}
+
+	return j.Publish(build(), topics)
+}
+
+type joeHasSubscribers struct {
+	result chan bool
+	topics []string
+}
+
+// HasSubscribers reports whether any subscriber would receive a message published to the topic,
+// including subscribers to all topics and the paused ones, which can be replayed the message when
+// they are resumed. The answer may be outdated by the time it is used, as subscribers can come
+// and go meanwhile – see PublishFunc. It returns false after Joe is stopped.
+func (j *Joe) HasSubscribers(topic string) bool {
+	ok, _ := j.anySubscribed([]string{topic})
+	return ok
+}
+
+func (j *Joe) anySubscribed(topics []string) (bool, error) {
 	j.init()
 
+	m := joeHasSubscribers{topics: topics, result: make(chan bool, 1)}
+
+	select {
+	case j.hasSubscribers <- m:
+	case <-j.done:
+		return false, ErrProviderClosed
+	}
+
+	select {
+	case ok := <-m.result:
+		return ok, nil
+	case <-j.closed:
+		return false, ErrProviderClosed
+	}
+}
+
+func (j *Joe) publish(msg *Message, topics []string, urgent bool) error {
+	if len(topics) == 0 {
+		return ErrNoTopic
+	}
+
+	j.init()
+
+	m := joeMessage{messageWithTopics: messageWithTopics{message: msg, topics: topics}}
+
+	var timeout <-chan time.Time
+	if j.DeliveryTimeout > 0 {
+		m.delivered = make(chan struct{})
+
+		t := time.NewTimer(j.DeliveryTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	if err := j.enqueue(m, urgent, timeout); err != nil {
+		return err
+	}
+
+	if m.delivered == nil {
+		return nil
+	}
+
+	// Joe is closed only after he's done sending the message,
+	// so waiting on closed ensures Publish doesn't block if Joe panics.
+	select {
+	case <-m.delivered:
+		return nil
+	case <-j.closed:
+		return ErrProviderClosed
+	case <-timeout:
+		return ErrDeliveryTimeout
+	}
+}
+
+// OverflowPolicy tells Joe what to do with the messages published while the publish queue
+// is full. See Joe.QueueSize.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Publish wait until there is room in the queue, so no message is lost,
+	// but publishers are slowed down to Joe's pace.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest queued message to make room for the published one,
+	// so Publish never waits. Use it for messages where only the latest ones matter, such as
+	// telemetry – the subscribers miss the dropped messages, which are not replayed either.
+	OverflowDropOldest
+	// OverflowDropNewest makes Publish return ErrQueueFull immediately,
+	// so the caller can drop the message or handle the error otherwise.
+	OverflowDropNewest
+)
+
+// ErrQueueFull is returned by Joe.Publish when the publish queue is full
+// and the overflow policy is OverflowDropNewest.
+var ErrQueueFull = errors.New("go-sse.server: publish queue is full")
+
+// enqueue sends the message to Joe's goroutine. Messages which are not urgent are subject
+// to the overflow policy.
+func (j *Joe) enqueue(m joeMessage, urgent bool, timeout <-chan time.Time) error {
+	queue := j.message
+	if urgent {
+		queue = j.urgent
+	} else if j.QueueSize > 0 && j.QueueFull != OverflowBlock {
+		for {
+			select {
+			case j.message <- m:
+				return nil
+			case <-j.done:
+				return ErrProviderClosed
+			default:
+			}
+
+			if j.QueueFull == OverflowDropNewest {
+				return ErrQueueFull
+			}
+
+			// Make room by receiving the oldest message. Joe might receive it first,
+			// in which case there is room already.
+			select {
+			case <-j.message:
+			default:
+			}
+		}
+	}
+
 	// Waiting on done ensures Publish doesn't block the caller goroutine
 	// when Joe is stopped and implements the required Provider behavior.
 	select {
-	case j.message <- messageWithTopics{message: msg, topics: topics}:
+	case queue <- m:
+		return nil
+	case <-j.done:
+		return ErrProviderClosed
+	case <-timeout:
+		return ErrDeliveryTimeout
+	}
+}
+
+type joeDirectMessage struct {
+	message *Message
+	result  chan error
+	id      string
+}
+
+// SendTo sends the message only to the subscribers whose subscription has the given ID,
+// regardless of their topics – see Subscription.ID. It returns ErrSubscriberNotFound if there
+// is no such subscriber. Otherwise, it returns after the message was sent; subscribers to which
+// sending fails are removed, just like when publishing.
+//
+// Messages sent this way are not put into the replay provider and are not rate limited.
+func (j *Joe) SendTo(id string, msg *Message) error {
+	j.init()
+
+	m := joeDirectMessage{id: id, message: msg, result: make(chan error, 1)}
+
+	select {
+	case j.direct <- m:
+	case <-j.done:
+		return ErrProviderClosed
+	}
+
+	select {
+	case err := <-m.result:
+		return err
+	case <-j.closed:
+		return ErrProviderClosed
+	}
+}
+
+type joePause struct {
+	result chan error
+	id     string
+	paused bool
+}
+
+// Pause stops sending messages to the subscribers whose subscription has the given ID,
+// without removing them – for example, while the user doesn't look at the page. Resume
+// sends them the messages they missed in the meantime. Messages sent with SendTo to paused
+// subscribers are not received. Pausing a paused subscriber does nothing.
+//
+// Pause returns ErrSubscriberNotFound if there are no subscribers with the ID.
+func (j *Joe) Pause(id string) error {
+	return j.setPause(id, true)
+}
+
+// Resume resumes sending messages to the subscribers whose subscription has the given ID.
+// First, the messages published while they were paused are replayed, using the replay provider:
+// Joe keeps only the ID of the last message published before each subscriber was paused, as its
+// last event ID, so only the messages which are still buffered can be received. If Joe has no replay
+// provider or no message with an ID was published before pausing, nothing is replayed. Resuming
+// a subscriber which is not paused does nothing.
+//
+// Resume returns ErrSubscriberNotFound if there are no subscribers with the ID.
+func (j *Joe) Resume(id string) error {
+	return j.setPause(id, false)
+}
+
+func (j *Joe) setPause(id string, paused bool) error {
+	j.init()
+
+	m := joePause{id: id, paused: paused, result: make(chan error, 1)}
+
+	select {
+	case j.pause <- m:
+	case <-j.done:
+		return ErrProviderClosed
+	}
+
+	select {
+	case err := <-m.result:
+		return err
+	case <-j.closed:
+		return ErrProviderClosed
+	}
+}
+
+type joeReplay struct {
+	result      chan error
+	id          string
+	lastEventID EventID
+}
+
+// ReplayTo replays the messages published after the given last event ID to the subscribers
+// whose subscription has the given ID – for example, when a client detects it missed messages
+// and asks for them again without reconnecting. The messages are replayed by Joe's goroutine,
+// so they are not interleaved with the messages being published. Paused subscribers are skipped.
+// Subscribers to which replaying fails are removed, just like when publishing.
+//
+// The replay provider decides what is replayed, exactly like for new subscribers: if the ID
+// is not buffered anymore, usually nothing is replayed. ReplayTo returns ErrSubscriberNotFound
+// if there are no subscribers with the ID.
+func (j *Joe) ReplayTo(id string, lastEventID EventID) error {
+	j.init()
+
+	m := joeReplay{id: id, lastEventID: lastEventID, result: make(chan error, 1)}
+
+	select {
+	case j.replayRequest <- m:
+	case <-j.done:
+		return ErrProviderClosed
+	}
+
+	select {
+	case err := <-m.result:
+		return err
+	case <-j.closed:
+		return ErrProviderClosed
+	}
+}
+
+// Topics returns, sorted, the topics which have at least one subscriber, including
+// the paused ones. Subscribers to all topics don't add any topic, as they subscribe
+// to no topic in particular. After Joe is stopped, no topics are returned.
+func (j *Joe) Topics() []string {
+	j.init()
+
+	result := make(chan []string, 1)
+
+	select {
+	case j.topics <- result:
+	case <-j.done:
+		return nil
+	}
+
+	select {
+	case topics := <-result:
+		return topics
+	case <-j.closed:
+		return nil
+	}
+}
+
+// SubscriberState is the state of a subscriber, as exported by Joe.ExportSubscribers.
+type SubscriberState struct {
+	// The ID of the subscription. See Subscription.ID.
+	ID string
+	// The topics the subscriber is subscribed to.
+	Topics []string
+	// LastEventID is the ID of the last message with an ID sent or replayed to the subscriber,
+	// or its subscription's last event ID, if no such message was sent yet.
+	LastEventID EventID
+	// Whether the subscriber is subscribed to all topics.
+	AllTopics bool
+	// Whether the subscriber is paused. See Joe.Pause.
+	Paused bool
+}
+
+// ExportSubscribers returns the state of the current subscribers, for example to hand them off
+// to another server during a rolling deploy. It's a building block: the connections themselves
+// can't be moved, so the clients must reconnect to the new server. Clients such as browsers
+// send the ID of the last event they received in the Last-Event-ID header when reconnecting,
+// so the new server can resume them where they stopped if its replay provider has the messages
+// published after that ID – seed them using FiniteReplayProvider.Seed or ValidReplayProvider.Seed,
+// for example. The exported last event IDs tell where each subscriber is expected to resume,
+// which is useful for clients which don't keep the ID themselves and to check that the new
+// replay provider has all the messages needed. After Joe is stopped, nothing is returned.
+func (j *Joe) ExportSubscribers() []SubscriberState {
+	j.init()
+
+	result := make(chan []SubscriberState, 1)
+
+	select {
+	case j.export <- result:
+	case <-j.done:
+		return nil
+	}
+
+	select {
+	case states := <-result:
+		return states
+	case <-j.closed:
+		return nil
+	}
+}
+
+type joeCloseTopic struct {
+	result chan int
+	topic  string
+}
+
+// CloseTopic removes the subscribers to the given topic, for example to disconnect everyone using
+// a feature during its maintenance, and returns how many were removed. Their Subscribe calls return
+// ErrTopicClosed. Subscribers to multiple topics are removed entirely, not only from the given topic,
+// as a subscription's topics can't be changed; subscribers to all topics are not removed. The topic
+// is not closed for new subscribers – reject them using AllowTopic, if needed.
+func (j *Joe) CloseTopic(topic string) (int, error) {
+	j.init()
+
+	m := joeCloseTopic{topic: topic, result: make(chan int, 1)}
+
+	select {
+	case j.closeTopic <- m:
+	case <-j.done:
+		return 0, ErrProviderClosed
+	}
+
+	select {
+	case n := <-m.result:
+		return n, nil
+	case <-j.closed:
+		return 0, ErrProviderClosed
+	}
+}
+
+// ErrTopicClosed is returned by Joe.Subscribe when the subscriber is removed by Joe.CloseTopic.
+var ErrTopicClosed = errors.New("go-sse.server: topic closed")
+
+// ErrSubscriberNotFound is returned by Joe.SendTo, Joe.Pause, Joe.Resume and Joe.ReplayTo
+// when there is no subscriber with the given ID.
+var ErrSubscriberNotFound = errors.New("go-sse.server: subscriber not found")
+
+// ErrDeliveryTimeout is returned by Joe.Publish when the message
+// is not sent to all the subscribers within the configured timeout.
+var ErrDeliveryTimeout = errors.New("go-sse.server: message delivery timed out")
+
+// ErrReplayPanicked is wrapped by the error Subscribe returns when the replay provider
+// panics while replaying the messages to the subscriber.
+var ErrReplayPanicked = errors.New("go-sse.server: replay panicked")
+
+// SetReplayProvider replaces the replay provider Joe uses, for example to discard
+// the buffered messages. The subscribers are not affected. Joe swaps the providers between
+// operations, so messages published before SetReplayProvider is called are put into the
+// old provider, and subscribers registered before it are replayed messages from the old
+// provider – replays for new subscribers always use the provider which is current
+// when Joe accepts their subscription. A nil provider disables replaying.
+//
+// If replaying was disabled because the previous provider panicked, it is enabled again.
+// Calling SetReplayProvider doesn't change the ReplayProvider field.
+func (j *Joe) SetReplayProvider(p ReplayProvider) error {
+	j.init()
+
+	if p == nil {
+		p = noopReplayProvider{}
+	}
+
+	select {
+	case j.replayProvider <- p:
 		return nil
 	case <-j.done:
 		return ErrProviderClosed
@@ -153,7 +905,7 @@ func (j *Joe) Publish(msg *Message, topics []string) error {
 }
 
 // Stop signals Joe to close all subscribers and stop receiving messages.
-// It returns when all the subscribers are closed.
+// It returns when all the subscribers are closed, after they are sent the Goodbye message, if set.
 //
 // Further calls to Stop will return ErrProviderClosed.
 func (j *Joe) Shutdown(ctx context.Context) (err error) {
@@ -177,8 +929,302 @@ func (j *Joe) Shutdown(ctx context.Context) (err error) {
 }
 
 func (j *Joe) removeSubscriber(sub subscriber) {
+	js, ok := j.subscribers[sub]
+	if !ok {
+		// The subscriber was already removed, for example because sending to it
+		// failed right before it unsubscribed.
+		return
+	}
 	delete(j.subscribers, sub)
 	close(sub)
+
+	if id := js.ID; id != "" {
+		subs := j.byID[id]
+		for i := range subs {
+			if subs[i] == sub {
+				subs[i] = subs[len(subs)-1]
+				subs = subs[:len(subs)-1]
+				break
+			}
+		}
+
+		if len(subs) == 0 {
+			delete(j.byID, id)
+		} else {
+			j.byID[id] = subs
+		}
+	}
+
+	if j.OnUnsubscribe != nil {
+		j.OnUnsubscribe(js.Subscription)
+	}
+}
+
+// dispatch puts the message into the replay provider and sends it to the subscribers.
+func (j *Joe) dispatch(msg joeMessage, replay ReplayProvider, canReplay *bool, lastID *EventID) {
+	if j.IDFunc != nil && !msg.message.isHeartbeat() {
+		msg.message = msg.message.Clone()
+		msg.message.ID = j.IDFunc(msg.message)
+	}
+
+	toDispatch := msg.message
+	if *canReplay && (j.ReplayHeartbeats || !msg.message.isHeartbeat()) {
+		toDispatch = tryPut(msg.messageWithTopics, replay, canReplay)
+	}
+
+	var endSpan func(map[string]any)
+	if j.Tracer != nil {
+		endSpan = j.Tracer.StartSpan("sse.publish", map[string]any{"topics": msg.topics})
+	}
+
+	now := time.Now()
+	sent, failed := 0, 0
+	if toDispatch.ID.IsSet() {
+		*lastID = toDispatch.ID
+	}
+	if j.Retain != nil && !toDispatch.isHeartbeat() {
+		j.retain(toDispatch, msg.topics)
+	}
+
+	for _, r := range j.replays {
+		if !r.cancelled && r.sub.receives(msg.topics) {
+			r.pending = append(r.pending, toDispatch)
+		}
+	}
+
+	for done, sub := range j.subscribers {
+		if !sub.paused && sub.receives(msg.topics) && (sub.limiter == nil || sub.limiter.allow(now)) {
+			sent++
+			if err := send(sub.Subscription, toDispatch, j.SendTimeout); err != nil {
+				failed++
+				done <- err
+				j.removeSubscriber(done)
+			} else if toDispatch.ID.IsSet() {
+				sub.lastSent = toDispatch.ID
+				j.subscribers[done] = sub
+			}
+		}
+	}
+
+	if endSpan != nil {
+		endSpan(map[string]any{"subscribers": sent, "failed": failed})
+	}
+
+	if msg.delivered != nil {
+		close(msg.delivered)
+	}
+}
+
+// accept replays the missed messages to the new subscriber and adds it.
+func (j *Joe) accept(sub subscription, replay ReplayProvider, canReplay bool) {
+	if err := j.checkTopicsFull(sub.Subscription); err != nil {
+		sub.done <- err
+		close(sub.done)
+		return
+	}
+
+	var err error
+	replayed, lastSent := 0, sub.LastEventID
+	if canReplay {
+		var endSpan func(map[string]any)
+		if j.Tracer != nil {
+			endSpan = j.Tracer.StartSpan("sse.replay", map[string]any{"topics": sub.Topics, "lastEventID": sub.LastEventID})
+		}
+
+		if j.AsyncReplay {
+			var snapshot []*Message
+			if snapshot, err = snapshotReplay(sub.Subscription, replay); err == nil && len(snapshot) > 0 {
+				j.replayAsync(sub, snapshot, endSpan)
+				return
+			}
+		} else {
+			var lastReplayed EventID
+			replayed, lastReplayed, err = replayTo(sub.Subscription, replay, j.SendTimeout)
+			if lastReplayed.IsSet() {
+				lastSent = lastReplayed
+			}
+		}
+
+		if endSpan != nil {
+			endSpan(map[string]any{"err": err})
+		}
+	}
+
+	j.addSubscriber(sub, replayed, lastSent, err, nil)
+}
+
+// checkTopicsFull returns an error if any of the subscription's topics has the maximum number of subscribers.
+func (j *Joe) checkTopicsFull(sub Subscription) error {
+	if j.MaxSubscribersPerTopic <= 0 || sub.AllTopics {
+		return nil
+	}
+
+	counts := make(map[string]int, len(sub.Topics))
+	count := func(s *Subscription) {
+		if s.AllTopics {
+			return
+		}
+		for _, topic := range s.Topics {
+			counts[topic]++
+		}
+	}
+	for _, s := range j.subscribers {
+		count(&s.Subscription)
+	}
+	for _, r := range j.replays {
+		count(&r.sub.Subscription)
+	}
+
+	for _, topic := range sub.Topics {
+		if counts[topic] >= j.MaxSubscribersPerTopic {
+			return fmt.Errorf("%w: %q", ErrTopicFull, topic)
+		}
+	}
+
+	return nil
+}
+
+// addSubscriber adds the subscriber after it was replayed the given number of messages, unless
+// replaying failed. The retained messages, if nothing was replayed, the ReplayDone message and
+// the pending messages, which were published while it was replayed asynchronously, are sent first.
+func (j *Joe) addSubscriber(sub subscription, replayed int, lastSent EventID, err error, pending []*Message) {
+	if err == nil && replayed == 0 && len(j.retained) > 0 {
+		err = j.sendRetained(sub.Subscription)
+	}
+	if err == nil {
+		err = sendReplayDone(sub.Subscription, j.SendTimeout)
+	}
+
+	js := joeSubscription{Subscription: sub.Subscription, lastSent: lastSent}
+	if sub.RateLimit > 0 {
+		js.limiter = newRateLimiter(sub.RateLimit, sub.RateBurst)
+	}
+
+	now := time.Now()
+	for _, m := range pending {
+		if err != nil {
+			break
+		}
+		if js.limiter == nil || js.limiter.allow(now) {
+			if err = send(sub.Subscription, m, j.SendTimeout); err == nil && m.ID.IsSet() {
+				js.lastSent = m.ID
+			}
+		}
+	}
+
+	if err != nil {
+		sub.done <- err
+		close(sub.done)
+		return
+	}
+
+	j.subscribers[sub.done] = js
+	if sub.ID != "" {
+		j.byID[sub.ID] = append(j.byID[sub.ID], sub.done)
+	}
+
+	if j.OnSubscribe != nil {
+		j.OnSubscribe(sub.Subscription)
+	}
+}
+
+// joeAsyncReplay is a subscriber which is being replayed asynchronously.
+type joeAsyncReplay struct {
+	sub subscription
+	// pending holds the messages published for the subscriber during the replay.
+	pending []*Message
+	stop    chan struct{}
+	endSpan func(map[string]any)
+	// cancelled is set if the subscriber unsubscribed during the replay.
+	cancelled bool
+}
+
+func (r *joeAsyncReplay) cancel() {
+	if !r.cancelled {
+		r.cancelled = true
+		close(r.stop)
+	}
+}
+
+// joeReplayed is the result of an asynchronous replay.
+type joeReplayed struct {
+	err    error
+	done   subscriber
+	lastID EventID
+	sent   int
+}
+
+// replayAsync sends the snapshot to the subscriber from a separate goroutine,
+// which reports back to Joe's goroutine when it is done.
+func (j *Joe) replayAsync(sub subscription, snapshot []*Message, endSpan func(map[string]any)) {
+	r := &joeAsyncReplay{sub: sub, stop: make(chan struct{}), endSpan: endSpan}
+	j.replays[sub.done] = r
+
+	go func() {
+		sent, lastID, err := replayTo(sub.Subscription, snapshotReplayProvider{messages: snapshot, stop: r.stop}, j.SendTimeout)
+		j.replayed <- joeReplayed{done: sub.done, sent: sent, lastID: lastID, err: err}
+	}()
+}
+
+// finishReplay adds the subscriber which was replayed asynchronously,
+// or removes it if it unsubscribed meanwhile.
+func (j *Joe) finishReplay(res joeReplayed) {
+	r := j.replays[res.done]
+	delete(j.replays, res.done)
+
+	if r.endSpan != nil {
+		r.endSpan(map[string]any{"err": res.err})
+	}
+
+	if r.cancelled {
+		close(res.done)
+		return
+	}
+
+	lastSent := r.sub.LastEventID
+	if res.lastID.IsSet() {
+		lastSent = res.lastID
+	}
+
+	j.addSubscriber(r.sub, res.sent, lastSent, res.err, r.pending)
+}
+
+// snapshotReplay returns the messages the replay provider replays to the subscriber.
+func snapshotReplay(sub Subscription, replay ReplayProvider) ([]*Message, error) {
+	c := &collectingClient{}
+	sub.Client = c
+	err := tryReplay(sub, replay)
+
+	return c.messages, err
+}
+
+// errReplayStopped is returned by snapshotReplayProvider when the replay is stopped.
+var errReplayStopped = errors.New("go-sse.server: replay stopped")
+
+// snapshotReplayProvider replays the given messages, until the stop channel is closed.
+type snapshotReplayProvider struct {
+	stop     <-chan struct{}
+	messages []*Message
+}
+
+func (s snapshotReplayProvider) Put(message *Message, _ []string) *Message {
+	return message
+}
+
+func (s snapshotReplayProvider) Replay(sub Subscription) error {
+	for _, m := range s.messages {
+		select {
+		case <-s.stop:
+			return errReplayStopped
+		default:
+		}
+
+		if err := sub.Client.Send(m); err != nil {
+			return err
+		}
+	}
+
+	return sub.Client.Flush()
 }
 
 func (j *Joe) start(replay ReplayProvider) {
@@ -188,61 +1234,402 @@ func (j *Joe) start(replay ReplayProvider) {
 	defer j.closeSubscribers()
 
 	canReplay := true
+	// lastID is the ID of the last message sent which has an ID.
+	var lastID EventID
 
 	for {
+		// Urgent messages are sent before the queued ones.
 		select {
+		case msg := <-j.urgent:
+			j.dispatch(msg, replay, &canReplay, &lastID)
+			continue
+		default:
+		}
+
+		select {
+		case msg := <-j.urgent:
+			j.dispatch(msg, replay, &canReplay, &lastID)
 		case msg := <-j.message:
-			toDispatch := msg.message
-			if canReplay {
-				toDispatch = j.tryPut(msg, replay, &canReplay)
+			j.dispatch(msg, replay, &canReplay, &lastID)
+		case sub := <-j.subscription:
+			j.accept(sub, replay, canReplay)
+		case r := <-j.replayed:
+			j.finishReplay(r)
+		case sub := <-j.unsubscription:
+			if r, ok := j.replays[sub]; ok {
+				r.cancel()
+			} else {
+				j.removeSubscriber(sub)
+			}
+		case m := <-j.direct:
+			subs := j.byID[m.id]
+			if len(subs) == 0 {
+				m.result <- ErrSubscriberNotFound
+				break
 			}
 
-			for done, sub := range j.subscribers {
-				if topicsIntersect(sub.Topics, msg.topics) {
-					err := sub.Client.Send(toDispatch)
-					if err == nil {
-						err = sub.Client.Flush()
-					}
+			// Removing subscribers changes the slice, so iterate over a copy.
+			for _, done := range append([]subscriber(nil), subs...) {
+				if sub := j.subscribers[done]; sub.paused {
+					continue
+				} else if err := send(sub.Subscription, m.message, j.SendTimeout); err != nil {
+					done <- err
+					j.removeSubscriber(done)
+				}
+			}
+
+			m.result <- nil
+		case m := <-j.pause:
+			subs := j.byID[m.id]
+			if len(subs) == 0 {
+				m.result <- ErrSubscriberNotFound
+				break
+			}
+
+			for _, done := range append([]subscriber(nil), subs...) {
+				j.setPaused(done, m.paused, lastID, replay, canReplay)
+			}
+
+			m.result <- nil
+		case m := <-j.replayRequest:
+			subs := j.byID[m.id]
+			if len(subs) == 0 {
+				m.result <- ErrSubscriberNotFound
+				break
+			}
 
-					if err != nil {
-						done <- err
-						j.removeSubscriber(done)
+			if canReplay {
+				for _, done := range append([]subscriber(nil), subs...) {
+					if sub := j.subscribers[done]; !sub.paused {
+						j.replayFrom(done, m.lastEventID, replay)
 					}
 				}
 			}
-		case sub := <-j.subscription:
-			var err error
-			if canReplay {
-				err = j.tryReplay(sub.Subscription, replay, &canReplay)
+
+			m.result <- nil
+		case result := <-j.topics:
+			result <- j.activeTopics()
+		case result := <-j.export:
+			states := make([]SubscriberState, 0, len(j.subscribers))
+			for _, sub := range j.subscribers {
+				states = append(states, SubscriberState{
+					ID:          sub.ID,
+					Topics:      append([]string(nil), sub.Topics...),
+					LastEventID: sub.lastSent,
+					AllTopics:   sub.AllTopics,
+					Paused:      sub.paused,
+				})
+			}
+			result <- states
+		case m := <-j.hasSubscribers:
+			found := false
+			for _, sub := range j.subscribers {
+				if sub.receives(m.topics) {
+					found = true
+					break
+				}
 			}
 
-			if err != nil && err != errReplayPanicked { //nolint:errorlint // This is our error.
-				sub.done <- err
-				close(sub.done)
-			} else {
-				j.subscribers[sub.done] = sub.Subscription
+			m.result <- found
+		case m := <-j.closeTopic:
+			removed := 0
+			for done, sub := range j.subscribers {
+				if !sub.AllTopics && topicsIntersect(sub.Topics, []string{m.topic}) {
+					removed++
+					done <- ErrTopicClosed
+					j.removeSubscriber(done)
+				}
 			}
-		case sub := <-j.unsubscription:
-			j.removeSubscriber(sub)
+
+			m.result <- removed
+		case replay = <-j.replayProvider:
+			canReplay = true
 		case <-j.done:
+			j.sendGoodbye()
 			return
 		}
 	}
 }
 
+const defaultGoodbyeTimeout = time.Second
+
+// sendGoodbye sends the Goodbye message, if set, to all the subscribers.
+// All the clients share the same deadline, so the timeout bounds the whole operation.
+func (j *Joe) sendGoodbye() {
+	if j.Goodbye == nil {
+		return
+	}
+
+	timeout := j.GoodbyeTimeout
+	if timeout <= 0 {
+		timeout = defaultGoodbyeTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for _, sub := range j.subscribers {
+		d, ok := sub.Client.(writeDeadliner)
+		if ok {
+			_ = d.SetWriteDeadline(deadline)
+		}
+
+		if sub.Client.Send(j.Goodbye) == nil {
+			_ = sub.Client.Flush()
+		}
+
+		if ok {
+			_ = d.SetWriteDeadline(time.Time{})
+		}
+	}
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(time.Time) error
+}
+
+// timeoutWriter sets a write deadline on the client before each operation.
+type timeoutWriter struct {
+	MessageWriter
+	deadliner   writeDeadliner
+	timeout     time.Duration
+	hasDeadline bool
+}
+
+func (w *timeoutWriter) Send(m *Message) error {
+	w.setDeadline()
+	return w.MessageWriter.Send(m)
+}
+
+func (w *timeoutWriter) Flush() error {
+	w.setDeadline()
+	return w.MessageWriter.Flush()
+}
+
+func (w *timeoutWriter) setDeadline() {
+	_ = w.deadliner.SetWriteDeadline(time.Now().Add(w.timeout))
+	w.hasDeadline = true
+}
+
+// resetDeadline removes the deadline, if one was set.
+func (w *timeoutWriter) resetDeadline() {
+	if w.hasDeadline {
+		_ = w.deadliner.SetWriteDeadline(time.Time{})
+		w.hasDeadline = false
+	}
+}
+
+// sendTimeout returns the send timeout of the subscription and its client's deadline setter.
+// The boolean is false if there is no timeout or the client doesn't support deadlines.
+// The given default is used if the subscription has no send timeout.
+func sendTimeout(sub Subscription, timeout time.Duration) (writeDeadliner, time.Duration, bool) {
+	if sub.SendTimeout != 0 {
+		timeout = sub.SendTimeout
+	}
+
+	d, ok := sub.Client.(writeDeadliner)
+	return d, timeout, ok && timeout > 0
+}
+
+func send(sub Subscription, m *Message, timeout time.Duration) error {
+	if d, timeout, ok := sendTimeout(sub, timeout); ok {
+		_ = d.SetWriteDeadline(time.Now().Add(timeout))
+		defer func() { _ = d.SetWriteDeadline(time.Time{}) }()
+	}
+
+	if err := sub.Client.Send(m); err != nil {
+		return err
+	}
+
+	return sub.Client.Flush()
+}
+
+// setPaused pauses or resumes the subscriber. When it is resumed, the messages published
+// while it was paused are replayed.
+func (j *Joe) setPaused(done subscriber, paused bool, lastID EventID, replay ReplayProvider, canReplay bool) {
+	sub := j.subscribers[done]
+	if sub.paused == paused {
+		return
+	}
+
+	sub.paused = paused
+	if paused {
+		sub.resumeFrom = lastID
+	}
+	j.subscribers[done] = sub
+
+	if !paused && canReplay && sub.resumeFrom != lastID {
+		j.replayFrom(done, sub.resumeFrom, replay)
+	}
+}
+
+// replayFrom replays the messages after the given ID to the subscriber.
+// If replaying fails, the subscriber is removed and false is returned.
+func (j *Joe) replayFrom(done subscriber, lastEventID EventID, replay ReplayProvider) bool {
+	sub := j.subscribers[done].Subscription
+	sub.LastEventID = lastEventID
+	_, lastID, err := replayTo(sub, replay, j.SendTimeout)
+	if err != nil {
+		done <- err
+		j.removeSubscriber(done)
+		return false
+	}
+
+	if lastID.IsSet() {
+		js := j.subscribers[done]
+		js.lastSent = lastID
+		j.subscribers[done] = js
+	}
+
+	return true
+}
+
+// activeTopics returns the sorted topics of the subscribers.
+func (j *Joe) activeTopics() []string {
+	seen := map[string]struct{}{}
+	for _, sub := range j.subscribers {
+		if sub.AllTopics {
+			continue
+		}
+		for _, topic := range sub.Topics {
+			seen[topic] = struct{}{}
+		}
+	}
+
+	topics := make([]string, 0, len(seen))
+	for topic := range seen {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	return topics
+}
+
 func (j *Joe) closeSubscribers() {
 	for done := range j.subscribers {
+		done <- ErrProviderClosed
 		j.removeSubscriber(done)
 	}
+
+	// Wait for the asynchronous replays to stop, so nothing is sent to their subscribers
+	// after they are removed.
+	for _, r := range j.replays {
+		r.cancel()
+	}
+	for len(j.replays) > 0 {
+		res := <-j.replayed
+		if r := j.replays[res.done]; r.endSpan != nil {
+			r.endSpan(map[string]any{"err": ErrProviderClosed})
+		}
+		delete(j.replays, res.done)
+		res.done <- ErrProviderClosed
+		close(res.done)
+	}
+}
+
+// replayTo replays the messages to the subscriber, applying the send timeout to each
+// replayed message. It returns the number of messages replayed and the last replayed ID.
+func replayTo(sub Subscription, replay ReplayProvider, timeout time.Duration) (int, EventID, error) {
+	if d, timeout, ok := sendTimeout(sub, timeout); ok {
+		w := &timeoutWriter{MessageWriter: sub.Client, deadliner: d, timeout: timeout}
+		defer w.resetDeadline()
+		sub.Client = w
+	}
+
+	w := &countingWriter{MessageWriter: sub.Client}
+	sub.Client = w
+	err := tryReplay(sub, replay)
+
+	return w.sent, w.lastID, err
+}
+
+// countingWriter counts the messages sent to the client and keeps the last ID sent.
+type countingWriter struct {
+	MessageWriter
+	lastID EventID
+	sent   int
 }
 
-var errReplayPanicked = errors.New("replay failed unexpectedly")
+func (w *countingWriter) Send(m *Message) error {
+	w.sent++
+	if m.ID.IsSet() {
+		w.lastID = m.ID
+	}
+	return w.MessageWriter.Send(m)
+}
 
-func (*Joe) tryReplay(sub Subscription, replay ReplayProvider, canReplay *bool) (err error) {
+type retainedMessage struct {
+	message *Message
+	// order is the number of the message in publish order.
+	order uint64
+}
+
+// retain keeps the message as the last one of each of its topics which are retained.
+func (j *Joe) retain(m *Message, topics []string) {
+	j.published++
+	for _, topic := range topics {
+		if j.Retain(topic) {
+			j.retained[topic] = retainedMessage{message: m, order: j.published}
+		}
+	}
+}
+
+// sendRetained sends the messages retained for the subscription's topics, in publish order.
+func (j *Joe) sendRetained(sub Subscription) error {
+	var messages []retainedMessage
+	add := func(r retainedMessage) {
+		// A message published to multiple topics is retained for each of them.
+		if !containsRetained(messages, r) {
+			messages = append(messages, r)
+		}
+	}
+
+	if sub.AllTopics {
+		for _, r := range j.retained {
+			add(r)
+		}
+	} else {
+		for _, topic := range sub.Topics {
+			if r, ok := j.retained[topic]; ok {
+				add(r)
+			}
+		}
+	}
+
+	sort.Slice(messages, func(a, b int) bool { return messages[a].order < messages[b].order })
+
+	for _, r := range messages {
+		if err := send(sub, r.message, j.SendTimeout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsRetained(messages []retainedMessage, r retainedMessage) bool {
+	for _, m := range messages {
+		if m.order == r.order {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sendReplayDone sends the subscription's replay done message, if it has one.
+func sendReplayDone(sub Subscription, timeout time.Duration) error {
+	if sub.ReplayDone == nil {
+		return nil
+	}
+
+	return send(sub, sub.ReplayDone, timeout)
+}
+
+// tryReplay replays the messages to the subscriber. If the replay provider panics,
+// the panic is logged and only this subscriber fails, so the others aren't affected.
+func tryReplay(sub Subscription, replay ReplayProvider) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			*canReplay = false
-			err = errReplayPanicked
+			err = fmt.Errorf("%w: %v", ErrReplayPanicked, r)
 			log.Printf("panic: %v\n%s", r, debug.Stack())
 		}
 	}()
@@ -252,7 +1639,7 @@ func (*Joe) tryReplay(sub Subscription, replay ReplayProvider, canReplay *bool)
 	return
 }
 
-func (*Joe) tryPut(msg messageWithTopics, replay ReplayProvider, canReplay *bool) *Message {
+func tryPut(msg messageWithTopics, replay ReplayProvider, canReplay *bool) *Message {
 	defer func() {
 		if r := recover(); r != nil {
 			*canReplay = false
@@ -265,12 +1652,29 @@ func (*Joe) tryPut(msg messageWithTopics, replay ReplayProvider, canReplay *bool
 
 func (j *Joe) init() {
 	j.initDone.Do(func() {
-		j.message = make(chan messageWithTopics)
+		queueSize := j.QueueSize
+		if queueSize < 0 {
+			queueSize = 0
+		}
+		j.message = make(chan joeMessage, queueSize)
+		j.urgent = make(chan joeMessage, queueSize)
 		j.subscription = make(chan subscription)
 		j.unsubscription = make(chan subscriber)
+		j.replayProvider = make(chan ReplayProvider)
+		j.direct = make(chan joeDirectMessage)
+		j.pause = make(chan joePause)
+		j.replayRequest = make(chan joeReplay)
+		j.topics = make(chan chan []string)
+		j.export = make(chan chan []SubscriberState)
+		j.closeTopic = make(chan joeCloseTopic)
+		j.hasSubscribers = make(chan joeHasSubscribers)
+		j.replayed = make(chan joeReplayed)
 		j.done = make(chan struct{})
 		j.closed = make(chan struct{})
-		j.subscribers = map[subscriber]Subscription{}
+		j.subscribers = map[subscriber]joeSubscription{}
+		j.byID = map[string][]subscriber{}
+		j.replays = map[subscriber]*joeAsyncReplay{}
+		j.retained = map[string]retainedMessage{}
 
 		replay := j.ReplayProvider
 		if replay == nil {
@@ -3,7 +3,12 @@ package sse_test
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -226,6 +231,321 @@ data: world
 	tests.Equal(t, expected, msgs[0].String()+msgs[1].String(), "unexpected data received")
 }
 
+func TestJoe_Subscribe_multipleTopics_replay(t *testing.T) {
+	t.Parallel()
+
+	fin, err := sse.NewFiniteReplayProvider(5, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	j := &sse.Joe{ReplayProvider: fin}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	_ = j.Publish(msg(t, "start", "1"), []string{"other"})
+	_ = j.Publish(msg(t, "both", "2"), []string{"orders", "audit"})
+	_ = j.Publish(msg(t, "orders", "3"), []string{"orders"})
+
+	replayed := func(topics ...string) string {
+		var out string
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_ = j.Subscribe(ctx, sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					out += m.String()
+				}
+				return nil
+			}),
+			LastEventID: sse.ID("1"),
+			Topics:      topics,
+		})
+
+		return out
+	}
+
+	tests.Equal(t, replayed("audit"), "id: 2\ndata: both\n\n", "invalid messages replayed for a single topic")
+	tests.Equal(t, replayed("orders", "audit"), "id: 2\ndata: both\n\nid: 3\ndata: orders\n\n", "messages should be replayed once")
+}
+
+func TestJoe_Subscribe_allTopics(t *testing.T) {
+	t.Parallel()
+
+	fin, err := sse.NewFiniteReplayProvider(3, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	j := &sse.Joe{ReplayProvider: fin}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	_ = j.Publish(msg(t, "a", "1"), []string{"first"})
+	_ = j.Publish(msg(t, "b", "2"), []string{"second"})
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	msgs := make(chan *sse.Message, 3)
+	done := make(chan error, 1)
+	go func() {
+		done <- j.Subscribe(ctx, sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					msgs <- m
+				}
+				return nil
+			}),
+			LastEventID: sse.ID("1"),
+			AllTopics:   true,
+		})
+	}()
+	<-ctx.waitingOnDone
+
+	_ = j.Publish(msg(t, "c", "3"), []string{"third"})
+	_ = j.Shutdown(context.Background())
+	tests.Equal(t, <-done, sse.ErrProviderClosed, "unexpected subscribe error")
+
+	tests.Equal(t, (<-msgs).String(), "id: 2\ndata: b\n\n", "replayed message from any topic should be received")
+	tests.Equal(t, (<-msgs).String(), "id: 3\ndata: c\n\n", "message published to any topic should be received")
+}
+
+type deadlineClient struct {
+	mockClient
+	deadlines chan time.Time
+}
+
+func (d deadlineClient) SetWriteDeadline(t time.Time) error {
+	d.deadlines <- t
+	return nil
+}
+
+func TestJoe_SendTimeout(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{SendTimeout: time.Hour}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	newClient := func() deadlineClient {
+		return deadlineClient{
+			mockClient: func(*sse.Message) error { return nil },
+			deadlines:  make(chan time.Time, 2),
+		}
+	}
+
+	clients := []deadlineClient{newClient(), newClient(), newClient()}
+	timeouts := []time.Duration{0, time.Hour * 2, -1}
+
+	for i := range clients {
+		ctx, cancel := newMockContext(t)
+		defer cancel()
+
+		go func(i int) {
+			_ = j.Subscribe(ctx, sse.Subscription{Client: clients[i], SendTimeout: timeouts[i], Topics: []string{sse.DefaultTopic}})
+		}(i)
+
+		<-ctx.waitingOnDone
+	}
+
+	start := time.Now()
+	_ = j.Publish(msg(t, "hello", ""), []string{sse.DefaultTopic})
+	_ = j.Shutdown(context.Background())
+
+	for i, expected := range []time.Duration{time.Hour, time.Hour * 2} {
+		deadline := <-clients[i].deadlines
+		tests.Expect(t, deadline.Sub(start) >= expected && deadline.Sub(start) < expected+time.Minute, "invalid deadline for client %d", i)
+		tests.Expect(t, (<-clients[i].deadlines).IsZero(), "deadline should be reset for client %d", i)
+	}
+	tests.Equal(t, len(clients[2].deadlines), 0, "no deadline should be set when timeout is disabled")
+}
+
+func TestJoe_Goodbye(t *testing.T) {
+	t.Parallel()
+
+	goodbye := &sse.Message{Type: sse.Type("server-closing")}
+	subscribed := make(chan struct{}, 1)
+	j := &sse.Joe{
+		Goodbye:        goodbye,
+		GoodbyeTimeout: time.Hour,
+		OnSubscribe:    func(sse.Subscription) { subscribed <- struct{}{} },
+	}
+
+	received := make(chan *sse.Message, 2)
+	client := deadlineClient{
+		mockClient: func(m *sse.Message) error {
+			if m != nil {
+				received <- m
+			}
+			return nil
+		},
+		deadlines: make(chan time.Time, 4),
+	}
+
+	done := make(chan error, 2)
+	for _, id := range []string{"live", "paused"} {
+		go func(id string) {
+			done <- j.Subscribe(context.Background(), sse.Subscription{Client: client, ID: id, Topics: []string{sse.DefaultTopic}})
+		}(id)
+		<-subscribed
+	}
+	tests.Equal(t, j.Pause("paused"), nil, "unexpected pause error")
+
+	start := time.Now()
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "shutdown should succeed")
+
+	for i := 0; i < 2; i++ {
+		tests.ErrorIs(t, <-done, sse.ErrProviderClosed, "invalid subscribe error")
+		tests.Equal(t, <-received, goodbye, "goodbye should be sent to all subscribers")
+
+		deadline := <-client.deadlines
+		tests.Expect(t, deadline.Sub(start) >= time.Hour && deadline.Sub(start) < time.Hour+time.Minute, "invalid goodbye deadline")
+		tests.Expect(t, (<-client.deadlines).IsZero(), "deadline should be reset")
+	}
+}
+
+func TestJoe_SendTimeout_replay(t *testing.T) {
+	t.Parallel()
+
+	rp, err := sse.NewFiniteReplayProvider(5, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	j := &sse.Joe{ReplayProvider: rp, SendTimeout: time.Hour}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	_ = j.Publish(msg(t, "hello", "1"), []string{sse.DefaultTopic})
+	_ = j.Publish(msg(t, "world", "2"), []string{sse.DefaultTopic})
+	_ = j.Publish(msg(t, "again", "3"), []string{sse.DefaultTopic})
+
+	c := deadlineClient{
+		mockClient: func(*sse.Message) error { return nil },
+		deadlines:  make(chan time.Time, 8),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	_ = j.Subscribe(ctx, sse.Subscription{Client: c, LastEventID: sse.ID("1"), Topics: []string{sse.DefaultTopic}})
+
+	// Two replayed messages and the flush after them.
+	for i := 0; i < 3; i++ {
+		deadline := <-c.deadlines
+		tests.Expect(t, deadline.Sub(start) >= time.Hour && deadline.Sub(start) < time.Hour+time.Minute, "invalid deadline for operation %d", i)
+	}
+	tests.Expect(t, (<-c.deadlines).IsZero(), "deadline should be reset after replay")
+	tests.Equal(t, len(c.deadlines), 0, "unexpected deadlines")
+}
+
+func TestJoe_RateLimit(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{}
+
+	limitedCtx, cancelLimited := newMockContext(t)
+	defer cancelLimited()
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	var limited []*sse.Message
+	limitedDone := make(chan struct{})
+	go func() {
+		defer close(limitedDone)
+		_ = j.Subscribe(limitedCtx, sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					limited = append(limited, m)
+				}
+				return nil
+			}),
+			Topics:    []string{sse.DefaultTopic},
+			RateLimit: 0.001,
+			RateBurst: 2,
+		})
+	}()
+	<-limitedCtx.waitingOnDone
+
+	sub := subscribe(t, j, ctx)
+	<-ctx.waitingOnDone
+
+	for i := 0; i < 5; i++ {
+		_ = j.Publish(msg(t, "hello", ""), []string{sse.DefaultTopic})
+	}
+
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "shutdown should succeed")
+	<-limitedDone
+
+	tests.Equal(t, len(limited), 2, "messages over the rate limit should be dropped")
+	tests.Equal(t, len(<-sub), 5, "other subscribers should not be limited")
+}
+
+func TestJoe_heartbeats(t *testing.T) {
+	t.Parallel()
+
+	fin, err := sse.NewFiniteReplayProvider(2, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	j := &sse.Joe{ReplayProvider: fin}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	heartbeat := &sse.Message{}
+	heartbeat.AppendComment("keep-alive")
+
+	_ = j.Publish(msg(t, "hello", "1"), []string{sse.DefaultTopic})
+	_ = j.Publish(msg(t, "world", "2"), []string{sse.DefaultTopic})
+	// The heartbeat would make the provider panic due to the missing ID
+	// and would evict the first message if it were put.
+	_ = j.Publish(heartbeat, []string{sse.DefaultTopic})
+
+	var replayed []*sse.Message
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_ = j.Subscribe(ctx, sse.Subscription{
+		Client: mockClient(func(m *sse.Message) error {
+			if m != nil {
+				replayed = append(replayed, m)
+			}
+			return nil
+		}),
+		LastEventID: sse.ID("1"),
+		Topics:      []string{sse.DefaultTopic},
+	})
+
+	tests.Equal(t, len(replayed), 1, "heartbeat should not be replayed")
+	tests.Equal(t, replayed[0].String(), "id: 2\ndata: world\n\n", "invalid message replayed")
+}
+
+func TestJoe_SubscribeTimeout(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{SubscribeTimeout: time.Millisecond}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	unblock := make(chan struct{})
+	go func() {
+		_ = j.Subscribe(ctx, sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					<-unblock
+				}
+				return nil
+			}),
+			Topics: []string{sse.DefaultTopic},
+		})
+	}()
+	<-ctx.waitingOnDone
+
+	// Blocks Joe until the subscriber is unblocked.
+	_ = j.Publish(&sse.Message{}, []string{sse.DefaultTopic})
+
+	err := j.Subscribe(context.Background(), sse.Subscription{Topics: []string{sse.DefaultTopic}})
+	tests.Equal(t, err, sse.ErrSubscribeTimeout, "subscription should time out")
+
+	close(unblock)
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "unexpected shutdown error")
+	tests.Equal(t, j.Subscribe(context.Background(), sse.Subscription{}), sse.ErrProviderClosed, "closed error should take precedence")
+}
+
 func TestJoe_errors(t *testing.T) {
 	t.Parallel()
 
@@ -295,28 +615,1073 @@ func (m *mockMessageWriter) Flush() error {
 	return nil
 }
 
+// panickingReplayProvider panics when replaying to subscribers with the given last event ID.
+type panickingReplayProvider struct {
+	sse.ReplayProvider
+	id sse.EventID
+}
+
+func (p *panickingReplayProvider) Replay(sub sse.Subscription) error {
+	if sub.LastEventID == p.id {
+		panic("panicked")
+	}
+	return p.ReplayProvider.Replay(sub)
+}
+
 func TestJoe_ReplayPanic(t *testing.T) {
 	t.Parallel()
 
-	rp := newMockReplayProvider("replay", 1)
-	j := &sse.Joe{ReplayProvider: rp}
-	wr := &mockMessageWriter{msg: make(chan *sse.Message, 1)}
+	fin, err := sse.NewFiniteReplayProvider(10, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	subscribed := make(chan struct{}, 1)
+	j := &sse.Joe{
+		ReplayProvider: &panickingReplayProvider{ReplayProvider: fin, id: sse.ID("bad")},
+		OnSubscribe:    func(sse.Subscription) { subscribed <- struct{}{} },
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
 
 	topics := []string{sse.DefaultTopic}
-	suberr := make(chan error)
+	wr := &mockMessageWriter{msg: make(chan *sse.Message, 1)}
+	suberr := make(chan error, 1)
 	go func() { suberr <- j.Subscribe(context.Background(), sse.Subscription{Client: wr, Topics: topics}) }()
+	<-subscribed
 
-	_, ok := <-rp.replayc
-	tests.Expect(t, ok, "replay wasn't called")
+	tests.Equal(t, j.Publish(msg(t, "hello", "1"), topics), nil, "unexpected Publish error")
+	tests.Equal(t, (<-wr.msg).ID, sse.ID("1"), "message was not sent to client")
 
-	msg := &sse.Message{ID: sse.ID("hello")}
-	tests.Equal(t, j.Publish(msg, topics), nil, "unexpected Publish error")
-	tests.Equal(t, (<-wr.msg).ID, msg.ID, "message was not sent to client")
+	err = j.Subscribe(context.Background(), sse.Subscription{
+		Client:      mockClient(func(*sse.Message) error { return nil }),
+		LastEventID: sse.ID("bad"),
+		Topics:      topics,
+	})
+	tests.ErrorIs(t, err, sse.ErrReplayPanicked, "panicking replay should fail the subscription")
 
-	go func() { _ = j.Subscribe(context.Background(), sse.Subscription{}) }()
-	time.Sleep(time.Millisecond)
-	tests.Equal(t, rp.replays(), 0, "replay was called")
+	tests.Equal(t, j.Publish(msg(t, "world", "2"), topics), nil, "unexpected Publish error")
+	tests.Equal(t, (<-wr.msg).ID, sse.ID("2"), "existing subscribers should not be affected")
+
+	replayed := &mockMessageWriter{msg: make(chan *sse.Message, 1)}
+	go func() {
+		_ = j.Subscribe(context.Background(), sse.Subscription{Client: replayed, LastEventID: sse.ID("1"), Topics: topics})
+	}()
+	tests.Equal(t, (<-replayed.msg).ID, sse.ID("2"), "other subscribers should still be replayed to")
+	<-subscribed
+
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "shutdown should succeed")
+	tests.Equal(t, <-suberr, sse.ErrProviderClosed, "unexpected subscribe error")
+}
+
+func TestJoe_Publish_concurrent(t *testing.T) {
+	t.Parallel()
+
+	const publishers, messagesPerPublisher = 50, 20
+
+	j := &sse.Joe{}
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	sub := subscribe(t, j, ctx)
+	<-ctx.waitingOnDone
+
+	var wg sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		p := p
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < messagesPerPublisher; i++ {
+				_ = j.Publish(msg(t, "", fmt.Sprintf("%d:%d", p, i)), []string{sse.DefaultTopic})
+			}
+		}()
+	}
+	wg.Wait()
+
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "shutdown should succeed")
+
+	msgs := <-sub
+	tests.Equal(t, len(msgs), publishers*messagesPerPublisher, "all messages should be received")
+
+	next := make([]int, publishers)
+	for _, m := range msgs {
+		var p, i int
+		_, err := fmt.Sscanf(m.ID.String(), "%d:%d", &p, &i)
+		tests.Equal(t, err, nil, "invalid message ID")
+		tests.Equal(t, i, next[p], "messages of publisher %d received out of order", p)
+		next[p]++
+	}
+}
+
+func TestJoe_ReplayDone(t *testing.T) {
+	t.Parallel()
+
+	providers := map[string]func(sse.ReplayProvider) sse.Provider{
+		"Joe":  func(rp sse.ReplayProvider) sse.Provider { return &sse.Joe{ReplayProvider: rp} },
+		"Pool": func(rp sse.ReplayProvider) sse.Provider { return &sse.Pool{ReplayProvider: rp} },
+	}
+
+	for name, newProvider := range providers {
+		newProvider := newProvider
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fin, err := sse.NewFiniteReplayProvider(2, false)
+			tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+			p := newProvider(fin)
+
+			_ = p.Publish(msg(t, "", "1"), []string{sse.DefaultTopic})
+			_ = p.Publish(msg(t, "", "2"), []string{sse.DefaultTopic})
+
+			ctx, cancel := newMockContext(t)
+			defer cancel()
+
+			var received []string
+			done := make(chan error, 1)
+			go func() {
+				done <- p.Subscribe(ctx, sse.Subscription{
+					Client: mockClient(func(m *sse.Message) error {
+						if m != nil {
+							received = append(received, m.ID.String()+m.Type.String())
+						}
+						return nil
+					}),
+					LastEventID: sse.ID("1"),
+					Topics:      []string{sse.DefaultTopic},
+					ReplayDone:  &sse.Message{Type: sse.Type("synced")},
+				})
+			}()
+			<-ctx.waitingOnDone
+
+			_ = p.Publish(msg(t, "", "3"), []string{sse.DefaultTopic})
+
+			tests.Equal(t, p.Shutdown(context.Background()), nil, "shutdown should succeed")
+			tests.Equal(t, <-done, sse.ErrProviderClosed, "unexpected subscribe error")
+			tests.DeepEqual(t, received, []string{"2", "synced", "3"}, "replay done message should be sent between replayed and live messages")
+		})
+	}
+}
+
+func TestJoe_DeliveryTimeout(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{DeliveryTimeout: time.Millisecond * 20}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	unblock := make(chan struct{})
+	var received []string
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	go j.Subscribe(ctx, sse.Subscription{ //nolint:errcheck // irrelevant
+		Client: mockClient(func(m *sse.Message) error {
+			if m != nil {
+				if m.ID.String() == "slow" {
+					<-unblock
+				}
+				received = append(received, m.ID.String())
+			}
+			return nil
+		}),
+		Topics: []string{sse.DefaultTopic},
+	})
+	<-ctx.waitingOnDone
+
+	tests.Equal(t, j.Publish(msg(t, "", "1"), []string{sse.DefaultTopic}), nil, "publish should succeed")
+	// Publish returned only after the message was sent, so this doesn't race.
+	tests.DeepEqual(t, received, []string{"1"}, "message should be delivered when Publish returns")
+
+	tests.ErrorIs(t, j.Publish(msg(t, "", "slow"), []string{sse.DefaultTopic}), sse.ErrDeliveryTimeout, "publish should time out")
+	close(unblock)
+
+	tests.Equal(t, j.Publish(msg(t, "", "2"), []string{sse.DefaultTopic}), nil, "publish should succeed")
+	tests.DeepEqual(t, received, []string{"1", "slow", "2"}, "timed out message should still be delivered")
+}
+
+func TestJoe_SetReplayProvider(t *testing.T) {
+	t.Parallel()
+
+	newFinite := func() *sse.FiniteReplayProvider {
+		fin, err := sse.NewFiniteReplayProvider(5, false)
+		tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+		return fin
+	}
+
+	j := &sse.Joe{ReplayProvider: newFinite()}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	replayed := func(lastEventID string) []string {
+		var ids []string
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		// The context is already done, so Subscribe returns right after the replay.
+		_ = j.Subscribe(ctx, sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					ids = append(ids, m.ID.String())
+				}
+				return nil
+			}),
+			LastEventID: sse.ID(lastEventID),
+			Topics:      []string{sse.DefaultTopic},
+		})
+		return ids
+	}
+
+	_ = j.Publish(msg(t, "", "1"), []string{sse.DefaultTopic})
+	_ = j.Publish(msg(t, "", "2"), []string{sse.DefaultTopic})
+	tests.DeepEqual(t, replayed("1"), []string{"2"}, "invalid replay before swap")
+
+	tests.Equal(t, j.SetReplayProvider(newFinite()), nil, "swap should succeed")
+	_ = j.Publish(msg(t, "", "3"), []string{sse.DefaultTopic})
+	_ = j.Publish(msg(t, "", "4"), []string{sse.DefaultTopic})
+	tests.DeepEqual(t, replayed("1"), []string(nil), "old messages should be discarded")
+	tests.DeepEqual(t, replayed("3"), []string{"4"}, "new provider should be used")
+
+	tests.Equal(t, j.SetReplayProvider(nil), nil, "disabling replay should succeed")
+	tests.DeepEqual(t, replayed("3"), []string(nil), "replay should be disabled")
+
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "shutdown should succeed")
+	tests.Equal(t, j.SetReplayProvider(newFinite()), sse.ErrProviderClosed, "swap should fail after shutdown")
+}
+
+func TestJoe_AllowTopic(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{AllowTopic: func(topic string) bool { return topic == sse.DefaultTopic }}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	client := mockClient(func(*sse.Message) error { return nil })
+	err := j.Subscribe(context.Background(), sse.Subscription{Client: client, Topics: []string{sse.DefaultTopic, "typo"}})
+	tests.ErrorIs(t, err, sse.ErrUnknownTopic, "unknown topic should be rejected")
+	tests.Expect(t, strings.Contains(err.Error(), `"typo"`), "error should name the topic")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests.Equal(t, j.Subscribe(ctx, sse.Subscription{Client: client, Topics: []string{sse.DefaultTopic}}), nil, "allowed topic should be accepted")
+	tests.Equal(t, j.Subscribe(ctx, sse.Subscription{Client: client, AllTopics: true}), nil, "all topics subscriptions should be accepted")
+}
+
+type mockSpan struct {
+	name       string
+	start, end map[string]any
+}
+
+type mockTracer struct {
+	spans []mockSpan
+	mu    sync.Mutex
+}
+
+func (m *mockTracer) StartSpan(name string, attributes map[string]any) func(map[string]any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := len(m.spans)
+	m.spans = append(m.spans, mockSpan{name: name, start: attributes})
+
+	return func(attributes map[string]any) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.spans[i].end = attributes
+	}
+}
+
+func TestJoe_Tracer(t *testing.T) {
+	t.Parallel()
+
+	rp, _ := sse.NewFiniteReplayProvider(2, false)
+	tracer := &mockTracer{}
+	j := &sse.Joe{ReplayProvider: rp, Tracer: tracer, DeliveryTimeout: time.Second}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	tests.Equal(t, j.Publish(msg(t, "", "1"), []string{sse.DefaultTopic}), nil, "publish should succeed")
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	go j.Subscribe(ctx, sse.Subscription{ //nolint:errcheck // irrelevant
+		Client:      mockClient(func(*sse.Message) error { return nil }),
+		LastEventID: sse.ID("1"),
+		Topics:      []string{sse.DefaultTopic},
+	})
+	<-ctx.waitingOnDone
+
+	tests.Equal(t, j.Publish(msg(t, "", "2"), []string{sse.DefaultTopic}), nil, "publish should succeed")
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+
+	topics := []string{sse.DefaultTopic}
+	expected := []mockSpan{
+		{name: "sse.publish", start: map[string]any{"topics": topics}, end: map[string]any{"subscribers": 0, "failed": 0}},
+		{name: "sse.replay", start: map[string]any{"topics": topics, "lastEventID": sse.ID("1")}, end: map[string]any{"err": nil}},
+		{name: "sse.publish", start: map[string]any{"topics": topics}, end: map[string]any{"subscribers": 1, "failed": 0}},
+	}
+	tests.DeepEqual(t, tracer.spans, expected, "invalid spans")
+}
+
+func TestJoe_OnSubscribe(t *testing.T) {
+	t.Parallel()
+
+	var events []string
+	eventsCh := make(chan string, 4)
+	j := &sse.Joe{
+		OnSubscribe:   func(sub sse.Subscription) { eventsCh <- "subscribe " + sub.Topics[0] },
+		OnUnsubscribe: func(sub sse.Subscription) { eventsCh <- "unsubscribe " + sub.Topics[0] },
+	}
+
+	ctx, cancel := newMockContext(t)
+	errCh := make(chan error)
+	go func() {
+		errCh <- j.Subscribe(ctx, sse.Subscription{
+			Client: mockClient(func(*sse.Message) error { return nil }),
+			Topics: []string{"a"},
+		})
+	}()
+	<-ctx.waitingOnDone
+	events = append(events, <-eventsCh)
+
+	cancel()
+	tests.Equal(t, <-errCh, nil, "unexpected subscribe error")
+	events = append(events, <-eventsCh)
+
+	ctx2, cancel2 := newMockContext(t)
+	defer cancel2()
+	go func() {
+		errCh <- j.Subscribe(ctx2, sse.Subscription{
+			Client: mockClient(func(*sse.Message) error { return nil }),
+			Topics: []string{"b"},
+		})
+	}()
+	<-ctx2.waitingOnDone
+	events = append(events, <-eventsCh)
+
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "unexpected shutdown error")
+	<-errCh
+	events = append(events, <-eventsCh)
+
+	tests.DeepEqual(t, events, []string{"subscribe a", "unsubscribe a", "subscribe b", "unsubscribe b"}, "invalid lifecycle callbacks")
+}
+
+func TestJoe_SendTo(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	var mu sync.Mutex
+	received := map[string][]string{}
+	// subscribeID returns a function which unsubscribes and waits for Subscribe to return.
+	subscribeID := func(name, id string) func() {
+		ctx, cancel := newMockContext(t)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = j.Subscribe(ctx, sse.Subscription{
+				Client: mockClient(func(m *sse.Message) error {
+					if m != nil {
+						mu.Lock()
+						received[name] = append(received[name], m.ID.String())
+						mu.Unlock()
+					}
+					return nil
+				}),
+				Topics: []string{sse.DefaultTopic},
+				ID:     id,
+			})
+		}()
+		<-ctx.waitingOnDone
+
+		return func() {
+			cancel()
+			<-done
+		}
+	}
+
+	defer subscribeID("tab1", "user")()
+	defer subscribeID("tab2", "user")()
+	cancelOther := subscribeID("other", "other")
+
+	tests.Equal(t, j.SendTo("user", msg(t, "", "1")), nil, "send should succeed")
+	tests.ErrorIs(t, j.SendTo("missing", msg(t, "", "2")), sse.ErrSubscriberNotFound, "missing subscriber should be reported")
+
+	cancelOther()
+	tests.ErrorIs(t, j.SendTo("other", msg(t, "", "3")), sse.ErrSubscriberNotFound, "unsubscribed subscriber should not be found")
+
+	mu.Lock()
+	defer mu.Unlock()
+	tests.DeepEqual(t, received, map[string][]string{"tab1": {"1"}, "tab2": {"1"}}, "message should be sent only to the subscribers with the ID")
+}
+
+func TestJoe_IDFunc(t *testing.T) {
+	t.Parallel()
+
+	rp, _ := sse.NewFiniteReplayProvider(2, false)
+	next := 0
+	j := &sse.Joe{
+		ReplayProvider: rp,
+		IDFunc: func(m *sse.Message) sse.EventID {
+			if m.ID.IsSet() {
+				return m.ID
+			}
+			next++
+			return sse.ID(strconv.Itoa(next))
+		},
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	received := make(chan *sse.Message, 3)
+	go j.Subscribe(ctx, sse.Subscription{ //nolint:errcheck // irrelevant
+		Client: mockClient(func(m *sse.Message) error {
+			if m != nil {
+				received <- m
+			}
+			return nil
+		}),
+		Topics: []string{sse.DefaultTopic},
+	})
+	<-ctx.waitingOnDone
+
+	published := msg(t, "hello", "")
+	heartbeat := &sse.Message{}
+	heartbeat.AppendComment("ping")
+
+	for _, m := range []*sse.Message{published, msg(t, "explicit", "custom"), heartbeat} {
+		tests.Equal(t, j.Publish(m, []string{sse.DefaultTopic}), nil, "unexpected publish error")
+	}
+
+	tests.Equal(t, (<-received).ID, sse.ID("1"), "message should receive an ID")
+	tests.Equal(t, (<-received).ID, sse.ID("custom"), "IDFunc should be able to keep the ID")
+	tests.Equal(t, (<-received).ID.IsSet(), false, "heartbeat should not receive an ID")
+	tests.Equal(t, published.ID.IsSet(), false, "published message should not be modified")
+
+	var replayed []string
+	rp.ForEach(func(m *sse.Message, _ []string) { replayed = append(replayed, m.ID.String()) })
+	tests.DeepEqual(t, replayed, []string{"1", "custom"}, "replay provider should receive the IDs")
+}
+
+func TestJoe_Stream(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{AllowTopic: func(topic string) bool { return topic != "forbidden" }}
+
+	_, err := j.Stream(context.Background(), "forbidden")
+	tests.ErrorIs(t, err, sse.ErrUnknownTopic, "unknown topic should be rejected")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := j.Stream(ctx)
+	tests.Equal(t, err, nil, "unexpected stream error")
+
+	other, err := j.Stream(context.Background(), "other")
+	tests.Equal(t, err, nil, "unexpected stream error")
+
+	m := msg(t, "hello", "")
+	go func() { _ = j.Publish(m, []string{sse.DefaultTopic}) }()
+	tests.Equal(t, <-ch, m, "message should be received")
+
+	cancel()
+	_, ok := <-ch
+	tests.Expect(t, !ok, "channel should be closed when the context is done")
+
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "unexpected shutdown error")
+	_, ok = <-other
+	tests.Expect(t, !ok, "channel should be closed when Joe is stopped")
+
+	_, err = j.Stream(context.Background())
+	tests.ErrorIs(t, err, sse.ErrProviderClosed, "stream should fail after shutdown")
+}
+
+func TestJoe_Pause(t *testing.T) {
+	t.Parallel()
+
+	rp, _ := sse.NewFiniteReplayProvider(10, true)
+	j := &sse.Joe{ReplayProvider: rp}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	received := make(chan string, 10)
+	go j.Subscribe(ctx, sse.Subscription{ //nolint:errcheck // irrelevant
+		Client: mockClient(func(m *sse.Message) error {
+			if m != nil {
+				received <- m.ID.String()
+			}
+			return nil
+		}),
+		Topics: []string{sse.DefaultTopic},
+		ID:     "tab",
+	})
+	<-ctx.waitingOnDone
+
+	publish := func() {
+		tests.Equal(t, j.Publish(msg(t, "hello", ""), []string{sse.DefaultTopic}), nil, "unexpected publish error")
+	}
+
+	publish()
+	tests.Equal(t, j.Pause("tab"), nil, "unexpected pause error")
+	publish()
+	publish()
+	tests.Equal(t, j.SendTo("tab", msg(t, "", "direct")), nil, "unexpected send error")
+	tests.Equal(t, j.Resume("tab"), nil, "unexpected resume error")
+	tests.Equal(t, j.Resume("tab"), nil, "resuming again should do nothing")
+	publish()
+
+	tests.ErrorIs(t, j.Pause("missing"), sse.ErrSubscriberNotFound, "missing subscriber should be reported")
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		ids = append(ids, <-received)
+	}
+	tests.DeepEqual(t, ids, []string{"1", "2", "3", "4"}, "missed messages should be replayed on resume")
+	tests.Equal(t, len(received), 0, "no other messages should be received")
+}
+
+func TestJoe_Topics(t *testing.T) {
+	t.Parallel()
+
+	subscribed := make(chan struct{}, 3)
+	j := &sse.Joe{OnSubscribe: func(sse.Subscription) { subscribed <- struct{}{} }}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	tests.DeepEqual(t, j.Topics(), []string{}, "there should be no topics without subscribers")
+
+	client := mockClient(func(*sse.Message) error { return nil })
+	subscribe := func(sub sse.Subscription) (cancel func()) {
+		ctx, cancelCtx := context.WithCancel(context.Background())
+		sub.Client = client
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = j.Subscribe(ctx, sub)
+		}()
+		<-subscribed
+		return func() { cancelCtx(); <-done }
+	}
+
+	defer subscribe(sse.Subscription{Topics: []string{"b", "a"}})()
+	unsubscribe := subscribe(sse.Subscription{Topics: []string{"c", "b"}})
+	defer subscribe(sse.Subscription{Topics: []string{"ignored"}, AllTopics: true})()
+
+	tests.DeepEqual(t, j.Topics(), []string{"a", "b", "c"}, "invalid topics")
+
+	unsubscribe()
+	tests.DeepEqual(t, j.Topics(), []string{"a", "b"}, "topics without subscribers should not be returned")
+
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "shutdown should succeed")
+	tests.Equal(t, len(j.Topics()), 0, "there should be no topics after shutdown")
+}
+
+func TestJoe_CloseTopic(t *testing.T) {
+	t.Parallel()
+
+	subscribed := make(chan struct{}, 3)
+	j := &sse.Joe{OnSubscribe: func(sse.Subscription) { subscribed <- struct{}{} }}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	client := mockClient(func(*sse.Message) error { return nil })
+	subscribe := func(sub sse.Subscription) <-chan error {
+		sub.Client = client
+		done := make(chan error, 1)
+		go func() { done <- j.Subscribe(context.Background(), sub) }()
+		<-subscribed
+		return done
+	}
+
+	single := subscribe(sse.Subscription{Topics: []string{"maintenance"}})
+	multiple := subscribe(sse.Subscription{Topics: []string{"other", "maintenance"}})
+	all := subscribe(sse.Subscription{AllTopics: true})
+	other := subscribe(sse.Subscription{Topics: []string{"other"}})
+
+	n, err := j.CloseTopic("maintenance")
+	tests.Equal(t, err, nil, "unexpected close error")
+	tests.Equal(t, n, 2, "subscribers to the topic should be removed")
+	tests.ErrorIs(t, <-single, sse.ErrTopicClosed, "invalid subscribe error")
+	tests.ErrorIs(t, <-multiple, sse.ErrTopicClosed, "subscribers to multiple topics should be removed")
+	tests.DeepEqual(t, j.Topics(), []string{"other"}, "other subscribers should not be removed")
+
+	n, err = j.CloseTopic("maintenance")
+	tests.Equal(t, err, nil, "unexpected close error")
+	tests.Equal(t, n, 0, "no subscribers should be left")
+
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "shutdown should succeed")
+	tests.ErrorIs(t, <-all, sse.ErrProviderClosed, "subscribers to all topics should not be removed")
+	tests.ErrorIs(t, <-other, sse.ErrProviderClosed, "subscribers to other topics should not be removed")
+
+	_, err = j.CloseTopic("other")
+	tests.ErrorIs(t, err, sse.ErrProviderClosed, "close should fail after shutdown")
+}
+
+func TestJoe_HasSubscribers(t *testing.T) {
+	t.Parallel()
+
+	subscribed := make(chan struct{}, 1)
+	j := &sse.Joe{OnSubscribe: func(sse.Subscription) { subscribed <- struct{}{} }}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	tests.Expect(t, !j.HasSubscribers(sse.DefaultTopic), "there should be no subscribers")
+
+	built := 0
+	build := func() *sse.Message {
+		built++
+		return msg(t, "expensive", "")
+	}
+
+	tests.Equal(t, j.PublishFunc(build, []string{sse.DefaultTopic}), nil, "unexpected publish error")
+	tests.Equal(t, built, 0, "message should not be built without subscribers")
+	tests.ErrorIs(t, j.PublishFunc(build, nil), sse.ErrNoTopic, "topics should be required")
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	received := make(chan *sse.Message, 1)
+	go func() {
+		_ = j.Subscribe(ctx, sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					received <- m
+				}
+				return nil
+			}),
+			Topics: []string{"t"},
+		})
+	}()
+	<-subscribed
+
+	tests.Expect(t, j.HasSubscribers("t"), "topic should have subscribers")
+	tests.Expect(t, !j.HasSubscribers(sse.DefaultTopic), "other topic should not have subscribers")
+
+	tests.Equal(t, j.PublishFunc(build, []string{sse.DefaultTopic, "t"}), nil, "unexpected publish error")
+	tests.Equal(t, built, 1, "message should be built for subscribers")
+	tests.Equal(t, (<-received).String(), "data: expensive\n\n", "built message should be received")
+
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "shutdown should succeed")
+	tests.Expect(t, !j.HasSubscribers("t"), "there should be no subscribers after shutdown")
+	tests.ErrorIs(t, j.PublishFunc(build, []string{"t"}), sse.ErrProviderClosed, "publish should fail after shutdown")
+}
+
+func TestJoe_MaxSubscribersPerTopic(t *testing.T) {
+	t.Parallel()
+
+	subscribed := make(chan struct{}, 1)
+	j := &sse.Joe{
+		MaxSubscribersPerTopic: 1,
+		OnSubscribe:            func(sse.Subscription) { subscribed <- struct{}{} },
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := mockClient(func(*sse.Message) error { return nil })
+
+	go func() { _ = j.Subscribe(ctx, sse.Subscription{Client: client, Topics: []string{"a"}}) }()
+	<-subscribed
+
+	err := j.Subscribe(context.Background(), sse.Subscription{Client: client, Topics: []string{"b", "a"}})
+	tests.ErrorIs(t, err, sse.ErrTopicFull, "subscription with a full topic should be rejected")
+	tests.Expect(t, !j.HasSubscribers("b"), "rejected subscription should not subscribe to other topics")
+
+	go func() { _ = j.Subscribe(ctx, sse.Subscription{Client: client, Topics: []string{"b"}}) }()
+	<-subscribed
+	go func() { _ = j.Subscribe(ctx, sse.Subscription{Client: client, AllTopics: true}) }()
+	<-subscribed
+}
+
+func TestJoe_SubscriptionTTL(t *testing.T) {
+	t.Parallel()
+
+	unsubscribed := make(chan struct{}, 1)
+	j := &sse.Joe{OnUnsubscribe: func(sse.Subscription) { unsubscribed <- struct{}{} }}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	sub := sse.Subscription{
+		Client: mockClient(func(*sse.Message) error { return nil }),
+		Topics: []string{sse.DefaultTopic},
+		TTL:    time.Millisecond,
+	}
+	tests.Equal(t, j.Subscribe(context.Background(), sub), nil, "expired subscription should be removed without error")
+	<-unsubscribed
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sub.TTL = time.Hour
+	tests.Equal(t, j.Subscribe(ctx, sub), nil, "subscription should be removed when the context is done")
+	<-unsubscribed
+	tests.Expect(t, !j.HasSubscribers(sse.DefaultTopic), "there should be no subscribers left")
+}
+
+func TestJoe_AsyncReplay(t *testing.T) {
+	t.Parallel()
+
+	rp, err := sse.NewFiniteReplayProvider(10, true)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	subscribed := make(chan struct{}, 1)
+	j := &sse.Joe{
+		ReplayProvider: rp,
+		AsyncReplay:    true,
+		OnSubscribe:    func(sse.Subscription) { subscribed <- struct{}{} },
+	}
+
+	topics := []string{sse.DefaultTopic}
+	_ = j.Publish(msg(t, "a", ""), topics)
+	_ = j.Publish(msg(t, "b", ""), topics)
+
+	live := make(chan string, 1)
+	liveDone := make(chan error, 1)
+	go func() {
+		liveDone <- j.Subscribe(context.Background(), sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					live <- m.String()
+				}
+				return nil
+			}),
+			Topics: topics,
+		})
+	}()
+	<-subscribed
+
+	replaying, release := make(chan struct{}), make(chan struct{})
+	var slow []string
+	slowDone := make(chan error, 1)
+	go func() {
+		slowDone <- j.Subscribe(context.Background(), sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m == nil {
+					return nil
+				}
+				if len(slow) == 0 {
+					close(replaying)
+					<-release
+				}
+				slow = append(slow, m.String())
+				return nil
+			}),
+			LastEventID: sse.ID("1"),
+			Topics:      topics,
+			ReplayDone:  &sse.Message{Type: sse.Type("replayed")},
+		})
+	}()
+	<-replaying
+
+	_ = j.Publish(msg(t, "c", ""), topics)
+	tests.Equal(t, <-live, "id: 3\ndata: c\n\n", "live subscriber should not wait for the replay")
+
+	close(release)
+	<-subscribed
+	_ = j.Publish(msg(t, "d", ""), topics)
+	tests.Equal(t, <-live, "id: 4\ndata: d\n\n", "live subscriber should receive all messages")
+
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "shutdown should succeed")
+	tests.ErrorIs(t, <-liveDone, sse.ErrProviderClosed, "invalid subscribe error")
+	tests.ErrorIs(t, <-slowDone, sse.ErrProviderClosed, "invalid subscribe error")
+	tests.DeepEqual(t, slow, []string{
+		"id: 2\ndata: b\n\n",
+		"event: replayed\n\n",
+		"id: 3\ndata: c\n\n",
+		"id: 4\ndata: d\n\n",
+	}, "messages published during the replay should be sent after it, in order")
+}
+
+func TestJoe_AsyncReplay_unsubscribe(t *testing.T) {
+	t.Parallel()
+
+	rp, err := sse.NewFiniteReplayProvider(10, true)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	tracer := &mockTracer{}
+	j := &sse.Joe{ReplayProvider: rp, AsyncReplay: true, Tracer: tracer}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	topics := []string{sse.DefaultTopic}
+	for i := 0; i < 3; i++ {
+		_ = j.Publish(msg(t, "hello", ""), topics)
+	}
+
+	for _, shutdown := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		replaying := make(chan struct{})
+		var sent, afterReturn atomic.Int32
+		var returned atomic.Bool
+
+		done := make(chan error, 1)
+		go func() {
+			done <- j.Subscribe(ctx, sse.Subscription{
+				Client: mockClient(func(m *sse.Message) error {
+					if returned.Load() {
+						afterReturn.Add(1)
+					}
+					if m != nil && sent.Add(1) == 1 {
+						close(replaying)
+						<-ctx.Done()
+					}
+					return nil
+				}),
+				LastEventID: sse.ID("1"),
+				Topics:      topics,
+			})
+			returned.Store(true)
+		}()
+		<-replaying
+
+		if shutdown {
+			stopped := make(chan error)
+			go func() { stopped <- j.Shutdown(context.Background()) }()
+			// The replay is stopped, but sending the message in progress must end first.
+			cancel()
+			tests.Equal(t, <-stopped, nil, "shutdown should wait for the replay")
+			err := <-done
+			tests.Expect(t, err == nil || errors.Is(err, sse.ErrProviderClosed), "invalid subscribe error %v", err)
+		} else {
+			cancel()
+			tests.Equal(t, <-done, nil, "replay should be stopped when unsubscribing")
+		}
+
+		tests.Equal(t, afterReturn.Load(), int32(0), "nothing should be sent after Subscribe returns")
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+
+	for _, s := range tracer.spans {
+		tests.Expect(t, s.end != nil, "span %q should be ended", s.name)
+	}
+}
+
+func TestJoe_Retain(t *testing.T) {
+	t.Parallel()
+
+	rp, err := sse.NewFiniteReplayProvider(10, true)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	subscribed := make(chan struct{}, 1)
+	j := &sse.Joe{
+		ReplayProvider: rp,
+		Retain:         func(topic string) bool { return topic != "live" },
+		OnSubscribe:    func(sse.Subscription) { subscribed <- struct{}{} },
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	publish := func(topics ...string) {
+		tests.Equal(t, j.Publish(msg(t, "hello", ""), topics), nil, "unexpected publish error")
+	}
+	publish("a")
+	publish("b")
+	publish("a")
+	publish("live")
+	tests.Equal(t, j.Publish(&sse.Message{}, []string{"a"}), nil, "unexpected publish error")
+
+	received := func(sub sse.Subscription) []string {
+		var ids []string
+		sub.Client = mockClient(func(m *sse.Message) error {
+			if m != nil {
+				ids = append(ids, m.ID.String())
+			}
+			return nil
+		})
+		sub.ReplayDone = msg(t, "", "done")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = j.Subscribe(ctx, sub)
+		}()
+		<-subscribed
+		cancel()
+		<-done
+
+		return ids
+	}
+
+	tests.DeepEqual(t, received(sse.Subscription{Topics: []string{"a", "b"}}), []string{"2", "3", "done"}, "retained messages should be sent in publish order")
+	tests.DeepEqual(t, received(sse.Subscription{Topics: []string{"a"}, LastEventID: sse.ID("1")}), []string{"3", "done"}, "retained messages should not be sent after a replay")
+	tests.DeepEqual(t, received(sse.Subscription{Topics: []string{"a"}, LastEventID: sse.ID("unknown")}), []string{"3", "done"}, "retained messages should be sent for invalid IDs")
+	tests.DeepEqual(t, received(sse.Subscription{Topics: []string{"live"}}), []string{"done"}, "messages should be retained only for the chosen topics")
+	tests.DeepEqual(t, received(sse.Subscription{AllTopics: true}), []string{"2", "3", "done"}, "all topics subscribers should receive all retained messages")
+}
+
+func TestJoe_QueueFull(t *testing.T) {
+	t.Parallel()
+
+	for policy, expected := range map[sse.OverflowPolicy][]string{
+		sse.OverflowDropOldest: {"1", "3", "4"},
+		sse.OverflowDropNewest: {"1", "2", "3"},
+	} {
+		subscribed := make(chan struct{})
+		j := &sse.Joe{
+			QueueSize:   2,
+			QueueFull:   policy,
+			OnSubscribe: func(sse.Subscription) { close(subscribed) },
+		}
+
+		received, release := make(chan string, 10), make(chan struct{})
+		go j.Subscribe(context.Background(), sse.Subscription{ //nolint:errcheck // irrelevant
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					received <- m.ID.String()
+					<-release
+				}
+				return nil
+			}),
+			Topics: []string{sse.DefaultTopic},
+		})
+		<-subscribed
+
+		publish := func(id string) error { return j.Publish(msg(t, "hello", id), []string{sse.DefaultTopic}) }
+
+		tests.Equal(t, publish("1"), nil, "unexpected publish error")
+		// Joe is now blocked sending the first message, so the others are queued.
+		ids := []string{<-received}
+		tests.Equal(t, publish("2"), nil, "unexpected publish error")
+		tests.Equal(t, publish("3"), nil, "unexpected publish error")
+
+		err := publish("4")
+		if policy == sse.OverflowDropNewest {
+			tests.ErrorIs(t, err, sse.ErrQueueFull, "full queue should be reported")
+		} else {
+			tests.Equal(t, err, nil, "oldest message should be dropped")
+		}
+
+		close(release)
+		for len(ids) < len(expected) {
+			ids = append(ids, <-received)
+		}
+		tests.DeepEqual(t, ids, expected, "invalid messages received")
+
+		tests.Equal(t, j.Shutdown(context.Background()), nil, "shutdown should succeed")
+	}
+}
+
+func TestJoe_ReplayTo(t *testing.T) {
+	t.Parallel()
+
+	rp, _ := sse.NewFiniteReplayProvider(10, true)
+	j := &sse.Joe{ReplayProvider: rp}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	received := make(chan string, 10)
+	go j.Subscribe(ctx, sse.Subscription{ //nolint:errcheck // irrelevant
+		Client: mockClient(func(m *sse.Message) error {
+			if m != nil {
+				received <- m.ID.String()
+			}
+			return nil
+		}),
+		Topics: []string{sse.DefaultTopic},
+		ID:     "tab",
+	})
+	<-ctx.waitingOnDone
+
+	for i := 0; i < 3; i++ {
+		tests.Equal(t, j.Publish(msg(t, "hello", ""), []string{sse.DefaultTopic}), nil, "unexpected publish error")
+	}
+	tests.Equal(t, j.ReplayTo("tab", sse.ID("1")), nil, "unexpected replay error")
+	tests.ErrorIs(t, j.ReplayTo("missing", sse.ID("1")), sse.ErrSubscriberNotFound, "missing subscriber should be reported")
+
+	tests.Equal(t, j.Pause("tab"), nil, "unexpected pause error")
+	tests.Equal(t, j.ReplayTo("tab", sse.ID("1")), nil, "unexpected replay error")
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ids = append(ids, <-received)
+	}
+	tests.DeepEqual(t, ids, []string{"1", "2", "3", "2", "3"}, "messages should be replayed after the given ID")
+	tests.Equal(t, len(received), 0, "paused subscribers should not be replayed to")
+}
+
+func TestJoe_PublishUrgent(t *testing.T) {
+	t.Parallel()
+
+	subscribed := make(chan struct{})
+	j := &sse.Joe{
+		QueueSize:   2,
+		QueueFull:   sse.OverflowDropNewest,
+		OnSubscribe: func(sse.Subscription) { close(subscribed) },
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	received, release := make(chan string, 10), make(chan struct{})
+	go j.Subscribe(context.Background(), sse.Subscription{ //nolint:errcheck // irrelevant
+		Client: mockClient(func(m *sse.Message) error {
+			if m != nil {
+				received <- m.ID.String()
+				<-release
+			}
+			return nil
+		}),
+		Topics: []string{sse.DefaultTopic},
+	})
+	<-subscribed
+
+	topics := []string{sse.DefaultTopic}
+	tests.Equal(t, j.Publish(msg(t, "hello", "1"), topics), nil, "unexpected publish error")
+	ids := []string{<-received}
+	tests.Equal(t, j.Publish(msg(t, "hello", "2"), topics), nil, "unexpected publish error")
+	tests.Equal(t, j.Publish(msg(t, "hello", "3"), topics), nil, "unexpected publish error")
+	tests.Equal(t, j.PublishUrgent(msg(t, "hello", "urgent"), topics), nil, "urgent message should not be dropped")
+	tests.ErrorIs(t, j.PublishUrgent(msg(t, "hello", "4"), nil), sse.ErrNoTopic, "topics should be required")
+
+	close(release)
+	for len(ids) < 4 {
+		ids = append(ids, <-received)
+	}
+	tests.DeepEqual(t, ids, []string{"1", "urgent", "2", "3"}, "urgent message should be sent before the queued ones")
+}
+
+func TestJoe_ExportSubscribers(t *testing.T) {
+	t.Parallel()
+
+	rp, _ := sse.NewFiniteReplayProvider(10, true)
+	subscribed := make(chan struct{}, 1)
+	j := &sse.Joe{
+		ReplayProvider: rp,
+		OnSubscribe:    func(sse.Subscription) { subscribed <- struct{}{} },
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	subscribe := func(sub sse.Subscription) {
+		sub.Client = mockClient(func(*sse.Message) error { return nil })
+		go j.Subscribe(context.Background(), sub) //nolint:errcheck // irrelevant
+		<-subscribed
+	}
+	export := func() []sse.SubscriberState {
+		states := j.ExportSubscribers()
+		sort.Slice(states, func(a, b int) bool { return states[a].ID < states[b].ID })
+		return states
+	}
+
+	subscribe(sse.Subscription{ID: "a", Topics: []string{"x"}})
+	subscribe(sse.Subscription{ID: "b", Topics: []string{"y"}, LastEventID: sse.ID("unknown")})
+	subscribe(sse.Subscription{ID: "c", AllTopics: true})
+
+	tests.Equal(t, j.Publish(msg(t, "hello", ""), []string{"x"}), nil, "unexpected publish error")
+	tests.Equal(t, j.Publish(msg(t, "hello", ""), []string{"y"}), nil, "unexpected publish error")
+	tests.Equal(t, j.Publish(msg(t, "hello", ""), []string{"x"}), nil, "unexpected publish error")
+
+	subscribe(sse.Subscription{ID: "d", Topics: []string{"y"}, LastEventID: sse.ID("1")})
+	tests.Equal(t, j.Pause("a"), nil, "unexpected pause error")
+
+	tests.DeepEqual(t, export(), []sse.SubscriberState{
+		{ID: "a", Topics: []string{"x"}, LastEventID: sse.ID("3"), Paused: true},
+		{ID: "b", Topics: []string{"y"}, LastEventID: sse.ID("2")},
+		{ID: "c", LastEventID: sse.ID("3"), AllTopics: true},
+		{ID: "d", Topics: []string{"y"}, LastEventID: sse.ID("2")},
+	}, "invalid exported subscribers")
 
 	tests.Equal(t, j.Shutdown(context.Background()), nil, "shutdown should succeed")
-	tests.Equal(t, <-suberr, nil, "unexpected subscribe error")
+	tests.Equal(t, len(j.ExportSubscribers()), 0, "nothing should be exported after shutdown")
 }
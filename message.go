@@ -2,14 +2,15 @@ package sse
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
-	"unsafe"
 
 	"github.com/tmaxmax/go-sse/internal/parser"
 )
@@ -35,27 +36,52 @@ type chunk struct {
 
 var newline = []byte{'\n'}
 
-func (c *chunk) WriteTo(w io.Writer) (int64, error) {
-	name := fieldBytesData
+// appendTo appends the chunk to b, using the given field name, followed by a colon, for data.
+func (c *chunk) appendTo(b, dataName []byte) []byte {
+	name := dataName
 	if c.isComment {
 		name = fieldBytesComment
 	}
-	n, err := w.Write(name)
-	if err != nil {
-		return int64(n), err
-	}
-	m, err := writeString(w, c.content)
-	n += m
-	if err != nil {
-		return int64(n), err
-	}
-	m, err = w.Write(newline)
-	return int64(n + m), err
+	b = append(b, name...)
+	b = append(b, c.content...)
+	return append(b, '\n')
+}
+
+// extensionField is a field with a non-standard name, set using Message.SetField.
+type extensionField struct {
+	name  string
+	value string
+}
+
+func (f *extensionField) appendTo(b []byte) []byte {
+	b = append(b, f.name...)
+	b = append(b, fieldBytesComment...)
+	b = append(b, f.value...)
+	return append(b, '\n')
 }
 
 // Message is the representation of an event sent from the server to its clients.
+//
+// Messages without data, such as
+//
+//	id: 5
+//	event: refresh
+//
+// are valid events and are written with all their other fields. Be aware, though, that
+// browsers don't dispatch events without data – they only update the last event ID and
+// the reconnection time. For signals which browsers must receive, append an empty
+// data field using AppendData("\n"):
+//
+//	id: 5
+//	event: refresh
+//	data:
 type Message struct {
 	chunks []chunk
+	fields []extensionField
+	// dataReader is the source of the data streamed after the chunks, see SetDataReader.
+	dataReader io.Reader
+	// variants are the data of the message encoded for other content types, see AppendVariantData.
+	variants []messageVariant
 
 	ID    EventID
 	Type  EventType
@@ -111,128 +137,438 @@ func (e *Message) appendText(isComment bool, chunks ...string) {
 // any newline characters (\r or \n) and then append the resulted data.
 //
 // Given that clients treat all newlines the same and replace the original newlines with LF,
-// for internal code simplicity AppendData replaces them aswell.
+// for internal code simplicity AppendData replaces them aswell. Empty strings add no data
+// fields, while a single newline adds one empty data field.
 func (e *Message) AppendData(chunks ...string) {
 	e.appendText(false, chunks...)
 }
 
 // AppendComment adds comment fields to the message's event.
 // If the comments span multiple lines, they are broken into multiple comment fields.
+// Each comment, and each line of a comment, is written on its own line, prefixed by a colon:
+//
+//	e.AppendComment("first", "second\nthird")
+//
+// is written as
+//
+//	: first
+//	: second
+//	: third
+//
+// Comments and data are written in the order they are appended. Empty strings add no comments.
 func (e *Message) AppendComment(comments ...string) {
 	e.appendText(true, comments...)
 }
 
-func (e *Message) writeMessageField(w io.Writer, f messageField, fieldBytes []byte) (int64, error) {
-	if !f.IsSet() {
-		return 0, nil
+// AppendComments adds each of the lines as a comment field, in order. It is the same as AppendComment,
+// named for appending multiple lines at once, for example structured debug annotations:
+//
+//	e.AppendComments("trace: abc", "took: 3ms")
+//
+// Lines which contain newlines are still split, so each comment field is a single line.
+func (e *Message) AppendComments(lines ...string) {
+	e.AppendComment(lines...)
+}
+
+// AppendInt adds a data field with the decimal representation of the integer.
+func (e *Message) AppendInt(v int64) {
+	e.chunks = append(e.chunks, chunk{content: strconv.FormatInt(v, 10)})
+}
+
+// AppendFloat adds a data field with the representation of the floating-point number
+// given by strconv.FormatFloat(v, 'g', -1, 64): the shortest one which parses back
+// to the same number, in exponent notation for large and small exponents – for example,
+// 0.1, 1e+21 and 1e-07. NaN and infinities are written as NaN, +Inf and -Inf.
+func (e *Message) AppendFloat(v float64) {
+	e.chunks = append(e.chunks, chunk{content: strconv.FormatFloat(v, 'g', -1, 64)})
+}
+
+// AppendBool adds a data field with the value "true" or "false".
+func (e *Message) AppendBool(v bool) {
+	e.chunks = append(e.chunks, chunk{content: strconv.FormatBool(v)})
+}
+
+// SetField sets the value of a field with a non-standard name, which is written as
+//
+//	name: value
+//
+// after the standard ID, event and retry fields. Setting a field which was already set
+// replaces its value; the fields are written in the order they were first set.
+//
+// Browsers and other spec-compliant clients ignore fields with unknown names, so these are
+// useful only for custom consumers, such as proxies or proprietary SSE dialects.
+//
+// The name must not be empty, must not contain colons or newlines and must not be one of the
+// standard field names (data, event, id or retry). The value must not have newlines.
+// If either is invalid, an error is returned and the message is not modified.
+func (e *Message) SetField(name, value string) error {
+	if name == "" || strings.IndexByte(name, ':') != -1 || !isSingleLine(name) {
+		return fmt.Errorf("go-sse: invalid field name %q", name)
 	}
 
-	n, err := w.Write(fieldBytes)
-	if err != nil {
-		return int64(n), err
+	switch parser.FieldName(name) { //nolint:exhaustive // Comment is not a valid name here.
+	case parser.FieldNameData, parser.FieldNameEvent, parser.FieldNameID, parser.FieldNameRetry:
+		return fmt.Errorf("go-sse: field name %q is a standard field name", name)
 	}
-	m, err := writeString(w, f.String())
-	n += m
-	if err != nil {
-		return int64(n), err
+
+	if !isSingleLine(value) {
+		return fmt.Errorf("go-sse: value of field %q is multiline", name)
 	}
-	m, err = w.Write(newline)
-	return int64(n + m), err
+
+	e.setField(name, value)
+
+	return nil
 }
 
-func (e *Message) writeID(w io.Writer) (int64, error) {
-	return e.writeMessageField(w, e.ID.messageField, fieldBytesID)
+func (e *Message) setField(name, value string) {
+	for i := range e.fields {
+		if e.fields[i].name == name {
+			e.fields[i].value = value
+			return
+		}
+	}
+
+	e.fields = append(e.fields, extensionField{name: name, value: value})
 }
 
-func (e *Message) writeType(w io.Writer) (int64, error) {
-	return e.writeMessageField(w, e.Type.messageField, fieldBytesEvent)
+// Field returns the value of a field with a non-standard name. The boolean is false if the
+// field is not set. See SetField.
+func (e *Message) Field(name string) (string, bool) {
+	for i := range e.fields {
+		if e.fields[i].name == name {
+			return e.fields[i].value, true
+		}
+	}
+
+	return "", false
 }
 
-func (e *Message) writeRetry(w io.Writer) (int64, error) {
-	millis := e.Retry.Milliseconds()
-	if millis <= 0 {
-		return 0, nil
+// isHeartbeat reports whether the message has no fields other than comments.
+func (e *Message) isHeartbeat() bool {
+	if e.ID.IsSet() || e.Type.IsSet() || e.Retry > 0 || len(e.fields) > 0 || e.dataReader != nil {
+		return false
 	}
 
-	n, err := w.Write(fieldBytesRetry)
-	if err != nil {
-		return int64(n), err
+	for i := range e.chunks {
+		if !e.chunks[i].isComment {
+			return false
+		}
+	}
+
+	return true
+}
+
+func appendMessageField(b []byte, f messageField, fieldBytes []byte) []byte {
+	if !f.IsSet() {
+		return b
 	}
 
-	var buf [13]byte // log10(INT64_MAX / 1e6) ~= 13
+	b = append(b, fieldBytes...)
+	b = append(b, f.String()...)
+	return append(b, '\n')
+}
 
-	i := len(buf) - 1
-	for millis != 0 {
-		buf[i] = '0' + byte(millis%10)
-		i--
-		millis /= 10
+func (e *Message) appendRetry(b []byte) []byte {
+	millis := e.Retry.Milliseconds()
+	if millis <= 0 {
+		return b
 	}
 
-	m, err := w.Write(buf[i+1:])
-	n += m
-	if err != nil {
-		return int64(n), err
+	b = append(b, fieldBytesRetry...)
+	b = strconv.AppendInt(b, millis, 10)
+	return append(b, '\n')
+}
+
+// appendTo appends the standard textual representation of the message's event to b.
+func (e *Message) appendTo(b []byte) []byte {
+	start := len(b)
+	if b = e.appendFields(b, fieldBytesData); len(b) == start {
+		return b
 	}
-	m, err = w.Write(newline)
-	return int64(n + m), err
+	return append(b, '\n')
 }
 
+// appendFields appends the message's fields to b, without the blank line which ends the event.
+// The data fields are written using the given field name, followed by a colon.
+func (e *Message) appendFields(b, dataName []byte) []byte {
+	b = appendMessageField(b, e.ID.messageField, fieldBytesID)
+	b = appendMessageField(b, e.Type.messageField, fieldBytesEvent)
+	b = e.appendRetry(b)
+	for i := range e.fields {
+		b = e.fields[i].appendTo(b)
+	}
+	for i := range e.chunks {
+		b = e.chunks[i].appendTo(b, dataName)
+	}
+	return b
+}
+
+// writeBuffers holds the buffers WriteTo assembles the messages in.
+var writeBuffers = sync.Pool{New: func() any { return new([]byte) }}
+
+// maxPooledBufferSize is the maximum capacity of the buffers put back into the pool,
+// so a single large message doesn't keep a large buffer alive.
+const maxPooledBufferSize = 64 << 10
+
 // WriteTo writes the standard textual representation of the message's event to an io.Writer.
 // This operation is heavily optimized, so it is strongly preferred over MarshalText or String.
+//
+// The event is assembled in a pooled buffer and written using a single Write call,
+// which matters when writing directly to network connections. The buffer is reused
+// after WriteTo returns, so, as required by io.Writer, w must not retain it.
 func (e *Message) WriteTo(w io.Writer) (int64, error) {
-	n, err := e.writeID(w)
-	if err != nil {
-		return n, err
+	return e.writeTo(w, nil, fieldBytesData)
+}
+
+// MessageWriteOptions changes how Message.WriteToWith writes messages.
+type MessageWriteOptions struct {
+	// The name the data fields are written with, instead of "data", for consumers which
+	// use a non-standard name for them. Clients which follow the specification, such as
+	// browsers, ignore fields with other names. It must not contain colons or newlines and
+	// must not be the name of another standard field. If empty, "data" is used.
+	DataFieldName string
+	// The line ending each field and the blank line which ends the event are written with,
+	// for consumers which expect a specific one. It must be "\n", "\r\n" or "\r", which are
+	// all valid according to the specification. If empty, "\n" is used.
+	LineEnding string
+}
+
+// WriteToWith writes the message to w, just like WriteTo, using the given options.
+// It returns an error without writing anything if the options are invalid.
+func (e *Message) WriteToWith(w io.Writer, opts MessageWriteOptions) (int64, error) {
+	dataName := fieldBytesData
+	if name := opts.DataFieldName; name != "" {
+		if strings.IndexByte(name, ':') != -1 || !isSingleLine(name) {
+			return 0, fmt.Errorf("go-sse: invalid data field name %q", name)
+		}
+		switch parser.FieldName(name) { //nolint:exhaustive // Comment is not a valid name here.
+		case parser.FieldNameEvent, parser.FieldNameID, parser.FieldNameRetry:
+			return 0, fmt.Errorf("go-sse: data field name %q is another standard field name", name)
+		}
+
+		dataName = []byte(name + ": ")
 	}
-	m, err := e.writeType(w)
-	n += m
-	if err != nil {
-		return n, err
+
+	switch opts.LineEnding {
+	case "", "\n":
+		return e.writeTo(w, nil, dataName)
+	case "\r\n", "\r":
+	default:
+		return 0, fmt.Errorf("go-sse: invalid line ending %q", opts.LineEnding)
+	}
+
+	cw := &byteCounter{w: w}
+	_, err := e.writeTo(&lineEndingWriter{w: cw, ending: opts.LineEnding}, nil, dataName)
+
+	return cw.n, err
+}
+
+// lineEndingWriter writes to w the bytes written to it, with each LF replaced by the given line ending.
+// The messages' fields never contain newlines, so each LF written by writeTo ends a line.
+type lineEndingWriter struct {
+	w      io.Writer
+	ending string
+	buf    []byte
+}
+
+func (l *lineEndingWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	l.buf = l.buf[:0]
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i == -1 {
+			break
+		}
+
+		l.buf = append(l.buf, p[:i]...)
+		l.buf = append(l.buf, l.ending...)
+		p = p[i+1:]
+	}
+	l.buf = append(l.buf, p...)
+
+	if _, err := l.w.Write(l.buf); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// writeTo writes the message, preceded by the given fields, using the given
+// data field name, followed by a colon. Nothing is written if the message is empty.
+func (e *Message) writeTo(w io.Writer, fields []extensionField, dataName []byte) (int64, error) {
+	if e.dataReader != nil {
+		return e.writeStreamed(w, fields, dataName)
+	}
+
+	bp := writeBuffers.Get().(*[]byte) //nolint:forcetypeassert // The pool has only this type.
+	b := (*bp)[:0]
+	for i := range fields {
+		b = fields[i].appendTo(b)
+	}
+	start := len(b)
+	if b = e.appendFields(b, dataName); len(b) == start {
+		// The message is empty, so the fields aren't written either.
+		b = b[:0]
+	} else {
+		b = append(b, '\n')
+	}
+
+	var n int
+	var err error
+	if len(b) > 0 {
+		n, err = w.Write(b)
+	}
+
+	if cap(b) <= maxPooledBufferSize {
+		*bp = b[:0]
+		writeBuffers.Put(bp)
 	}
-	m, err = e.writeRetry(w)
-	n += m
+
+	return int64(n), err
+}
+
+// WriteAndFlush writes the message to w, just like WriteTo, and then flushes w. This is useful
+// with buffered writers, such as a bufio.Writer wrapping a network connection, where a successful
+// write doesn't mean the message reached its destination.
+//
+// If the message is written successfully but flushing fails, the error is a *FlushError,
+// so broken connections can be told apart from write errors.
+func (e *Message) WriteAndFlush(w interface {
+	io.Writer
+	Flush() error
+},
+) (int64, error) {
+	n, err := e.WriteTo(w)
 	if err != nil {
 		return n, err
 	}
+	if err := w.Flush(); err != nil {
+		return n, &FlushError{Err: err}
+	}
+	return n, nil
+}
+
+// ErrEmptyMessage is returned by Message.Validate for messages which have no fields,
+// for which WriteTo writes nothing.
+var ErrEmptyMessage = errors.New("go-sse: message is empty")
+
+// Validate reports whether the message is written cleanly by WriteTo and received by clients
+// as it is, without writing it anywhere. It returns the first problem found, or nil:
+//
+//   - ErrEmptyMessage, if the message has no fields at all – messages with only comments are valid;
+//   - an error if the ID contains a NUL character, as clients ignore such IDs;
+//   - an error if any field is not valid UTF-8, as clients replace the invalid bytes.
+//
+// Newlines need not be checked, as the message's fields can't have any.
+func (e *Message) Validate() error {
+	if len(e.chunks) == 0 && len(e.fields) == 0 && e.dataReader == nil && !e.ID.IsSet() && !e.Type.IsSet() && e.Retry.Milliseconds() <= 0 {
+		return ErrEmptyMessage
+	}
+
+	if strings.IndexByte(e.ID.value, 0) != -1 {
+		return fmt.Errorf("go-sse: event ID %q contains a NUL character", e.ID.value)
+	}
+	if !utf8.ValidString(e.ID.value) {
+		return fmt.Errorf("go-sse: event ID %q is not valid UTF-8", e.ID.value)
+	}
+	if !utf8.ValidString(e.Type.value) {
+		return fmt.Errorf("go-sse: event type %q is not valid UTF-8", e.Type.value)
+	}
+
+	for i := range e.fields {
+		if f := &e.fields[i]; !utf8.ValidString(f.value) {
+			return fmt.Errorf("go-sse: value of field %q is not valid UTF-8", f.name)
+		}
+	}
+
 	for i := range e.chunks {
-		m, err = e.chunks[i].WriteTo(w)
-		n += m
-		if err != nil {
-			return n, err
+		if c := &e.chunks[i]; !utf8.ValidString(c.content) {
+			if c.isComment {
+				return fmt.Errorf("go-sse: comment %q is not valid UTF-8", c.content)
+			}
+			return fmt.Errorf("go-sse: data %q is not valid UTF-8", c.content)
 		}
 	}
-	if n == 0 {
+
+	return nil
+}
+
+// NewMessageReader returns a reader of the event stream made of the messages received
+// from the channel. Each message is written, in the standard textual representation,
+// only when the reader needs more bytes, so Read blocks while waiting for a message.
+// Empty and nil messages are skipped. Messages are written using WriteTo, so the data of
+// messages with a data reader (see SetDataReader) is streamed too; if reading it fails,
+// Read returns the error and the rest of that message is dropped. After the channel is closed
+// and all the messages are read, Read returns io.EOF.
+//
+// Use it to pass an event stream to code which expects an io.Reader, such as an HTTP
+// response body or a Client in tests.
+func NewMessageReader(ch <-chan *Message) io.Reader {
+	return &messageReader{ch: ch}
+}
+
+type messageReader struct {
+	ch  <-chan *Message
+	buf bytes.Buffer
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
 		return 0, nil
 	}
-	o, err := w.Write(newline)
-	return int64(o) + n, err
+
+	for r.buf.Len() == 0 {
+		m, ok := <-r.ch
+		if !ok {
+			return 0, io.EOF
+		}
+		if m == nil {
+			continue
+		}
+
+		r.buf.Reset()
+		if _, err := m.WriteTo(&r.buf); err != nil {
+			r.buf.Reset()
+			return 0, err
+		}
+	}
+
+	return r.buf.Read(p)
+}
+
+// FlushError is returned by Message.WriteAndFlush when the message was written,
+// but flushing it failed.
+type FlushError struct {
+	// The error returned by Flush.
+	Err error
+}
+
+func (f *FlushError) Error() string {
+	return fmt.Sprintf("flush error: %v", f.Err)
+}
+
+func (f *FlushError) Unwrap() error {
+	return f.Err
 }
 
 // MarshalText writes the standard textual representation of the message's event. Marshalling and unmarshalling will
 // result in a message with an event that has the same fields; topic will be lost.
 //
-// If you want to preserve everything, create your own custom marshalling logic.
-// For an example using encoding/json, see the top-level MessageCustomJSONMarshal example.
+// If you want a representation which is easier to store or log, use MarshalJSON.
 //
 // Use the WriteTo method if you don't need the byte representation.
 //
-// The representation is written to a bytes.Buffer, which means the error is always nil.
-// If the buffer grows to a size bigger than the maximum allowed, MarshalText will panic.
-// See the bytes.Buffer documentation for more info.
+// The error is always nil.
 func (e *Message) MarshalText() ([]byte, error) {
-	b := bytes.Buffer{}
-	_, err := e.WriteTo(&b)
-	return b.Bytes(), err
+	return e.appendTo(nil), nil
 }
 
-// String writes the message's event standard textual representation to a strings.Builder and returns the resulted string.
-// It may panic if the representation is too long to be buffered.
+// String returns the message's event standard textual representation.
 //
 // Use the WriteTo method if you don't actually need the string representation.
 func (e *Message) String() string {
-	s := strings.Builder{}
-	_, _ = e.WriteTo(&s)
-	return s.String()
+	return string(e.appendTo(nil))
 }
 
 // UnmarshalError is the error returned by the Message's UnmarshalText method.
@@ -266,6 +602,9 @@ var ErrUnexpectedEOF = parser.ErrUnexpectedEOF
 
 func (e *Message) reset() {
 	e.chunks = nil
+	e.fields = nil
+	e.dataReader = nil
+	e.variants = nil
 	e.Type = EventType{}
 	e.ID = EventID{}
 	e.Retry = 0
@@ -274,47 +613,168 @@ func (e *Message) reset() {
 // UnmarshalText extracts the first event found in the given byte slice into the
 // receiver. The input is expected to be a wire format event, as defined by the spec.
 // Therefore, previous fields present on the Message will be overwritten
-// (i.e. event, ID, comments, data, retry).
+// (i.e. event, ID, comments, data, retry, non-standard fields).
 //
-// Unmarshaling ignores fields with invalid names. If no valid fields are found,
+// Fields with unknown names are kept as non-standard fields – see SetField. If such a field
+// appears multiple times, only its last value is kept. If no valid fields are found,
 // an error is returned. For a field to be valid it must end in a newline - if the last
 // field of the event doesn't end in one, an error is returned.
 //
 // All returned errors are of type UnmarshalError.
 func (e *Message) UnmarshalText(p []byte) error {
+	return e.UnmarshalTextWith(p, MessageUnmarshalOptions{})
+}
+
+// UnmarshalTextPartial is like UnmarshalText, but if the input ends in the middle of the event,
+// for example because it comes from a stream which was closed abruptly, the fields parsed until then
+// are kept in the message. In this case the returned error still wraps ErrUnexpectedEOF, so the caller
+// knows the message is incomplete:
+//
+//	err := m.UnmarshalTextPartial(p)
+//	if errors.Is(err, sse.ErrUnexpectedEOF) {
+//		// m has only the fields which were complete.
+//	}
+//
+// The last field, which doesn't end in a newline, is not kept, as its value may be truncated.
+// If there are no complete fields, the message is reset, just like with UnmarshalText.
+func (e *Message) UnmarshalTextPartial(p []byte) error {
+	return e.UnmarshalTextWith(p, MessageUnmarshalOptions{Partial: true})
+}
+
+// MessageUnmarshalOptions changes how Message.UnmarshalTextWith parses messages.
+type MessageUnmarshalOptions struct {
+	// If Partial is set, the complete fields of an incomplete event are kept.
+	// See UnmarshalTextPartial.
+	Partial bool
+	// If LenientRetry is set, retry values which don't follow the specification, but are common
+	// with non-conformant servers, are accepted: spaces around the value, a "ms" suffix and fractional
+	// values, which are rounded to the closest millisecond – for example, " 1500.6 ms" is parsed as
+	// 1501 milliseconds. Other invalid values, such as negative ones or ones with other units,
+	// are still rejected.
+	LenientRetry bool
+	// MaxDataLines is the maximum number of data fields an event can have. Events with more
+	// fail to unmarshal with an error wrapping ErrTooManyDataLines, so inputs with a huge number
+	// of tiny data fields can't make the message allocate a chunk for each of them. Unlike a limit
+	// on the event's size, it bounds the per-field overhead. If <=0, there is no limit.
+	MaxDataLines int
+	// MaxEventSize is the maximum total size, in bytes, of the fields of an event: the names and values
+	// of all its fields, including comments and unknown fields, without the colons and newlines. Events
+	// which are larger fail to unmarshal with an error wrapping ErrEventTooLarge as soon as the limit is
+	// exceeded, before the rest of the event is parsed, so a huge event doesn't make the message allocate
+	// memory for all its fields. If <=0, there is no limit.
+	MaxEventSize int
+}
+
+// ErrEventTooLarge is returned when unmarshaling an event whose fields are larger than allowed.
+// See MessageUnmarshalOptions.MaxEventSize.
+var ErrEventTooLarge = errors.New("go-sse: event is too large")
+
+// ErrTooManyDataLines is returned when unmarshaling an event which has more data fields
+// than allowed. See MessageUnmarshalOptions.MaxDataLines and Client.MaxDataLines.
+var ErrTooManyDataLines = errors.New("go-sse: event has too many data lines")
+
+// UnmarshalTextWith parses the message just like UnmarshalText, using the given options.
+func (e *Message) UnmarshalTextWith(p []byte, opts MessageUnmarshalOptions) error {
+	return e.unmarshalText(p, opts)
+}
+
+// parseRetry parses the value of a retry field, in milliseconds. If lenient is set, spaces around the value,
+// a "ms" suffix and a fractional part are accepted, and the value is rounded to the closest millisecond.
+func parseRetry(value string, lenient bool) (int64, error) {
+	var fraction string
+	if lenient {
+		value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "ms"))
+		if i := strings.IndexByte(value, '.'); i > 0 && i < len(value)-1 {
+			value, fraction = value[:i], value[i+1:]
+		}
+	}
+
+	if i := strings.IndexFunc(value+fraction, func(r rune) bool {
+		return r < '0' || r > '9'
+	}); i != -1 {
+		r, _ := utf8.DecodeRuneInString((value + fraction)[i:])
+		return 0, fmt.Errorf("contains character %q, which is not an ASCII digit", r)
+	}
+
+	milli, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retry value: %w", err)
+	}
+	if fraction != "" && fraction[0] >= '5' {
+		milli++
+	}
+
+	return milli, nil
+}
+
+// ScanEvents is a split function for a bufio.Scanner which splits an event stream into events.
+// Events end with a blank line, where newlines are "\n", "\r" or "\r\n", so both "\n\n" and "\r\n\r\n"
+// separate events. Each token is a single event, including its final newlines, and can be passed
+// to Message.UnmarshalText:
+//
+//	s := bufio.NewScanner(r)
+//	s.Split(sse.ScanEvents)
+//	for s.Scan() {
+//		var m sse.Message
+//		if err := m.UnmarshalText(s.Bytes()); err != nil {
+//			// handle error
+//		}
+//	}
+//
+// Blank lines between events are skipped. If the stream doesn't end with a blank line, the last token is
+// the incomplete event, for which UnmarshalText returns ErrUnexpectedEOF – use UnmarshalTextPartial to
+// keep its complete fields. For reading events from HTTP responses, use a Connection instead.
+//
+// The scanner buffers each event whole, so when reading from untrusted sources set its maximum
+// buffer size using bufio.Scanner.Buffer: larger events make Scan fail with bufio.ErrTooLong instead
+// of growing the buffer. Use MessageUnmarshalOptions.MaxEventSize to also limit the size of the
+// fields of the events which are unmarshaled.
+func ScanEvents(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	return parser.SplitFunc(data, atEOF)
+}
+
+func (e *Message) unmarshalText(p []byte, opts MessageUnmarshalOptions) error {
 	e.reset()
 
 	s := parser.NewFieldParser(string(p))
 	s.KeepComments(true)
+	s.KeepUnknownFields(true)
 	s.RemoveBOM(true)
 
+	dataLines, size := 0, 0
+
 loop:
 	for f := (parser.Field{}); s.Next(&f); {
-		switch f.Name {
-		case parser.FieldNameRetry:
-			if i := strings.IndexFunc(f.Value, func(r rune) bool {
-				return r < '0' || r > '9'
-			}); i != -1 {
-				r, _ := utf8.DecodeRuneInString(f.Value[i:])
-
-				return &UnmarshalError{
-					FieldName:  string(f.Name),
-					FieldValue: f.Value,
-					Reason:     fmt.Errorf("contains character %q, which is not an ASCII digit", r),
-				}
+		if f.Name != "" && opts.MaxEventSize > 0 {
+			name := len(f.Name)
+			if f.Name == parser.FieldNameComment {
+				name = 0
 			}
+			if size += name + len(f.Value); size > opts.MaxEventSize {
+				e.reset()
+				return &UnmarshalError{Reason: fmt.Errorf("%w: the limit is %d bytes", ErrEventTooLarge, opts.MaxEventSize)}
+			}
+		}
 
-			milli, err := strconv.ParseInt(f.Value, 10, 64)
+		switch f.Name {
+		case parser.FieldNameRetry:
+			milli, err := parseRetry(f.Value, opts.LenientRetry)
 			if err != nil {
 				return &UnmarshalError{
 					FieldName:  string(f.Name),
 					FieldValue: f.Value,
-					Reason:     fmt.Errorf("invalid retry value: %w", err),
+					Reason:     err,
 				}
 			}
 
 			e.Retry = time.Duration(milli) * time.Millisecond
 		case parser.FieldNameData, parser.FieldNameComment:
+			if f.Name == parser.FieldNameData {
+				if dataLines++; opts.MaxDataLines > 0 && dataLines > opts.MaxDataLines {
+					e.reset()
+					return &UnmarshalError{Reason: fmt.Errorf("%w: the limit is %d", ErrTooManyDataLines, opts.MaxDataLines)}
+				}
+			}
 			e.chunks = append(e.chunks, chunk{content: f.Value, isComment: f.Name == parser.FieldNameComment})
 		case parser.FieldNameEvent:
 			e.Type.value = f.Value
@@ -326,30 +786,291 @@ loop:
 
 			e.ID.value = f.Value
 			e.ID.set = true
-		default: // event end
+		case "": // event end
 			break loop
+		default:
+			e.setField(string(f.Name), f.Value)
 		}
 	}
 
-	if len(e.chunks) == 0 && !e.Type.IsSet() && e.Retry == 0 && !e.ID.IsSet() || s.Err() != nil {
-		e.reset()
+	empty := len(e.chunks) == 0 && len(e.fields) == 0 && !e.Type.IsSet() && e.Retry == 0 && !e.ID.IsSet()
+	if empty || s.Err() != nil {
+		if empty || !opts.Partial {
+			e.reset()
+		}
 		return &UnmarshalError{Reason: ErrUnexpectedEOF}
 	}
 	return nil
 }
 
+type jsonChunk struct {
+	Data    *string `json:"data,omitempty"`
+	Comment *string `json:"comment,omitempty"`
+}
+
+type jsonField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type jsonMessage struct {
+	ID       *EventID      `json:"id,omitempty"`
+	Type     *EventType    `json:"event,omitempty"`
+	Fields   []jsonField   `json:"fields,omitempty"`
+	Chunks   []jsonChunk   `json:"chunks,omitempty"`
+	Variants []jsonVariant `json:"variants,omitempty"`
+	Retry    int64         `json:"retry,omitempty"`
+}
+
+type jsonVariant struct {
+	ContentType string   `json:"contentType"`
+	Data        []string `json:"data"`
+}
+
+// MarshalJSON returns a JSON representation of the message, useful for logging or storage.
+// It has the following shape:
+//
+//	{
+//		"id": "the event ID",
+//		"event": "the event type",
+//		"fields": [{"name": "a non-standard field", "value": "its value"}],
+//		"chunks": [{"data": "a data field"}, {"comment": "a comment field"}],
+//		"variants": [{"contentType": "application/msgpack", "data": ["a data field"]}],
+//		"retry": 5000
+//	}
+//
+// The keys are always written in this order. Unset fields are omitted and the retry
+// value is expressed in milliseconds. The chunks
+// are in the order in which the data and comment fields would be written by WriteTo.
+func (e *Message) MarshalJSON() ([]byte, error) {
+	m := jsonMessage{Chunks: make([]jsonChunk, 0, len(e.chunks))}
+	if e.ID.IsSet() {
+		m.ID = &e.ID
+	}
+	if e.Type.IsSet() {
+		m.Type = &e.Type
+	}
+	if millis := e.Retry.Milliseconds(); millis > 0 {
+		m.Retry = millis
+	}
+	for _, f := range e.fields {
+		m.Fields = append(m.Fields, jsonField{Name: f.name, Value: f.value})
+	}
+	for i := range e.chunks {
+		c := &e.chunks[i]
+		if c.isComment {
+			m.Chunks = append(m.Chunks, jsonChunk{Comment: &c.content})
+		} else {
+			m.Chunks = append(m.Chunks, jsonChunk{Data: &c.content})
+		}
+	}
+	for _, v := range e.variants {
+		jv := jsonVariant{ContentType: v.contentType, Data: make([]string, 0, len(v.chunks))}
+		for _, c := range v.chunks {
+			jv.Data = append(jv.Data, c.content)
+		}
+		m.Variants = append(m.Variants, jv)
+	}
+
+	return json.Marshal(m)
+}
+
+// WriteNDJSON writes the JSON representation of the message, as returned by MarshalJSON,
+// followed by a newline. This is the newline-delimited JSON (NDJSON) format, which can be
+// used to stream messages to clients that can't receive server-sent events.
+// Just like WriteTo, it writes nothing if the message has no fields.
+func (e *Message) WriteNDJSON(w io.Writer) (int64, error) {
+	p, err := e.MarshalJSON()
+	if err != nil {
+		return 0, err
+	}
+	if string(p) == "{}" {
+		return 0, nil
+	}
+
+	n, err := w.Write(append(p, '\n'))
+	return int64(n), err
+}
+
+// UnmarshalJSON reconstructs a message from the representation returned by MarshalJSON.
+// Previous fields present on the Message are overwritten. Each chunk must have exactly
+// one of the data or comment keys, and its value must not span multiple lines, just like
+// the data of the variants. The non-standard fields must be valid, as described by SetField.
+// Variants must have distinct, non-empty content types.
+func (e *Message) UnmarshalJSON(data []byte) error {
+	e.reset()
+
+	var m jsonMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+
+	var fields Message
+	for _, f := range m.Fields {
+		if err := fields.SetField(f.Name, f.Value); err != nil {
+			return err
+		}
+	}
+
+	chunks := make([]chunk, 0, len(m.Chunks))
+	for i, c := range m.Chunks {
+		var ch chunk
+		switch {
+		case c.Data != nil && c.Comment == nil:
+			ch.content = *c.Data
+		case c.Comment != nil && c.Data == nil:
+			ch.content, ch.isComment = *c.Comment, true
+		default:
+			return fmt.Errorf("go-sse: chunk %d must be either a data or a comment field", i)
+		}
+		if !isSingleLine(ch.content) {
+			return fmt.Errorf("go-sse: chunk %d is multiline", i)
+		}
+		chunks = append(chunks, ch)
+	}
+
+	var variants []messageVariant
+	for i, v := range m.Variants {
+		if v.ContentType == "" {
+			return fmt.Errorf("go-sse: variant %d has no content type", i)
+		}
+		for _, prev := range variants {
+			if prev.contentType == v.ContentType {
+				return fmt.Errorf("go-sse: variant %d has duplicate content type %q", i, v.ContentType)
+			}
+		}
+
+		mv := messageVariant{contentType: v.ContentType, chunks: make([]chunk, 0, len(v.Data))}
+		for _, d := range v.Data {
+			if !isSingleLine(d) {
+				return fmt.Errorf("go-sse: data of variant %d is multiline", i)
+			}
+			mv.chunks = append(mv.chunks, chunk{content: d})
+		}
+		variants = append(variants, mv)
+	}
+
+	if len(chunks) > 0 {
+		e.chunks = chunks
+	}
+	e.variants = variants
+	e.fields = fields.fields
+	if m.ID != nil {
+		e.ID = *m.ID
+	}
+	if m.Type != nil {
+		e.Type = *m.Type
+	}
+	if m.Retry > 0 {
+		e.Retry = time.Duration(m.Retry) * time.Millisecond
+	}
+
+	return nil
+}
+
+// CloseEventType is the type of the message returned by CloseMessage.
+// It is prefixed so it doesn't collide with application event types, such as "close".
+const CloseEventType = "go-sse.close"
+
+// CloseMessage returns a message which signals clients that the server is done sending events
+// and that they should not reconnect. It has the following wire representation:
+//
+//	event: go-sse.close
+//	data:
+//
+// The empty data field ensures that browsers dispatch the event: listen for it using
+// EventSource.addEventListener("go-sse.close", ...) and call EventSource.close in the listener.
+// Connections created using this package's Client stop after receiving it.
+func CloseMessage() *Message {
+	return &Message{Type: Type(CloseEventType), chunks: []chunk{{}}}
+}
+
+// ErrorEventType is the type of the message returned by ErrorMessage.
+// It is deliberately not "error", which is the conventional type, but prefixed just like
+// CloseEventType: EventSource dispatches events of type "error" itself when the connection fails,
+// so listeners couldn't tell the two apart, and applications may already use the type for their own events.
+const ErrorEventType = "go-sse.error"
+
+// ErrorMessage returns a message which signals clients that the server failed to produce the
+// rest of the stream, for example because a query whose results are streamed failed midway.
+// It has the following wire representation:
+//
+//	event: go-sse.error
+//	data: {"message":"the error's message"}
+//
+// Listen for it using EventSource.addEventListener("go-sse.error", ...), parse the data as JSON
+// and call EventSource.close in the listener – the server should end the stream after sending it.
+// Connections created using this package's Client stop after receiving it and return an error
+// which wraps a *ServerError with the error's message. The error's message is sent to the client,
+// so it must not contain sensitive information. If the error is nil, the message is still returned,
+// with an empty error message, so the clients still stop.
+func ErrorMessage(err error) *Message {
+	var e jsonError
+	if err != nil {
+		e.Message = err.Error()
+	}
+	// Marshaling a struct with a string field doesn't fail and the result is a single line.
+	data, _ := json.Marshal(e)
+	return &Message{Type: Type(ErrorEventType), chunks: []chunk{{content: string(data)}}}
+}
+
+type jsonError struct {
+	Message string `json:"message"`
+}
+
 // Clone returns a copy of the message.
 func (e *Message) Clone() *Message {
 	return &Message{
 		// The first AppendData will trigger a reallocation.
 		// Already appended chunks cannot be modified/removed, so this is safe.
 		chunks: e.chunks[:len(e.chunks):len(e.chunks)],
-		Retry:  e.Retry,
-		Type:   e.Type,
-		ID:     e.ID,
+		// Field values can be modified in place, so they must be copied.
+		fields:     append([]extensionField(nil), e.fields...),
+		dataReader: e.dataReader,
+		variants:   cloneVariants(e.variants),
+		Retry:      e.Retry,
+		Type:       e.Type,
+		ID:         e.ID,
 	}
 }
 
-func writeString(w io.Writer, s string) (int, error) {
-	return w.Write(unsafe.Slice((*byte)(unsafe.Pointer((*reflect.StringHeader)(unsafe.Pointer(&s)).Data)), len(s)))
+// Equal reports whether the messages have the same ID, type, retry and extension fields,
+// the same data and comments in the same order, and the same variants. Two nil messages are equal.
+// It is useful for comparing messages in tests.
+func (e *Message) Equal(other *Message) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+
+	if e.ID != other.ID || e.Type != other.Type || e.Retry != other.Retry ||
+		len(e.chunks) != len(other.chunks) || len(e.fields) != len(other.fields) ||
+		len(e.variants) != len(other.variants) {
+		return false
+	}
+
+	for i := range e.chunks {
+		if e.chunks[i] != other.chunks[i] {
+			return false
+		}
+	}
+
+	for i := range e.fields {
+		if e.fields[i] != other.fields[i] {
+			return false
+		}
+	}
+
+	for i := range e.variants {
+		v, ov := &e.variants[i], &other.variants[i]
+		if v.contentType != ov.contentType || len(v.chunks) != len(ov.chunks) {
+			return false
+		}
+		for j := range v.chunks {
+			if v.chunks[j] != ov.chunks[j] {
+				return false
+			}
+		}
+	}
+
+	return true
 }
@@ -0,0 +1,62 @@
+package sse
+
+import "time"
+
+// MessageBuilder constructs a Message using a fluent API:
+//
+//	m := sse.NewMessage().Type("update").ID("1").Data("hello").Build()
+//
+// The data and comment fields are written in the order they were added.
+// Create a MessageBuilder using NewMessage.
+type MessageBuilder struct {
+	m Message
+}
+
+// NewMessage creates a builder for a message without any fields.
+func NewMessage() *MessageBuilder {
+	return &MessageBuilder{}
+}
+
+// ID sets the message's ID. It panics if the ID is invalid – see the ID function.
+func (b *MessageBuilder) ID(id string) *MessageBuilder {
+	b.m.ID = ID(id)
+	return b
+}
+
+// Type sets the message's type. It panics if the type is invalid – see the Type function.
+func (b *MessageBuilder) Type(typ string) *MessageBuilder {
+	b.m.Type = Type(typ)
+	return b
+}
+
+// Retry sets the message's retry value.
+func (b *MessageBuilder) Retry(retry time.Duration) *MessageBuilder {
+	b.m.Retry = retry
+	return b
+}
+
+// Field sets a non-standard field on the message. It panics if the field is invalid – see Message.SetField.
+func (b *MessageBuilder) Field(name, value string) *MessageBuilder {
+	if err := b.m.SetField(name, value); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Data appends data fields to the message. See Message.AppendData.
+func (b *MessageBuilder) Data(data ...string) *MessageBuilder {
+	b.m.AppendData(data...)
+	return b
+}
+
+// Comment appends comment fields to the message. See Message.AppendComment.
+func (b *MessageBuilder) Comment(comments ...string) *MessageBuilder {
+	b.m.AppendComment(comments...)
+	return b
+}
+
+// Build returns the assembled message. The builder can be used further
+// without changing the already built messages.
+func (b *MessageBuilder) Build() *Message {
+	return b.m.Clone()
+}
@@ -0,0 +1,138 @@
+package sse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// SetDataReader sets a source for the message's data, which is read and written as data fields
+// only when the message is written, line by line, after the data appended with AppendData.
+// This way very large payloads are streamed to the client without ever being held in memory
+// entirely. Newlines are handled the same way AppendData handles them.
+//
+// Given that the data is not known in advance, the following apply to such messages:
+//
+//   - the reader is read only once, so the message can be written only once – send it directly
+//     using Session.Send or WriteTo, instead of publishing it to a provider such as Joe, which
+//     writes it to multiple clients and maybe puts it into a replay provider;
+//   - the streamed data is not included by MarshalText, String, MarshalJSON, WriteNDJSON or Equal,
+//     the size of the event is not known before it is written and it is not written with a single
+//     Write call, but in chunks, as the data is read;
+//   - if reading fails, the event is left incomplete and WriteTo returns the error – end
+//     the client's connection, as the next event would be mixed with the incomplete one.
+//
+// A nil reader removes the source.
+func (e *Message) SetDataReader(r io.Reader) {
+	e.dataReader = r
+}
+
+// streamBufferSize is the size of the buffer the streamed messages are written with.
+const streamBufferSize = 4 << 10
+
+// writeStreamed writes the message just like writeTo, reading the data from the message's data reader.
+func (e *Message) writeStreamed(w io.Writer, fields []extensionField, dataName []byte) (int64, error) {
+	cw := &byteCounter{w: w}
+	bw := bufio.NewWriterSize(cw, streamBufferSize)
+
+	var b []byte
+	for i := range fields {
+		b = fields[i].appendTo(b)
+	}
+	b = e.appendFields(b, dataName)
+	// Errors are kept by the bufio.Writer and returned by Flush.
+	_, _ = bw.Write(b)
+
+	dw := &dataWriter{w: bw, name: dataName}
+	if _, err := io.Copy(dw, e.dataReader); err != nil && dw.err == nil {
+		_ = bw.Flush()
+		return cw.n, fmt.Errorf("go-sse: failed to read data: %w", err)
+	}
+
+	if dw.inLine {
+		_ = bw.WriteByte('\n')
+	}
+	if len(b) > 0 || dw.lines > 0 {
+		_ = bw.WriteByte('\n')
+	}
+
+	err := bw.Flush()
+
+	return cw.n, err
+}
+
+// dataWriter writes each line of the data written to it as a data field.
+type dataWriter struct {
+	w *bufio.Writer
+	// name is the data field name, followed by a colon.
+	name []byte
+	err  error
+	// lines counts the data fields started.
+	lines int
+	// inLine is true if a data field was started, but no newline was written yet.
+	inLine bool
+	// skipLF is true if the last byte written was a CR, so a following LF is part of the same newline.
+	skipLF bool
+}
+
+func (d *dataWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		if d.skipLF {
+			d.skipLF = false
+			if p[0] == '\n' {
+				p = p[1:]
+				continue
+			}
+		}
+
+		if !d.inLine {
+			_, _ = d.w.Write(d.name)
+			d.inLine = true
+			d.lines++
+		}
+
+		i := indexNewline(p)
+		if i == -1 {
+			_, _ = d.w.Write(p)
+			break
+		}
+
+		_, _ = d.w.Write(p[:i])
+		_ = d.w.WriteByte('\n')
+		d.inLine = false
+		d.skipLF = p[i] == '\r'
+		p = p[i+1:]
+	}
+
+	// The bufio.Writer keeps the first error and returns it from each later call.
+	if _, d.err = d.w.Write(nil); d.err != nil {
+		return 0, d.err
+	}
+
+	return n, nil
+}
+
+// indexNewline returns the index of the first CR or LF in p, or -1.
+func indexNewline(p []byte) int {
+	for i, c := range p {
+		if c == '\r' || c == '\n' {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// byteCounter counts the bytes written to w.
+type byteCounter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -1,16 +1,21 @@
 package sse
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"os"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/tmaxmax/go-sse/internal/parser"
@@ -68,6 +73,46 @@ func TestEvent_WriteTo(t *testing.T) {
 		tests.Equal(t, written, expectedWritten, "written byte count wrong")
 	})
 
+	t.Run("No data", func(t *testing.T) {
+		e := &Message{Type: Type("refresh"), ID: ID("5"), Retry: time.Second}
+		tests.Equal(t, e.String(), "id: 5\nevent: refresh\nretry: 1000\n\n", "message without data should be a valid event")
+
+		var u Message
+		tests.Equal(t, u.UnmarshalText([]byte(e.String())), nil, "unexpected unmarshal error")
+		tests.DeepEqual(t, u, *e, "message without data should round-trip")
+
+		e.AppendData("\n")
+		tests.Equal(t, e.String(), "id: 5\nevent: refresh\nretry: 1000\ndata: \n\n", "a newline should add an empty data field")
+
+		tests.Equal(t, u.UnmarshalText([]byte(e.String())), nil, "unexpected unmarshal error")
+		tests.DeepEqual(t, u, *e, "message with empty data should round-trip")
+	})
+
+	t.Run("Comments", func(t *testing.T) {
+		e := &Message{}
+		e.AppendComment("first", "second\nthird")
+		e.AppendData("data")
+		e.AppendComment("", "fourth\r\n\nfifth")
+		e.AppendData("more\ndata")
+
+		output := ": first\n: second\n: third\ndata: data\n: fourth\n: \n: fifth\ndata: more\ndata: data\n\n"
+
+		w := &strings.Builder{}
+		_, _ = e.WriteTo(w)
+
+		tests.Equal(t, w.String(), output, "comments and data written incorrectly")
+	})
+
+	t.Run("AppendComments", func(t *testing.T) {
+		e := &Message{}
+		e.AppendData("first")
+		e.AppendComments("trace: abc", "took: 3ms")
+		e.AppendData("second")
+		e.AppendComments("multi\nline")
+
+		tests.Equal(t, e.String(), "data: first\n: trace: abc\n: took: 3ms\ndata: second\n: multi\n: line\n\n", "comment lines written incorrectly")
+	})
+
 	type retryTest struct {
 		expected string
 		value    time.Duration
@@ -87,6 +132,158 @@ func TestEvent_WriteTo(t *testing.T) {
 	}
 }
 
+type failingFlusher struct {
+	bytes.Buffer
+	err error
+}
+
+func (f *failingFlusher) Flush() error { return f.err }
+
+func TestMessage_WriteAndFlush(t *testing.T) {
+	t.Parallel()
+
+	e := &Message{ID: ID("1")}
+	e.AppendData("hello")
+
+	var sb strings.Builder
+	w := bufio.NewWriter(&sb)
+	n, err := e.WriteAndFlush(w)
+	tests.Equal(t, err, nil, "unexpected error")
+	tests.Equal(t, n, int64(sb.Len()), "invalid written bytes count")
+	tests.Equal(t, sb.String(), "id: 1\ndata: hello\n\n", "message should be flushed")
+
+	flushErr := errors.New("connection reset")
+	f := &failingFlusher{err: flushErr}
+	n, err = e.WriteAndFlush(f)
+	tests.Equal(t, n, int64(f.Len()), "invalid written bytes count")
+
+	var ferr *FlushError
+	tests.Expect(t, errors.As(err, &ferr), "flush error should be distinct")
+	tests.ErrorIs(t, err, flushErr, "flush error should wrap the original error")
+}
+
+func TestMessage_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests.ErrorIs(t, (&Message{}).Validate(), ErrEmptyMessage, "empty message should be invalid")
+	tests.ErrorIs(t, (&Message{Retry: time.Microsecond}).Validate(), ErrEmptyMessage, "retry which is not written should be ignored")
+
+	comment := &Message{}
+	comment.AppendComment("keep-alive")
+	tests.Equal(t, comment.Validate(), nil, "comment-only message should be valid")
+
+	valid := &Message{ID: ID("1"), Type: Type("update")}
+	valid.AppendData("hello", "world")
+	tests.Equal(t, valid.Validate(), nil, "message should be valid")
+
+	for name, m := range map[string]*Message{
+		"NUL in ID":       {ID: ID("a\x00b")},
+		"invalid ID":      {ID: ID("\xff")},
+		"invalid type":    {Type: Type("\xfe")},
+		"invalid data":    func() *Message { m := &Message{}; m.AppendData("ok", "\xffbad"); return m }(),
+		"invalid comment": func() *Message { m := &Message{}; m.AppendComment("\xff"); return m }(),
+		"invalid field":   func() *Message { m := &Message{}; _ = m.SetField("x", "\xff"); return m }(),
+	} {
+		tests.Expect(t, m.Validate() != nil, "%s: message should be invalid", name)
+	}
+}
+
+func TestMessage_AppendScalars(t *testing.T) {
+	t.Parallel()
+
+	m := &Message{}
+	m.AppendInt(-42)
+	m.AppendFloat(0.1)
+	m.AppendFloat(1e21)
+	m.AppendFloat(math.Inf(-1))
+	m.AppendBool(true)
+	m.AppendData("text")
+
+	tests.Equal(t, m.String(), "data: -42\ndata: 0.1\ndata: 1e+21\ndata: -Inf\ndata: true\ndata: text\n\n", "invalid data fields")
+}
+
+func TestMessage_WriteToWith(t *testing.T) {
+	t.Parallel()
+
+	m := &Message{ID: ID("1")}
+	m.AppendData("hello\nworld")
+	m.AppendComment("comment")
+
+	write := func(opts MessageWriteOptions) (string, error) {
+		var sb strings.Builder
+		n, err := m.WriteToWith(&sb, opts)
+		tests.Equal(t, n, int64(sb.Len()), "invalid written bytes count")
+		return sb.String(), err
+	}
+
+	out, err := write(MessageWriteOptions{DataFieldName: "message"})
+	tests.Equal(t, err, nil, "unexpected write error")
+	tests.Equal(t, out, "id: 1\nmessage: hello\nmessage: world\n: comment\n\n", "data fields should be remapped")
+
+	out, err = write(MessageWriteOptions{})
+	tests.Equal(t, err, nil, "unexpected write error")
+	tests.Equal(t, out, m.String(), "default options should write the standard fields")
+
+	m.SetDataReader(strings.NewReader("streamed"))
+	out, err = write(MessageWriteOptions{DataFieldName: "message"})
+	tests.Equal(t, err, nil, "unexpected write error")
+	tests.Equal(t, out, "id: 1\nmessage: hello\nmessage: world\n: comment\nmessage: streamed\n\n", "streamed data fields should be remapped")
+
+	for _, name := range []string{"a:b", "a\nb", "id", "event", "retry"} {
+		out, err = write(MessageWriteOptions{DataFieldName: name})
+		tests.Expect(t, err != nil, "data field name %q should be invalid", name)
+		tests.Equal(t, out, "", "nothing should be written for invalid options")
+	}
+
+	m.SetDataReader(nil)
+	out, err = write(MessageWriteOptions{LineEnding: "\r\n"})
+	tests.Equal(t, err, nil, "unexpected write error")
+	tests.Equal(t, out, "id: 1\r\ndata: hello\r\ndata: world\r\n: comment\r\n\r\n", "lines should end with CRLF")
+
+	m.SetDataReader(strings.NewReader("a\nb"))
+	out, err = write(MessageWriteOptions{DataFieldName: "message", LineEnding: "\r"})
+	tests.Equal(t, err, nil, "unexpected write error")
+	tests.Equal(t, out, "id: 1\rmessage: hello\rmessage: world\r: comment\rmessage: a\rmessage: b\r\r", "streamed lines should end with CR")
+
+	for _, ending := range []string{"\n\r", " ", "\r\r"} {
+		out, err = write(MessageWriteOptions{LineEnding: ending})
+		tests.Expect(t, err != nil, "line ending %q should be invalid", ending)
+		tests.Equal(t, out, "", "nothing should be written for invalid options")
+	}
+}
+
+func TestMessage_SetDataReader(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{"", "hello", "a\nb\r\nc\rd", "a\r\n\r\nb\n", "\n", strings.Repeat("long line ", 1000)} {
+		expected := &Message{ID: ID("1")}
+		expected.AppendData("first", data)
+
+		m := &Message{ID: ID("1")}
+		m.AppendData("first")
+		m.SetDataReader(iotest.OneByteReader(strings.NewReader(data)))
+
+		var sb strings.Builder
+		n, err := m.WriteTo(&sb)
+		tests.Equal(t, err, nil, "unexpected write error")
+		tests.Equal(t, n, int64(sb.Len()), "invalid written bytes count")
+		tests.Equal(t, sb.String(), expected.String(), "streamed data should be written as appended data")
+	}
+
+	m := &Message{}
+	m.SetDataReader(strings.NewReader(""))
+	var sb strings.Builder
+	_, err := m.WriteTo(&sb)
+	tests.Equal(t, err, nil, "unexpected write error")
+	tests.Equal(t, sb.String(), "", "empty streamed message should not be written")
+
+	readErr := errors.New("read failed")
+	m.SetDataReader(io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(readErr)))
+	_, err = m.WriteTo(&sb)
+	tests.ErrorIs(t, err, readErr, "read error should be returned")
+	tests.Equal(t, sb.String(), "data: partial", "data read before the error should be written")
+}
+
 func TestEvent_UnmarshalText(t *testing.T) {
 	t.Parallel()
 
@@ -137,6 +334,14 @@ func TestEvent_UnmarshalText(t *testing.T) {
 				ID:    ID("2000"),
 			},
 		},
+		{
+			name:  "Non-standard fields",
+			input: "trace-id: abc\ndata: hello\nflag\ntrace-id: def\n\n",
+			expected: Message{
+				chunks: []chunk{{content: "hello"}},
+				fields: []extensionField{{name: "trace-id", value: "def"}, {name: "flag"}},
+			},
+		},
 	}
 
 	for _, test := range tt {
@@ -151,6 +356,282 @@ func TestEvent_UnmarshalText(t *testing.T) {
 	}
 }
 
+func TestMessage_UnmarshalTextWith_lenientRetry(t *testing.T) {
+	t.Parallel()
+
+	lenient := MessageUnmarshalOptions{LenientRetry: true}
+
+	for input, expected := range map[string]time.Duration{
+		"500":        500 * time.Millisecond,
+		"500 ":       500 * time.Millisecond,
+		"500ms":      500 * time.Millisecond,
+		"500 ms ":    500 * time.Millisecond,
+		"500.4":      500 * time.Millisecond,
+		"500.5":      501 * time.Millisecond,
+		" 1500.6 ms": 1501 * time.Millisecond,
+	} {
+		var m Message
+		tests.Equal(t, m.UnmarshalTextWith([]byte("retry:"+input+"\n\n"), lenient), nil, "lenient retry %q should be accepted", input)
+		tests.Equal(t, m.Retry, expected, "invalid retry for %q", input)
+
+		if input != "500" {
+			var uerr *UnmarshalError
+			tests.Expect(t, errors.As(m.UnmarshalText([]byte("retry:"+input+"\n\n")), &uerr), "retry %q should be rejected by default", input)
+		}
+	}
+
+	for _, input := range []string{"-500", "1.5s", "500.", ".5", "5.0.1", "ms", "5e2"} {
+		var m Message
+		var uerr *UnmarshalError
+		tests.Expect(t, errors.As(m.UnmarshalTextWith([]byte("retry: "+input+"\n\n"), lenient), &uerr), "retry %q should be rejected", input)
+		tests.Equal(t, uerr.FieldName, "retry", "invalid field name")
+	}
+
+	var m Message
+	tests.ErrorIs(t, m.UnmarshalTextWith([]byte("data: a\nretry: 1ms\ndata: b"), MessageUnmarshalOptions{Partial: true, LenientRetry: true}), ErrUnexpectedEOF, "incomplete event should be reported")
+	tests.Equal(t, m.String(), "retry: 1\ndata: a\n\n", "complete fields should be kept")
+}
+
+func TestMessage_UnmarshalText_BOM(t *testing.T) {
+	t.Parallel()
+
+	var m Message
+	tests.Equal(t, m.UnmarshalText([]byte("\xEF\xBB\xBFdata: hello\n\n")), nil, "unexpected error")
+	tests.Equal(t, m.String(), "data: hello\n\n", "BOM should be removed")
+
+	tests.Equal(t, m.UnmarshalText([]byte("\xEF\xBB\xBF\xEF\xBB\xBFdata: hello\n\n")), nil, "unexpected error")
+	_, ok := m.Field("\xEF\xBB\xBFdata")
+	tests.Expect(t, ok, "only the first BOM should be removed")
+
+	tests.Equal(t, m.UnmarshalText([]byte("data: \xEF\xBB\xBFhello\n\n")), nil, "unexpected error")
+	tests.Equal(t, m.String(), "data: \xEF\xBB\xBFhello\n\n", "BOM should be removed only from the start")
+}
+
+func TestMessage_UnmarshalTextPartial(t *testing.T) {
+	t.Parallel()
+
+	var e Message
+	err := e.UnmarshalTextPartial([]byte("id: 1\ndata: first\ndata:second\ndata:thi"))
+	tests.ErrorIs(t, err, ErrUnexpectedEOF, "incomplete input should be reported")
+	tests.Equal(t, e.String(), "id: 1\ndata: first\ndata: second\n\n", "complete fields should be kept")
+
+	err = e.UnmarshalTextPartial([]byte("data: third"))
+	tests.ErrorIs(t, err, ErrUnexpectedEOF, "incomplete input should be reported")
+	tests.DeepEqual(t, e, Message{}, "message without complete fields should be reset")
+
+	tests.Equal(t, e.UnmarshalTextPartial([]byte("data: whole\n\n")), nil, "complete input should be unmarshaled")
+	tests.Equal(t, e.String(), "data: whole\n\n", "invalid complete message")
+}
+
+func TestScanEvents(t *testing.T) {
+	t.Parallel()
+
+	s := bufio.NewScanner(strings.NewReader("id: 1\ndata: first\n\n\ndata: second\r\n\r\n: comment\ndata: third"))
+	s.Split(ScanEvents)
+
+	var messages []string
+	var errs []error
+	for s.Scan() {
+		var m Message
+		errs = append(errs, m.UnmarshalText(s.Bytes()))
+		messages = append(messages, m.String())
+	}
+
+	tests.Equal(t, s.Err(), nil, "unexpected scan error")
+	tests.DeepEqual(t, messages, []string{"id: 1\ndata: first\n\n", "data: second\n\n", ""}, "invalid messages")
+	tests.Equal(t, errs[0], nil, "unexpected unmarshal error")
+	tests.Equal(t, errs[1], nil, "unexpected unmarshal error")
+	tests.ErrorIs(t, errs[2], ErrUnexpectedEOF, "incomplete event should fail")
+}
+
+func TestMessage_JSON(t *testing.T) {
+	t.Parallel()
+
+	e := &Message{Type: Type("x"), ID: ID(""), Retry: time.Second}
+	e.AppendData("first")
+	e.AppendComment("comment")
+	e.AppendData("second\nthird")
+
+	data, err := json.Marshal(e)
+	tests.Equal(t, err, nil, "unexpected marshal error")
+	tests.Equal(t, string(data), `{"id":"","event":"x","chunks":[{"data":"first"},{"comment":"comment"},{"data":"second"},{"data":"third"}],"retry":1000}`, "invalid JSON representation")
+
+	var u Message
+	tests.Equal(t, json.Unmarshal(data, &u), nil, "unexpected unmarshal error")
+	tests.DeepEqual(t, u, *e, "round-trip should preserve message")
+
+	data, err = json.Marshal(&Message{})
+	tests.Equal(t, err, nil, "unexpected marshal error")
+	tests.Equal(t, string(data), `{}`, "empty message should have no fields")
+
+	tests.Equal(t, json.Unmarshal(data, &u), nil, "unexpected unmarshal error")
+	tests.DeepEqual(t, u, Message{}, "empty message should be unmarshaled")
+
+	tests.Expect(t, json.Unmarshal([]byte(`{"chunks":[{"data":"a","comment":"b"}]}`), &u) != nil, "ambiguous chunk should fail")
+	tests.Expect(t, json.Unmarshal([]byte(`{"chunks":[{"data":"a\nb"}]}`), &u) != nil, "multiline chunk should fail")
+	tests.Expect(t, json.Unmarshal([]byte(`{"id":"a\nb"}`), &u) != nil, "invalid ID should fail")
+}
+
+func TestMessage_WriteNDJSON(t *testing.T) {
+	t.Parallel()
+
+	var sb strings.Builder
+
+	n, err := (&Message{}).WriteNDJSON(&sb)
+	tests.Equal(t, err, nil, "unexpected error")
+	tests.Equal(t, n, int64(0), "empty message should not be written")
+
+	n, err = NewMessage().ID("1").Data("a\nb").Build().WriteNDJSON(&sb)
+	tests.Equal(t, err, nil, "unexpected error")
+	tests.Equal(t, sb.String(), `{"id":"1","chunks":[{"data":"a"},{"data":"b"}]}`+"\n", "invalid NDJSON representation")
+	tests.Equal(t, n, int64(sb.Len()), "invalid written bytes count")
+}
+
+func TestMessage_SetField(t *testing.T) {
+	t.Parallel()
+
+	e := &Message{ID: ID("1")}
+	tests.Equal(t, e.SetField("trace-id", "abc"), nil, "unexpected error")
+	tests.Equal(t, e.SetField("span", "1"), nil, "unexpected error")
+	tests.Equal(t, e.SetField("trace-id", "def"), nil, "unexpected error")
+	e.AppendData("hello")
+
+	tests.Equal(t, e.String(), "id: 1\ntrace-id: def\nspan: 1\ndata: hello\n\n", "invalid representation")
+
+	v, ok := e.Field("trace-id")
+	tests.Expect(t, ok && v == "def", "field should be set")
+	_, ok = e.Field("missing")
+	tests.Expect(t, !ok, "field should not be set")
+
+	for _, name := range []string{"", "a:b", "a\nb", "data", "event", "id", "retry"} {
+		tests.Expect(t, e.SetField(name, "x") != nil, "invalid name %q should be rejected", name)
+	}
+	tests.Expect(t, e.SetField("x", "a\nb") != nil, "multiline value should be rejected")
+
+	c := e.Clone()
+	_ = c.SetField("span", "2")
+	v, _ = e.Field("span")
+	tests.Equal(t, v, "1", "clone should not modify the original")
+
+	var u Message
+	tests.Equal(t, u.UnmarshalText([]byte(e.String())), nil, "unexpected unmarshal error")
+	tests.DeepEqual(t, u, *e, "text round-trip should preserve fields")
+
+	data, err := json.Marshal(e)
+	tests.Equal(t, err, nil, "unexpected marshal error")
+	tests.Equal(t, string(data), `{"id":"1","fields":[{"name":"trace-id","value":"def"},{"name":"span","value":"1"}],"chunks":[{"data":"hello"}]}`, "invalid JSON representation")
+
+	u = Message{}
+	tests.Equal(t, json.Unmarshal(data, &u), nil, "unexpected unmarshal error")
+	tests.DeepEqual(t, u, *e, "JSON round-trip should preserve fields")
+	tests.Expect(t, json.Unmarshal([]byte(`{"fields":[{"name":"id","value":"x"}]}`), &u) != nil, "invalid field should fail")
+}
+
+func TestMessage_Equal(t *testing.T) {
+	t.Parallel()
+
+	newMessage := func() *Message {
+		m := &Message{ID: ID("1"), Type: Type("x"), Retry: time.Second}
+		m.AppendData("hello\nworld")
+		m.AppendComment("c")
+		_ = m.SetField("f", "v")
+		return m
+	}
+
+	m := newMessage()
+	tests.Expect(t, m.Equal(newMessage()), "identical messages should be equal")
+	tests.Expect(t, m.Equal(m.Clone()), "clone should be equal")
+	tests.Expect(t, (*Message)(nil).Equal(nil), "nil messages should be equal")
+	tests.Expect(t, !m.Equal(nil), "message should not equal nil")
+
+	modifications := map[string]func(m *Message){
+		"ID":      func(m *Message) { m.ID = ID("2") },
+		"Type":    func(m *Message) { m.Type = EventType{} },
+		"Retry":   func(m *Message) { m.Retry = 0 },
+		"Data":    func(m *Message) { m.AppendData("more") },
+		"Field":   func(m *Message) { _ = m.SetField("f", "w") },
+		"Comment": func(m *Message) { m.AppendComment("d") },
+	}
+
+	for name, modify := range modifications {
+		other := newMessage()
+		modify(other)
+		tests.Expect(t, !m.Equal(other), "messages with different %s should not be equal", name)
+	}
+
+	data, comment := &Message{}, &Message{}
+	data.AppendData("x")
+	comment.AppendComment("x")
+	tests.Expect(t, !data.Equal(comment), "data and comments should not be equal")
+}
+
+func TestNewMessageReader(t *testing.T) {
+	t.Parallel()
+
+	ch := make(chan *Message, 5)
+	first := &Message{ID: ID("1")}
+	first.AppendData("hello")
+	ch <- first
+	ch <- &Message{}
+	ch <- nil
+	streamed := &Message{ID: ID("2")}
+	streamed.SetDataReader(strings.NewReader("streamed"))
+	ch <- streamed
+	ch <- &Message{ID: ID("3")}
+	close(ch)
+
+	r := NewMessageReader(ch)
+
+	var got []byte
+	// Tiny reads split the messages at arbitrary points.
+	p := make([]byte, 3)
+	for {
+		n, err := r.Read(p)
+		got = append(got, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		tests.Equal(t, err, nil, "unexpected read error")
+	}
+
+	tests.Equal(t, string(got), "id: 1\ndata: hello\n\nid: 2\ndata: streamed\n\nid: 3\n\n", "invalid stream read")
+
+	n, err := r.Read(p)
+	tests.Equal(t, n, 0, "nothing should be read after EOF")
+	tests.Equal(t, err, io.EOF, "reads after EOF should return EOF")
+
+	readErr := errors.New("read failed")
+	ch = make(chan *Message, 1)
+	failing := &Message{}
+	failing.SetDataReader(iotest.ErrReader(readErr))
+	ch <- failing
+	close(ch)
+
+	_, err = NewMessageReader(ch).Read(p)
+	tests.ErrorIs(t, err, readErr, "data reader errors should be returned")
+}
+
+func TestMessageBuilder(t *testing.T) {
+	t.Parallel()
+
+	b := NewMessage().Type("x").ID("1").Data("hello").Retry(time.Second).Comment("c").Data("multi\nline")
+	built := b.Build()
+
+	expected := &Message{Type: Type("x"), ID: ID("1"), Retry: time.Second}
+	expected.AppendData("hello")
+	expected.AppendComment("c")
+	expected.AppendData("multi\nline")
+
+	tests.Equal(t, built.String(), expected.String(), "built message is different")
+
+	tests.Equal(t, NewMessage().Field("x", "y").Build().String(), "x: y\n\n", "builder should set fields")
+	tests.Panics(t, func() { NewMessage().Field("id", "y") }, "builder should panic on invalid fields")
+
+	b.Data("more")
+	tests.Equal(t, built.String(), expected.String(), "built message should not be changed by the builder")
+	tests.Equal(t, b.Build().String(), expected.String()[:len(expected.String())-1]+"data: more\n\n", "builder should be reusable")
+}
+
 //nolint:all
 func Example_messageWriter() {
 	e := Message{
@@ -212,6 +693,36 @@ func BenchmarkEvent_WriteTo(b *testing.B) {
 	}
 }
 
+// BenchmarkEvent_WriteTo_conn writes to a network connection, where each write is a syscall.
+func BenchmarkEvent_WriteTo_conn(b *testing.B) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Skipf("can't listen: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			_, _ = io.Copy(io.Discard, c)
+			_ = c.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatalf("can't dial: %v", err)
+	}
+	defer conn.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		_, _ = benchmarkEvent.WriteTo(conn)
+	}
+}
+
 var benchmarkText = []string{
 	"Lorem ipsum dolor sit amet, consectetur adipiscing elit.",
 	"Pellentesque at dui non quam faucibus ultricies.",
@@ -244,3 +755,83 @@ func BenchmarkEvent_WriteTo_text(b *testing.B) {
 		_, _ = ev.WriteTo(io.Discard)
 	}
 }
+
+func TestMessage_Variant(t *testing.T) {
+	t.Parallel()
+
+	e := &Message{ID: ID("1")}
+	e.AppendComment("note")
+	e.AppendData(`{"a":1}`)
+	e.AppendVariantData("text/csv", "a\n1")
+	e.AppendVariantData("application/base64", "eyJhIjoxfQ==")
+
+	tests.DeepEqual(t, e.Variants(), []string{"text/csv", "application/base64"}, "invalid variants")
+	tests.Equal(t, e.Variant("").String(), "id: 1\n: note\ndata: {\"a\":1}\n\n", "default variant should be the message's data")
+	tests.Equal(t, e.Variant("text/plain"), e, "message should be returned for unknown variants")
+	tests.Equal(t, e.Variant("text/csv").String(), "id: 1\n: note\ndata: a\ndata: 1\n\n", "invalid variant")
+
+	v := e.Variant("application/base64")
+	tests.Equal(t, len(v.Variants()), 0, "variant should have no variants")
+	tests.Equal(t, e.String(), "id: 1\n: note\ndata: {\"a\":1}\n\n", "wire format should have the default variant")
+
+	c := e.Clone()
+	tests.Expect(t, c.Equal(e), "clone should have the variants")
+	c.AppendVariantData("text/csv", "2")
+	tests.Expect(t, !c.Equal(e), "variants should differ after appending")
+	tests.Equal(t, e.Variant("text/csv").String(), "id: 1\n: note\ndata: a\ndata: 1\n\n", "clone should not modify original variants")
+
+	p, err := json.Marshal(e)
+	tests.Equal(t, err, nil, "unexpected marshal error")
+	tests.Equal(t, string(p), `{"id":"1","chunks":[{"comment":"note"},{"data":"{\"a\":1}"}],"variants":[{"contentType":"text/csv","data":["a","1"]},{"contentType":"application/base64","data":["eyJhIjoxfQ=="]}]}`, "invalid JSON")
+
+	var u Message
+	tests.Equal(t, json.Unmarshal(p, &u), nil, "unexpected unmarshal error")
+	tests.Expect(t, u.Equal(e), "variants should survive JSON")
+
+	err = json.Unmarshal([]byte(`{"variants":[{"contentType":"a","data":[]},{"contentType":"a","data":[]}]}`), &u)
+	tests.Expect(t, err != nil, "duplicate variants should be rejected")
+
+	tests.Panics(t, func() { e.AppendVariantData("", "x") }, "empty content type should panic")
+}
+
+func TestMessage_UnmarshalTextWith_maxDataLines(t *testing.T) {
+	t.Parallel()
+
+	opts := MessageUnmarshalOptions{MaxDataLines: 2}
+
+	var m Message
+	tests.Equal(t, m.UnmarshalTextWith([]byte("data: a\n: comment\ndata: b\n\n"), opts), nil, "events at the limit should be accepted")
+	tests.Equal(t, m.String(), "data: a\n: comment\ndata: b\n\n", "invalid message")
+
+	err := m.UnmarshalTextWith([]byte("data: a\ndata: b\ndata: c\n\n"), opts)
+	tests.ErrorIs(t, err, ErrTooManyDataLines, "events over the limit should be rejected")
+	tests.Expect(t, strings.Contains(err.Error(), "the limit is 2"), "error should tell the limit")
+	tests.Equal(t, m.String(), "", "rejected message should be reset")
+
+	err = m.UnmarshalTextWith([]byte("data: a\ndata: b\ndata: c\n"), MessageUnmarshalOptions{MaxDataLines: 2, Partial: true})
+	tests.ErrorIs(t, err, ErrTooManyDataLines, "partial events over the limit should be rejected")
+
+	tests.Equal(t, m.UnmarshalTextWith([]byte("data: a\ndata: b\ndata: c\n\n"), MessageUnmarshalOptions{}), nil, "there should be no limit by default")
+}
+
+func TestMessage_UnmarshalTextWith_maxEventSize(t *testing.T) {
+	t.Parallel()
+
+	// "data" + "ab" + "comment" + "id" + "1" is 16 bytes.
+	input := []byte("data: ab\n: comment\nid: 1\n\n")
+
+	var m Message
+	tests.Equal(t, m.UnmarshalTextWith(input, MessageUnmarshalOptions{MaxEventSize: 16}), nil, "events at the limit should be accepted")
+	tests.Equal(t, m.String(), "id: 1\ndata: ab\n: comment\n\n", "invalid message")
+
+	err := m.UnmarshalTextWith(input, MessageUnmarshalOptions{MaxEventSize: 15})
+	tests.ErrorIs(t, err, ErrEventTooLarge, "events over the limit should be rejected")
+	tests.Expect(t, strings.Contains(err.Error(), "the limit is 15 bytes"), "error should tell the limit")
+	tests.Equal(t, m.String(), "", "rejected message should be reset")
+
+	unterminated := []byte("x: " + strings.Repeat("a", 100) + "\n" + "data: b\n")
+	err = m.UnmarshalTextWith(unterminated, MessageUnmarshalOptions{MaxEventSize: 50, Partial: true})
+	tests.ErrorIs(t, err, ErrEventTooLarge, "unknown fields should be counted, even for incomplete events")
+
+	tests.Equal(t, m.UnmarshalTextWith(input, MessageUnmarshalOptions{}), nil, "there should be no limit by default")
+}
@@ -0,0 +1,104 @@
+package sse
+
+// messageVariant is the data of a message encoded for a specific content type.
+type messageVariant struct {
+	contentType string
+	chunks      []chunk
+}
+
+// AppendVariantData adds data fields to the variant of the message for the given content type,
+// just like AppendData does for the message's own data. This way a single message can carry
+// the same event encoded in multiple formats – for example JSON and MessagePack – and each
+// client receives the encoding it negotiated: see Session.Variant and Server.Variants.
+// The message's own data is the default variant, sent to the clients which negotiated no
+// content type or one for which the message has no variant.
+//
+// Variants are kept together with the message, so replay providers store them too and replayed
+// messages are sent in the variant of each client. They are included in the JSON representation
+// of the message, so they also survive a Relay; they are not included in the wire format, which
+// always has the data of the default variant.
+//
+// An empty content type is invalid, as it is the one of the default variant, and causes a panic.
+func (e *Message) AppendVariantData(contentType string, chunks ...string) {
+	if contentType == "" {
+		panic("go-sse: empty content type given to Message.AppendVariantData")
+	}
+
+	i := e.variantIndex(contentType)
+	if i == -1 {
+		i = len(e.variants)
+		e.variants = append(e.variants, messageVariant{contentType: contentType})
+	}
+
+	var v Message
+	v.chunks = e.variants[i].chunks
+	v.appendText(false, chunks...)
+	e.variants[i].chunks = v.chunks
+}
+
+// Variant returns the message as sent to a client which negotiated the given content type:
+// if the message has a variant for it, a copy of the message is returned, whose data is the
+// variant's data, written after the message's comments; otherwise, the message itself is returned.
+// The returned message has no variants.
+func (e *Message) Variant(contentType string) *Message {
+	if contentType == "" || len(e.variants) == 0 {
+		return e
+	}
+
+	i := e.variantIndex(contentType)
+	if i == -1 {
+		return e
+	}
+
+	variant := e.Clone()
+	variant.dataReader = nil
+	variant.variants = nil
+	variant.chunks = make([]chunk, 0, len(e.chunks)+len(e.variants[i].chunks))
+	for _, c := range e.chunks {
+		if c.isComment {
+			variant.chunks = append(variant.chunks, c)
+		}
+	}
+	variant.chunks = append(variant.chunks, e.variants[i].chunks...)
+
+	return variant
+}
+
+// Variants returns the content types for which the message has variants, in the order
+// in which they were added.
+func (e *Message) Variants() []string {
+	if len(e.variants) == 0 {
+		return nil
+	}
+
+	contentTypes := make([]string, 0, len(e.variants))
+	for _, v := range e.variants {
+		contentTypes = append(contentTypes, v.contentType)
+	}
+
+	return contentTypes
+}
+
+func (e *Message) variantIndex(contentType string) int {
+	for i := range e.variants {
+		if e.variants[i].contentType == contentType {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func cloneVariants(variants []messageVariant) []messageVariant {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	cloned := make([]messageVariant, len(variants))
+	for i, v := range variants {
+		// Just like for the message's chunks, the capacity is capped so appending doesn't modify the original.
+		cloned[i] = messageVariant{contentType: v.contentType, chunks: v.chunks[:len(v.chunks):len(v.chunks)]}
+	}
+
+	return cloned
+}
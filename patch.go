@@ -0,0 +1,87 @@
+package sse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// MergePatchType is the type of the messages which carry JSON Merge Patches (RFC 7386),
+// as created by Message.SetJSONPatch.
+//
+// The convention is the following: the server sends the full state of an object once,
+// for example as the first message after the client connects, and then only sends
+// the changes as merge patches, each in the data of a message with this type.
+// Clients keep the current state and apply each patch they receive using MergePatch.
+// Given that the patches are relative to the previous state, clients which miss patches
+// must get the full state again – replaying the missed messages or sending the full state
+// to clients which reconnect ensures this.
+const MergePatchType = "patch"
+
+// SetJSONPatch sets the message's type to MergePatchType and appends the patch,
+// encoded as JSON, to the message's data – call it on a message without data.
+// The patch is usually a map or a struct whose fields are omitted when they are unchanged;
+// fields set to null are removed from the state by MergePatch. It returns any errors
+// that occurred while encoding the patch, in which case the message is not modified.
+func (e *Message) SetJSONPatch(patch any) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("go-sse: invalid patch: %w", err)
+	}
+
+	e.Type = Type(MergePatchType)
+	e.AppendData(string(data))
+
+	return nil
+}
+
+// MergePatch applies the JSON Merge Patch to the JSON document, as described by RFC 7386,
+// and returns the resulting document. An empty document is handled as null. Numbers are
+// kept as they are written, but the keys of the objects in the result are sorted.
+//
+// Use it on the client to keep the state updated using the messages of type MergePatchType:
+//
+//	state, err = sse.MergePatch(state, []byte(event.Data))
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target any
+	if len(bytes.TrimSpace(doc)) != 0 {
+		if err := decodeJSON(doc, &target); err != nil {
+			return nil, fmt.Errorf("go-sse: invalid document: %w", err)
+		}
+	}
+
+	var p any
+	if err := decodeJSON(patch, &p); err != nil {
+		return nil, fmt.Errorf("go-sse: invalid patch: %w", err)
+	}
+
+	return json.Marshal(mergePatch(target, p))
+}
+
+func decodeJSON(data []byte, v any) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any, len(p))
+	}
+
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = mergePatch(t[name], value)
+		}
+	}
+
+	return t
+}
@@ -0,0 +1,58 @@
+package sse_test
+
+import (
+	"testing"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/internal/tests"
+)
+
+func TestMessage_SetJSONPatch(t *testing.T) {
+	t.Parallel()
+
+	m := &sse.Message{}
+	tests.Equal(t, m.SetJSONPatch(map[string]any{"name": "go-sse", "old": nil}), nil, "unexpected error")
+	tests.Equal(t, m.String(), "event: patch\ndata: {\"name\":\"go-sse\",\"old\":null}\n\n", "invalid patch message")
+
+	m = &sse.Message{}
+	tests.Expect(t, m.SetJSONPatch(func() {}) != nil, "invalid patch should return an error")
+	tests.Equal(t, m.String(), "", "message should not be modified on error")
+}
+
+func TestMergePatch(t *testing.T) {
+	t.Parallel()
+
+	// The examples from RFC 7386, Appendix A.
+	cases := []struct {
+		doc, patch, result string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		// Not from the RFC.
+		{``, `{"a":12345678901234567890}`, `{"a":12345678901234567890}`},
+	}
+
+	for _, c := range cases {
+		result, err := sse.MergePatch([]byte(c.doc), []byte(c.patch))
+		tests.Equal(t, err, nil, "unexpected error for %s + %s", c.doc, c.patch)
+		tests.Equal(t, string(result), c.result, "invalid result for %s + %s", c.doc, c.patch)
+	}
+
+	_, err := sse.MergePatch([]byte(`{`), []byte(`{}`))
+	tests.Expect(t, err != nil, "invalid document should return an error")
+	_, err = sse.MergePatch(nil, []byte(``))
+	tests.Expect(t, err != nil, "invalid patch should return an error")
+}
@@ -0,0 +1,281 @@
+package sse
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+type (
+	poolSubscriber struct {
+		done chan error
+		// limiter is nil if the subscription has no rate limit.
+		limiter *rateLimiter
+		Subscription
+		// The index of the worker which sends the messages to the subscriber.
+		worker int
+	}
+
+	// A poolJob either sends a message, adds a subscriber or removes a subscriber.
+	poolJob struct {
+		add     *poolSubscriber
+		remove  *poolSubscriber
+		message messageWithTopics
+	}
+)
+
+// Pool is a server provider which sends messages to subscribers using a fixed number of worker
+// goroutines. Just like Joe, it executes operations synchronously in a single goroutine,
+// but each subscriber is owned by one of the workers, which sends it the messages. This way
+// a very large number of subscribers, or a slow subscriber, doesn't delay the delivery
+// to all the other subscribers by as much.
+//
+// Because a subscriber is owned by a single worker, it receives the messages in the order
+// they were published. Messages are replayed to new subscribers before they receive any
+// of the newly published messages.
+//
+// Pool optionally supports event replaying with the help of a replay provider, exactly
+// as Joe does – see the Joe documentation for how replay provider panics are handled.
+// Just like with Joe, messages which have only comment fields are not put into the
+// replay provider, unless ReplayHeartbeats is set. Rate limits and send timeouts
+// work the same as with Joe.
+//
+// Use Pool instead of Joe when there are thousands of subscribers on the same topics.
+// For smaller deployments Joe is lighter on resources.
+type Pool struct {
+	message        chan messageWithTopics
+	subscription   chan *poolSubscriber
+	unsubscription chan *poolSubscriber
+	done           chan struct{}
+	closed         chan struct{}
+	workers        []chan poolJob
+
+	// An optional replay provider that Pool uses to resend older messages to new subscribers.
+	// Its panics are handled the same way Joe handles them.
+	ReplayProvider ReplayProvider
+
+	// The number of worker goroutines. Defaults to GOMAXPROCS.
+	Workers int
+	// The default maximum duration of sending a message to a subscriber.
+	// See Joe.SendTimeout for details.
+	SendTimeout time.Duration
+
+	nextWorker int
+
+	// Put messages which have only comment fields into the replay provider.
+	// See Joe.ReplayHeartbeats for details.
+	ReplayHeartbeats bool
+
+	initDone sync.Once
+}
+
+// Subscribe tells Pool to send new messages to this subscriber. The subscription
+// is automatically removed when the context is done, a callback error occurs
+// or Pool is stopped. Subscribe returns only after the subscriber's worker is done
+// with it, so nothing is sent to the subscriber afterwards. Just like with Joe,
+// the error returned tells why the subscriber was removed – see Provider.Subscribe.
+func (p *Pool) Subscribe(ctx context.Context, sub Subscription) error {
+	p.init()
+
+	s := &poolSubscriber{done: make(chan error, 1), Subscription: sub}
+
+	select {
+	case <-p.done:
+		return ErrProviderClosed
+	default:
+	}
+
+	select {
+	case <-p.done:
+		return ErrProviderClosed
+	case p.subscription <- s:
+	}
+
+	select {
+	case err := <-s.done:
+		return err
+	case <-ctx.Done():
+	}
+
+	select {
+	case err := <-s.done:
+		return err
+	case p.unsubscription <- s:
+		return <-s.done
+	}
+}
+
+// Publish tells Pool to send the given message to the subscribers.
+// Just like with Joe, every client receives each unique message once,
+// regardless of how many topics it is subscribed to or to how many topics
+// the message is published. Publish returns after the message is queued
+// to the workers, not after it is sent to the subscribers.
+func (p *Pool) Publish(msg *Message, topics []string) error {
+	if len(topics) == 0 {
+		return ErrNoTopic
+	}
+
+	p.init()
+
+	select {
+	case p.message <- messageWithTopics{message: msg, topics: topics}:
+		return nil
+	case <-p.done:
+		return ErrProviderClosed
+	}
+}
+
+// Shutdown signals Pool to close all subscribers and stop receiving messages.
+// It returns when all the subscribers are closed and the workers are stopped.
+// Messages published before Shutdown is called are still sent to the subscribers.
+//
+// Further calls to Shutdown will return ErrProviderClosed.
+func (p *Pool) Shutdown(ctx context.Context) (err error) {
+	p.init()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrProviderClosed
+		}
+	}()
+
+	close(p.done)
+
+	select {
+	case <-p.closed:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return
+}
+
+func (p *Pool) start(replay ReplayProvider) {
+	var wg sync.WaitGroup
+
+	defer close(p.closed)
+	defer wg.Wait()
+	// Stop the workers also in case of a panic, so the subscribers don't end up blocked.
+	defer func() {
+		for _, w := range p.workers {
+			close(w)
+		}
+	}()
+
+	for _, w := range p.workers {
+		w := w
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(w)
+		}()
+	}
+
+	canReplay := true
+
+	for {
+		select {
+		case msg := <-p.message:
+			if canReplay && (p.ReplayHeartbeats || !msg.message.isHeartbeat()) {
+				msg.message = tryPut(msg, replay, &canReplay)
+			}
+
+			for _, w := range p.workers {
+				w <- poolJob{message: msg}
+			}
+		case sub := <-p.subscription:
+			var err error
+			if canReplay {
+				_, _, err = replayTo(sub.Subscription, replay, p.SendTimeout)
+			}
+			if err == nil {
+				err = sendReplayDone(sub.Subscription, p.SendTimeout)
+			}
+
+			if err != nil {
+				sub.done <- err
+				close(sub.done)
+			} else {
+				sub.worker = p.nextWorker
+				p.nextWorker = (p.nextWorker + 1) % len(p.workers)
+				if sub.RateLimit > 0 {
+					sub.limiter = newRateLimiter(sub.RateLimit, sub.RateBurst)
+				}
+				p.workers[sub.worker] <- poolJob{add: sub}
+			}
+		case sub := <-p.unsubscription:
+			p.workers[sub.worker] <- poolJob{remove: sub}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Pool) work(jobs <-chan poolJob) {
+	subscribers := map[*poolSubscriber]struct{}{}
+
+	defer func() {
+		for sub := range subscribers {
+			sub.done <- ErrProviderClosed
+			close(sub.done)
+		}
+	}()
+
+	for job := range jobs {
+		switch {
+		case job.add != nil:
+			subscribers[job.add] = struct{}{}
+		case job.remove != nil:
+			// The subscriber isn't found if it was already closed after an error.
+			if _, ok := subscribers[job.remove]; ok {
+				delete(subscribers, job.remove)
+				close(job.remove.done)
+			}
+		default:
+			now := time.Now()
+			for sub := range subscribers {
+				if !sub.receives(job.message.topics) || (sub.limiter != nil && !sub.limiter.allow(now)) {
+					continue
+				}
+
+				if err := send(sub.Subscription, job.message.message, p.SendTimeout); err != nil {
+					delete(subscribers, sub)
+					sub.done <- err
+					close(sub.done)
+				}
+			}
+		}
+	}
+}
+
+func (p *Pool) init() {
+	p.initDone.Do(func() {
+		workers := p.Workers
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+
+		p.message = make(chan messageWithTopics)
+		p.subscription = make(chan *poolSubscriber)
+		p.unsubscription = make(chan *poolSubscriber)
+		p.done = make(chan struct{})
+		p.closed = make(chan struct{})
+		p.workers = make([]chan poolJob, workers)
+		for i := range p.workers {
+			p.workers[i] = make(chan poolJob, poolQueueSize)
+		}
+
+		replay := p.ReplayProvider
+		if replay == nil {
+			replay = noopReplayProvider{}
+		}
+		go p.start(replay)
+	})
+}
+
+// poolQueueSize is the number of jobs each worker can have queued before the run loop waits.
+const poolQueueSize = 64
+
+var _ Provider = (*Pool)(nil)
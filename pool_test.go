@@ -0,0 +1,198 @@
+package sse_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/internal/tests"
+)
+
+func TestPool_Shutdown(t *testing.T) {
+	t.Parallel()
+
+	p := &sse.Pool{}
+
+	tests.Equal(t, p.Shutdown(context.Background()), nil, "pool should close successfully")
+	tests.Equal(t, p.Shutdown(context.Background()), sse.ErrProviderClosed, "pool should already be closed")
+	tests.Equal(t, p.Subscribe(context.Background(), sse.Subscription{}), sse.ErrProviderClosed, "no operation should be allowed on closed pool")
+	tests.Equal(t, p.Publish(nil, nil), sse.ErrNoTopic, "parameter validation should happen first")
+	tests.Equal(t, p.Publish(nil, []string{sse.DefaultTopic}), sse.ErrProviderClosed, "no operation should be allowed on closed pool")
+}
+
+func TestPool_SubscribePublish(t *testing.T) {
+	t.Parallel()
+
+	const subscribers, messages = 10, 50
+
+	p := &sse.Pool{Workers: 3}
+	defer p.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	subs := make([]<-chan []*sse.Message, 0, subscribers)
+	for i := 0; i < subscribers; i++ {
+		ctx, cancel := newMockContext(t)
+		defer cancel()
+
+		subs = append(subs, subscribe(t, p, ctx))
+		<-ctx.waitingOnDone
+	}
+
+	ctx, cancel := newMockContext(t)
+	other := subscribe(t, p, ctx, "other")
+	<-ctx.waitingOnDone
+
+	for i := 0; i < messages; i++ {
+		tests.Equal(t, p.Publish(msg(t, "", strconv.Itoa(i)), []string{sse.DefaultTopic}), nil, "publish should succeed")
+	}
+
+	cancel()
+	tests.Equal(t, len(<-other), 0, "subscriber to other topic should receive nothing")
+
+	tests.Equal(t, p.Shutdown(context.Background()), nil, "shutdown should succeed")
+
+	for i, sub := range subs {
+		msgs := <-sub
+		tests.Equal(t, len(msgs), messages, "subscriber %d should receive all messages", i)
+		for j, m := range msgs {
+			tests.Equal(t, m.ID.String(), strconv.Itoa(j), "subscriber %d received messages out of order", i)
+		}
+	}
+}
+
+func TestPool_replay(t *testing.T) {
+	t.Parallel()
+
+	fin, err := sse.NewFiniteReplayProvider(3, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	p := &sse.Pool{ReplayProvider: fin}
+	defer p.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	_ = p.Publish(msg(t, "", "0"), []string{sse.DefaultTopic})
+	_ = p.Publish(msg(t, "", "1"), []string{sse.DefaultTopic})
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	var received []string
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Subscribe(ctx, sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					received = append(received, m.ID.String())
+				}
+				return nil
+			}),
+			LastEventID: sse.ID("0"),
+			Topics:      []string{sse.DefaultTopic},
+		})
+	}()
+	<-ctx.waitingOnDone
+
+	_ = p.Publish(msg(t, "", "2"), []string{sse.DefaultTopic})
+
+	tests.Equal(t, p.Shutdown(context.Background()), nil, "shutdown should succeed")
+	tests.Equal(t, <-done, sse.ErrProviderClosed, "unexpected subscribe error")
+	tests.DeepEqual(t, received, []string{"1", "2"}, "replayed messages should be received before new ones")
+}
+
+func TestPool_errors(t *testing.T) {
+	t.Parallel()
+
+	p := &sse.Pool{}
+	defer p.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	callErr := errors.New("artificial fail")
+
+	var called int
+	client := mockClient(func(m *sse.Message) error {
+		if m != nil {
+			called++
+		}
+		return callErr
+	})
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		<-ctx.waitingOnDone
+
+		_ = p.Publish(msg(t, "", "0"), []string{sse.DefaultTopic})
+		_ = p.Publish(msg(t, "", "1"), []string{sse.DefaultTopic})
+	}()
+
+	err := p.Subscribe(ctx, sse.Subscription{Client: client, Topics: []string{sse.DefaultTopic}})
+	tests.Equal(t, err, callErr, "error not received from send")
+
+	<-done
+	// Shutdown waits for the workers, so the callback can't be called concurrently anymore.
+	tests.Equal(t, p.Shutdown(context.Background()), nil, "shutdown should succeed")
+	tests.Equal(t, called, 1, "callback was called after subscribe returned")
+}
+
+func BenchmarkPool(b *testing.B) {
+	for _, subscribers := range [...]int{10, 1000, 10000} {
+		subscribers := subscribers
+
+		b.Run(fmt.Sprintf("Joe/%d", subscribers), func(b *testing.B) {
+			benchmarkProvider(b, &sse.Joe{}, subscribers)
+		})
+		b.Run(fmt.Sprintf("Pool/%d", subscribers), func(b *testing.B) {
+			benchmarkProvider(b, &sse.Pool{}, subscribers)
+		})
+	}
+}
+
+func benchmarkProvider(b *testing.B, p sse.Provider, subscribers int) {
+	b.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := mockClient(func(m *sse.Message) error {
+		if m == nil {
+			return nil
+		}
+		_, err := m.WriteTo(io.Discard)
+		return err
+	})
+
+	var stopped sync.WaitGroup
+	stopped.Add(subscribers)
+
+	for i := 0; i < subscribers; i++ {
+		subctx := &mockContext{Context: ctx, waitingOnDone: make(chan struct{})}
+
+		go func() {
+			defer stopped.Done()
+			_ = p.Subscribe(subctx, sse.Subscription{Client: client, Topics: []string{sse.DefaultTopic}})
+		}()
+
+		<-subctx.waitingOnDone
+	}
+
+	m := &sse.Message{}
+	m.AppendData("hello world")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = p.Publish(m, []string{sse.DefaultTopic})
+	}
+	// Shutting down waits for the queued messages to be sent.
+	_ = p.Shutdown(context.Background())
+
+	b.StopTimer()
+	stopped.Wait()
+}
@@ -0,0 +1,56 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// PublishAll reads the reader line by line, decodes each line to a message using the decode function
+// and publishes the message to the given topics. It is meant for loading events in bulk, for example
+// when warm-starting a provider with a backlog of events stored as JSON Lines. Empty lines are ignored.
+//
+// It stops at the first error – returned by the reader, the decode function or the provider – and
+// returns it together with the number of messages published until then. If decoding a line fails,
+// the error also tells the line's number. The context is checked before each line is published:
+// if it is done, PublishAll stops and returns the context's error.
+//
+// The messages are published in the order of the lines, so subscribers receive them in that order.
+func PublishAll(ctx context.Context, p Provider, r io.Reader, topics []string, decode func(line []byte) (*Message, error)) (int, error) {
+	if decode == nil {
+		return 0, errors.New("go-sse: decode function must not be nil")
+	}
+
+	br := bufio.NewReader(r)
+	published := 0
+
+	for lineNum := 1; ; lineNum++ {
+		line, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return published, err
+		}
+
+		if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return published, ctxErr
+			}
+
+			m, decodeErr := decode(line)
+			if decodeErr != nil {
+				return published, fmt.Errorf("go-sse: failed to decode line %d: %w", lineNum, decodeErr)
+			}
+
+			if pubErr := p.Publish(m, topics); pubErr != nil {
+				return published, pubErr
+			}
+			published++
+		}
+
+		if err != nil {
+			return published, nil
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package sse_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/internal/tests"
+)
+
+type recordingProvider struct {
+	mockProvider
+	published []string
+}
+
+func (r *recordingProvider) Publish(msg *sse.Message, topics []string) error {
+	r.published = append(r.published, msg.ID.String()+"@"+strings.Join(topics, ","))
+	return nil
+}
+
+func TestPublishAll(t *testing.T) {
+	t.Parallel()
+
+	decode := func(line []byte) (*sse.Message, error) {
+		var event struct {
+			ID   sse.EventID `json:"id"`
+			Data string      `json:"data"`
+		}
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, err
+		}
+
+		m := &sse.Message{ID: event.ID}
+		m.AppendData(event.Data)
+		return m, nil
+	}
+
+	input := "{\"id\":\"1\",\"data\":\"a\"}\r\n\n{\"id\":\"2\",\"data\":\"b\"}\n{\"id\":\"3\",\"data\":\"c\"}"
+
+	p := &recordingProvider{}
+	n, err := sse.PublishAll(context.Background(), p, strings.NewReader(input), []string{"t"}, decode)
+	tests.Equal(t, err, nil, "unexpected error")
+	tests.Equal(t, n, 3, "all the lines should be published")
+	tests.DeepEqual(t, p.published, []string{"1@t", "2@t", "3@t"}, "messages should be published in order")
+
+	p = &recordingProvider{}
+	n, err = sse.PublishAll(context.Background(), p, strings.NewReader(input+"\nnot json\n{}"), []string{"t"}, decode)
+	tests.Expect(t, err != nil && strings.Contains(err.Error(), "line 5"), "decode error should tell the line")
+	tests.Equal(t, n, 3, "messages before the invalid line should be published")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err = sse.PublishAll(ctx, &recordingProvider{}, strings.NewReader(input), []string{"t"}, decode)
+	tests.ErrorIs(t, err, context.Canceled, "context error should be returned")
+	tests.Equal(t, n, 0, "nothing should be published after cancellation")
+
+	j := &sse.Joe{}
+	tests.Equal(t, j.Shutdown(context.Background()), nil, "unexpected shutdown error")
+	_, err = sse.PublishAll(context.Background(), j, strings.NewReader(input), []string{"t"}, decode)
+	tests.ErrorIs(t, err, sse.ErrProviderClosed, "provider error should be returned")
+
+	readErr := errors.New("read failed")
+	_, err = sse.PublishAll(context.Background(), p, errReader{readErr}, []string{"t"}, decode)
+	tests.ErrorIs(t, err, readErr, "reader error should be returned")
+}
+
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
@@ -0,0 +1,152 @@
+package sse
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// A Transport is an external messaging system, such as Redis Pub/Sub or NATS,
+// used by a Relay to share published messages between multiple nodes.
+//
+// Implementations must be thread-safe. The payloads are opaque to the transport
+// and must be delivered unaltered.
+type Transport interface {
+	// Publish sends the payload to all the nodes connected to the transport.
+	// It is not an issue if the payload is also delivered back to the sender.
+	Publish(payload []byte) error
+	// Subscribe registers a callback which must be called for every payload
+	// the transport receives. It is called only once, when the Relay is initialized.
+	Subscribe(fn func(payload []byte))
+}
+
+// Relay is a Provider which enables horizontal scaling: it publishes messages to
+// a local provider and forwards them to other nodes through a Transport.
+// Messages received from the Transport are published to the local provider,
+// so clients connected to any node receive all the messages.
+//
+// The local provider's replay provider will also store messages received from other nodes.
+// If replay providers which set IDs automatically are used, each node will assign
+// different IDs to the same message – set the IDs before publishing instead.
+//
+// Payloads received from the transport which are invalid or which were sent by
+// the Relay itself are dropped.
+type Relay struct {
+	// The provider to which messages are published on the current node.
+	// Defaults to Joe.
+	Provider Provider
+	// The transport used to exchange messages with the other nodes. Required.
+	Transport Transport
+
+	provider Provider
+	origin   string
+	initDone sync.Once
+}
+
+// NewRelayedJoe creates a Relay which uses the given Joe as the local provider.
+// If joe is nil, a new Joe without a replay provider is used.
+func NewRelayedJoe(joe *Joe, transport Transport) *Relay {
+	if joe == nil {
+		joe = &Joe{}
+	}
+
+	return &Relay{Provider: joe, Transport: transport}
+}
+
+type relayEnvelope struct {
+	Origin string `json:"origin"`
+	// Message is the JSON representation of the message, so its variants are relayed too.
+	// Nodes running older versions send the wire format instead, as a JSON string.
+	Message json.RawMessage `json:"message"`
+	Topics  []string        `json:"topics"`
+}
+
+// Subscribe subscribes to the local provider.
+func (r *Relay) Subscribe(ctx context.Context, sub Subscription) error {
+	r.init()
+	return r.provider.Subscribe(ctx, sub)
+}
+
+// Publish publishes the message to the local provider and then forwards it to the other nodes.
+// If the message couldn't be published locally, it is not forwarded.
+func (r *Relay) Publish(msg *Message, topics []string) error {
+	r.init()
+
+	// The message must be encoded before it is published locally: once published, the local
+	// provider may modify it concurrently, for example when its replay provider sets the ID.
+	encoded, err := msg.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("go-sse.server: failed to encode relayed message: %w", err)
+	}
+	payload, err := json.Marshal(relayEnvelope{Origin: r.origin, Message: encoded, Topics: topics})
+	if err != nil {
+		return fmt.Errorf("go-sse.server: failed to encode relayed message: %w", err)
+	}
+
+	if err := r.provider.Publish(msg, topics); err != nil {
+		return err
+	}
+
+	if err := r.Transport.Publish(payload); err != nil {
+		return fmt.Errorf("go-sse.server: failed to relay message: %w", err)
+	}
+
+	return nil
+}
+
+// Shutdown shuts down the local provider. The transport is not closed – messages
+// received afterwards are dropped.
+func (r *Relay) Shutdown(ctx context.Context) error {
+	r.init()
+	return r.provider.Shutdown(ctx)
+}
+
+func (r *Relay) receive(payload []byte) {
+	var env relayEnvelope
+	if err := json.Unmarshal(payload, &env); err != nil || env.Origin == r.origin || len(env.Topics) == 0 {
+		return
+	}
+
+	msg := &Message{}
+	if len(env.Message) > 0 && env.Message[0] == '"' {
+		var text string
+		if err := json.Unmarshal(env.Message, &text); err != nil {
+			return
+		}
+		if text != "" {
+			if err := msg.UnmarshalText([]byte(text)); err != nil {
+				return
+			}
+		}
+	} else if len(env.Message) > 0 {
+		if err := msg.UnmarshalJSON(env.Message); err != nil {
+			return
+		}
+	}
+
+	_ = r.provider.Publish(msg, env.Topics)
+}
+
+func (r *Relay) init() {
+	r.initDone.Do(func() {
+		if r.Transport == nil {
+			panic("go-sse: a Relay requires a Transport")
+		}
+
+		r.provider = r.Provider
+		if r.provider == nil {
+			r.provider = &Joe{}
+		}
+
+		var origin [16]byte
+		_, _ = rand.Read(origin[:])
+		r.origin = hex.EncodeToString(origin[:])
+
+		r.Transport.Subscribe(r.receive)
+	})
+}
+
+var _ Provider = (*Relay)(nil)
@@ -0,0 +1,131 @@
+package sse_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/internal/tests"
+)
+
+type memoryTransport struct {
+	mu   sync.Mutex
+	subs []func([]byte)
+}
+
+func (m *memoryTransport) Publish(payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, fn := range m.subs {
+		fn(payload)
+	}
+
+	return nil
+}
+
+func (m *memoryTransport) Subscribe(fn func([]byte)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.subs = append(m.subs, fn)
+}
+
+func TestRelay(t *testing.T) {
+	t.Parallel()
+
+	tr := &memoryTransport{}
+	a := sse.NewRelayedJoe(nil, tr)
+	b := sse.NewRelayedJoe(&sse.Joe{}, tr)
+
+	ctxA, cancelA := newMockContext(t)
+	defer cancelA()
+	ctxB, cancelB := newMockContext(t)
+	defer cancelB()
+
+	subA := subscribe(t, a, ctxA)
+	subB := subscribe(t, b, ctxB)
+	<-ctxA.waitingOnDone
+	<-ctxB.waitingOnDone
+
+	m := msg(t, "hello", "1")
+	m.AppendComment("relayed")
+	tests.Equal(t, a.Publish(m, []string{sse.DefaultTopic}), nil, "unexpected publish error")
+
+	tests.Equal(t, a.Shutdown(context.Background()), nil, "unexpected shutdown error")
+	tests.Equal(t, b.Shutdown(context.Background()), nil, "unexpected shutdown error")
+
+	msgsA, msgsB := <-subA, <-subB
+	tests.Equal(t, len(msgsA), 1, "message should be received once on the publishing node")
+	tests.Equal(t, len(msgsB), 1, "message should be relayed to the other node")
+	tests.Equal(t, msgsB[0].String(), m.String(), "relayed message is different")
+}
+
+func TestRelay_variants(t *testing.T) {
+	t.Parallel()
+
+	tr := &memoryTransport{}
+	a := sse.NewRelayedJoe(nil, tr)
+	b := sse.NewRelayedJoe(nil, tr)
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	sub := subscribe(t, b, ctx)
+	<-ctx.waitingOnDone
+
+	m := msg(t, "json", "1")
+	m.AppendVariantData("application/msgpack", "packed")
+	tests.Equal(t, a.Publish(m, []string{sse.DefaultTopic}), nil, "unexpected publish error")
+
+	tests.Equal(t, a.Shutdown(context.Background()), nil, "unexpected shutdown error")
+	tests.Equal(t, b.Shutdown(context.Background()), nil, "unexpected shutdown error")
+
+	msgs := <-sub
+	tests.Equal(t, len(msgs), 1, "message should be relayed to the other node")
+	tests.Expect(t, msgs[0].Equal(m), "relayed message should have the variants")
+	tests.Equal(t, msgs[0].Variant("application/msgpack").String(), "id: 1\ndata: packed\n\n", "relayed variant is different")
+}
+
+func TestRelay_wireFormatPayload(t *testing.T) {
+	t.Parallel()
+
+	tr := &memoryTransport{}
+	b := sse.NewRelayedJoe(nil, tr)
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	sub := subscribe(t, b, ctx)
+	<-ctx.waitingOnDone
+
+	// Nodes running older versions relay the wire format of the messages.
+	tests.Equal(t, tr.Publish([]byte(`{"origin":"old","message":"id: 1\ndata: hello\n\n","topics":["`+sse.DefaultTopic+`"]}`)), nil, "unexpected publish error")
+	tests.Equal(t, b.Shutdown(context.Background()), nil, "unexpected shutdown error")
+
+	msgs := <-sub
+	tests.Equal(t, len(msgs), 1, "message should be received")
+	tests.Equal(t, msgs[0].String(), "id: 1\ndata: hello\n\n", "relayed message is different")
+}
+
+func TestRelay_autoIDs(t *testing.T) {
+	t.Parallel()
+
+	rp, err := sse.NewFiniteReplayProvider(10, true)
+	tests.Equal(t, err, nil, "should create replay provider")
+
+	tr := &memoryTransport{}
+	a := sse.NewRelayedJoe(&sse.Joe{ReplayProvider: rp}, tr)
+	defer a.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	var relayed []string
+	tr.Subscribe(func(payload []byte) { relayed = append(relayed, string(payload)) })
+
+	// The local replay provider sets the IDs concurrently with the relay encoding the message.
+	for i := 0; i < 5; i++ {
+		tests.Equal(t, a.Publish(msg(t, "hello", ""), []string{sse.DefaultTopic}), nil, "unexpected publish error")
+	}
+
+	tests.Equal(t, len(relayed), 5, "all messages should be relayed")
+}
@@ -12,13 +12,26 @@ type buffer interface {
 	queue(message *Message, topics []string) *Message
 	dequeue()
 	front() *messageWithTopics
+	at(index int) *messageWithTopics
 	len() int
 	cap() int
+	// upcomingCursor returns the cursor of the next message queued.
+	upcomingCursor() int64
+	// slice returns the messages after the one with the given ID,
+	// or nil if the ID is not found.
 	slice(EventID) []messageWithTopics
+	all() []messageWithTopics
+	// indexOf returns the index of the oldest message with the given ID, or -1.
+	indexOf(EventID) int
+	removeAt(index int)
 }
 
 type bufferBase struct {
 	buf []messageWithTopics
+	// minCap is the minimum capacity the buffer is grown to.
+	minCap int
+	// nextCursor is the cursor of the next message queued.
+	nextCursor int64
 }
 
 func (b *bufferBase) len() int {
@@ -29,6 +42,10 @@ func (b *bufferBase) cap() int {
 	return cap(b.buf)
 }
 
+func (b *bufferBase) upcomingCursor() int64 {
+	return b.nextCursor
+}
+
 func (b *bufferBase) front() *messageWithTopics {
 	if b.len() == 0 {
 		return nil
@@ -36,16 +53,53 @@ func (b *bufferBase) front() *messageWithTopics {
 	return &b.buf[0]
 }
 
+func (b *bufferBase) at(index int) *messageWithTopics {
+	return &b.buf[index]
+}
+
+func (b *bufferBase) all() []messageWithTopics {
+	return b.buf
+}
+
+// removeAt removes the message at the given index. It must not be used by bufferAutoID,
+// which relies on the messages being consecutive.
+func (b *bufferBase) removeAt(index int) {
+	last := len(b.buf) - 1
+	copy(b.buf[index:], b.buf[index+1:])
+	b.buf[last] = messageWithTopics{}
+	b.buf = b.buf[:last]
+}
+
 func (b *bufferBase) queue(message *Message, topics []string) *Message {
 	if len(topics) == 0 {
 		panic(errors.New("go-sse: no topics provided for Message.\n" + formatMessagePanicString(message)))
 	}
 
-	b.buf = append(b.buf, messageWithTopics{message: message, topics: topics})
+	b.buf = appendGrow(b.buf, messageWithTopics{message: message, topics: topics, cursor: b.nextCursor}, b.minCap)
+	b.nextCursor++
 
 	return message
 }
 
+// appendGrow appends v to s. If s is full, it is reallocated to double the length, but at least
+// to minCap. Growing more than append does matters for buffers which are consumed from the front,
+// as their capacity shrinks with each dequeue: with a steady number of elements, append would
+// reallocate them after only a fourth of that number of new elements are added.
+func appendGrow[T any](s []T, v T, minCap int) []T {
+	if len(s) == cap(s) {
+		newCap := 2 * len(s)
+		if newCap < minCap {
+			newCap = minCap
+		}
+
+		grown := make([]T, len(s), newCap)
+		copy(grown, s)
+		s = grown
+	}
+
+	return append(s, v)
+}
+
 func (b *bufferBase) dequeue() {
 	// It may seem at first glance that the backing array would grow indefinitely,
 	// but factor in that when the slice is reallocated all the dequeued elements
@@ -55,6 +109,9 @@ func (b *bufferBase) dequeue() {
 
 type bufferNoID struct {
 	lastRemovedID EventID
+	index         idIndex
+	// base is the position of the oldest buffered message in the index.
+	base int64
 	bufferBase
 }
 
@@ -74,14 +131,32 @@ func (b *bufferNoID) queue(message *Message, topics []string) *Message {
 		panic(errors.New(panicString))
 	}
 
-	return b.bufferBase.queue(message, topics)
+	message = b.bufferBase.queue(message, topics)
+	b.index.add(message.ID, b.base+int64(len(b.buf))-1)
+
+	return message
 }
 
 func (b *bufferNoID) dequeue() {
 	b.lastRemovedID = b.buf[0].message.ID
+	b.index.remove(b.lastRemovedID, b.base)
+	b.base++
 	b.bufferBase.dequeue()
 }
 
+func (b *bufferNoID) indexOf(id EventID) int {
+	if pos, ok := b.index.find(id); ok {
+		return int(pos - b.base)
+	}
+
+	return -1
+}
+
+func (b *bufferNoID) removeAt(index int) {
+	b.bufferBase.removeAt(index)
+	b.index.rebuild(b.buf, b.base)
+}
+
 func (b *bufferNoID) slice(atID EventID) []messageWithTopics {
 	if !atID.IsSet() {
 		return nil
@@ -89,13 +164,8 @@ func (b *bufferNoID) slice(atID EventID) []messageWithTopics {
 	if atID == b.lastRemovedID {
 		return b.buf
 	}
-	index := -1
-	for i := range b.buf {
-		if atID == b.buf[i].message.ID {
-			index = i
-			break
-		}
-	}
+
+	index := b.indexOf(atID)
 	if index == -1 {
 		return nil
 	}
@@ -136,8 +206,132 @@ func (b *bufferAutoID) slice(atID EventID) []messageWithTopics {
 	return b.buf[index+1:]
 }
 
-func getBuffer(autoIDs bool, capacity int) buffer {
-	base := bufferBase{buf: make([]messageWithTopics, 0, capacity)}
+// indexOf computes the index from the ID, as the IDs are consecutive.
+func (b *bufferAutoID) indexOf(atID EventID) int {
+	id, err := strconv.ParseInt(atID.String(), autoIDBase, 64)
+	if err != nil {
+		return -1
+	}
+	index := id - b.firstID
+	if index < 0 || index >= int64(len(b.buf)) || b.buf[index].message.ID != atID {
+		return -1
+	}
+	return int(index)
+}
+
+// bufferKeepID sets IDs only for the messages which don't have one. Given that the IDs
+// aren't necessarily sequential, messages are looked up the same way bufferNoID does.
+type bufferKeepID struct {
+	bufferNoID
+	upcomingID int64
+}
+
+func (b *bufferKeepID) queue(message *Message, topics []string) *Message {
+	if message.ID.IsSet() {
+		b.upcomingID = nextAutoID(b.upcomingID, message.ID)
+	} else {
+		message = message.Clone()
+		message.ID = ID(strconv.FormatInt(b.upcomingID, autoIDBase))
+		b.upcomingID++
+	}
+
+	return b.bufferNoID.queue(message, topics)
+}
+
+// nextAutoID returns the ID which should be set after a message having the given ID,
+// so that the automatically set IDs increase even if some messages have numeric IDs set.
+func nextAutoID(upcoming int64, id EventID) int64 {
+	if n, err := strconv.ParseInt(id.String(), autoIDBase, 64); err == nil && n >= upcoming {
+		return n + 1
+	}
+
+	return upcoming
+}
+
+// idIndex maps the IDs of the buffered messages to their positions, so the messages are found
+// in constant time instead of by scanning the buffer. Positions are assigned by the buffers in
+// the order the messages are queued and they don't change when older messages are removed,
+// so the index doesn't have to be updated for the other messages.
+//
+// When multiple messages have the same ID, the oldest of them is found, just like when scanning.
+// The positions of the newer ones are linked from the older ones, so the next oldest is found
+// in constant time when the oldest is dequeued – no memory is used for this if the IDs are unique.
+type idIndex struct {
+	ids map[EventID]idEntry
+	// next maps the position of a message to the position of the next message with the same ID.
+	next map[int64]int64
+}
+
+type idEntry struct {
+	// The positions of the oldest and newest messages with the ID.
+	oldest, newest int64
+	// The number of buffered messages with the ID.
+	count int
+}
+
+// add adds the message with the given ID, queued at the given position,
+// which must be greater than the positions of all the indexed messages.
+func (x *idIndex) add(id EventID, pos int64) {
+	if x.ids == nil {
+		x.ids = map[EventID]idEntry{}
+	}
+
+	e, ok := x.ids[id]
+	if !ok {
+		x.ids[id] = idEntry{oldest: pos, newest: pos, count: 1}
+		return
+	}
+
+	if x.next == nil {
+		x.next = map[int64]int64{}
+	}
+	x.next[e.newest] = pos
+
+	e.newest = pos
+	e.count++
+	x.ids[id] = e
+}
+
+// remove removes the message with the given ID at the given position,
+// which must be the oldest message in the buffer.
+func (x *idIndex) remove(id EventID, pos int64) {
+	e, ok := x.ids[id]
+	if !ok || e.oldest != pos {
+		return
+	}
+
+	if e.count == 1 {
+		delete(x.ids, id)
+		return
+	}
+
+	e.oldest = x.next[pos]
+	e.count--
+	delete(x.next, pos)
+	x.ids[id] = e
+}
+
+// find returns the position of the oldest message with the given ID.
+func (x *idIndex) find(id EventID) (int64, bool) {
+	e, ok := x.ids[id]
+	return e.oldest, ok
+}
+
+// rebuild indexes the given messages again, the first of them having the given position.
+// It is used when messages are removed from the middle of the buffer, which changes the
+// positions of the newer messages – that is an O(n) operation anyway.
+func (x *idIndex) rebuild(messages []messageWithTopics, base int64) {
+	x.ids, x.next = nil, nil
+	for i := range messages {
+		x.add(messages[i].message.ID, base+int64(i))
+	}
+}
+
+func getBuffer(autoIDs, keepIDs bool, capacity int) buffer {
+	base := bufferBase{buf: make([]messageWithTopics, 0, capacity), minCap: capacity}
+	if autoIDs && keepIDs {
+		return &bufferKeepID{bufferNoID: bufferNoID{bufferBase: base}}
+	}
 	if autoIDs {
 		return &bufferAutoID{bufferBase: base}
 	}
@@ -0,0 +1,99 @@
+package sse
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrInvalidCursor is returned by the RangeFromCursor methods of the replay providers
+// when the cursor was not returned by the same provider.
+var ErrInvalidCursor = errors.New("go-sse.server: invalid replay cursor")
+
+// ErrCursorEvicted is returned by the RangeFromCursor methods of the replay providers
+// when some of the messages put after the cursor was returned were removed from the buffer,
+// so they can't be ranged over anymore.
+var ErrCursorEvicted = errors.New("go-sse.server: messages after the replay cursor were evicted")
+
+// rangeFromCursor calls fn for the events which are at or after the given cursor and, if isValid
+// is not nil, are valid. The index given to isValid is the position of the event across all the
+// given slices. The events must be in chronological order and upcoming is the cursor of the next
+// event put.
+func rangeFromCursor(
+	cursor string,
+	upcoming int64,
+	isValid func(i int) bool,
+	fn func(message *Message, topics []string),
+	events ...[]messageWithTopics,
+) (string, error) {
+	next := strconv.FormatInt(upcoming, 10)
+
+	from := int64(-1)
+	if cursor != "" {
+		var err error
+		if from, err = strconv.ParseInt(cursor, 10, 64); err != nil || from < 0 || from > upcoming {
+			return "", ErrInvalidCursor
+		}
+	}
+
+	oldest := upcoming
+	for _, e := range events {
+		if len(e) > 0 {
+			oldest = e[0].cursor
+			break
+		}
+	}
+	if from != -1 && from < oldest {
+		// The oldest cursor is returned, so the caller can start over from the buffered messages.
+		return strconv.FormatInt(oldest, 10), ErrCursorEvicted
+	}
+
+	i := 0
+	for _, e := range events {
+		for j := range e {
+			if e[j].cursor >= from && (isValid == nil || isValid(i)) {
+				fn(e[j].message, e[j].topics)
+			}
+			i++
+		}
+	}
+
+	return next, nil
+}
+
+// RangeFromCursor calls fn for each buffered message put after the given cursor was returned, from
+// the oldest to the newest, along with the topics the message was published to, and returns the cursor
+// to resume from after these messages. If the cursor is empty, fn is called for all the buffered
+// messages. This is an alternative to replaying by event ID, for streams whose IDs aren't meaningful:
+// the cursors are opaque positions in the buffer, independent of the IDs of the messages, so they work
+// regardless of how IDs are set, even if they are not unique. Clients resume from the cursor they were
+// given last, which the server can send them, for example, in a custom field or header.
+//
+// The cursors are valid only for the provider which returned them – they are not kept across restarts.
+// If the cursor was not returned by this provider, ErrInvalidCursor is returned. If some of the messages
+// put after the cursor was returned were evicted from the buffer, the client can't receive all the
+// messages it missed: fn is not called and ErrCursorEvicted is returned, together with the cursor of the oldest buffered
+// message, so the client can reset its state and start over from there.
+//
+// Just like ForEach, it is not thread-safe and the messages must not be modified.
+func (f *FiniteReplayProvider) RangeFromCursor(cursor string, fn func(message *Message, topics []string)) (next string, err error) {
+	first, second := f.buf[0:f.tail], []messageWithTopics(nil)
+	if f.tail < f.head {
+		first, second = f.buf[f.tail:], f.buf[0:f.tail]
+	}
+
+	return rangeFromCursor(cursor, f.written, nil, fn, first, second)
+}
+
+// RangeFromCursor calls fn for each valid buffered message put after the given cursor was returned,
+// from the oldest to the newest, the same way FiniteReplayProvider.RangeFromCursor does.
+// Expired messages are skipped, even if they were not removed yet.
+func (v *ValidReplayProvider) RangeFromCursor(cursor string, fn func(message *Message, topics []string)) (next string, err error) {
+	if v.b == nil {
+		return rangeFromCursor(cursor, 0, nil, fn)
+	}
+
+	now := v.now()
+	isValid := func(i int) bool { return v.expiries[i].After(now) }
+
+	return rangeFromCursor(cursor, v.b.upcomingCursor(), isValid, fn, v.b.all())
+}
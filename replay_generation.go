@@ -0,0 +1,146 @@
+package sse
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NewGenerationalReplayProvider creates a generational replay provider which holds
+// at most count messages of the current generation. Count must be greater than zero.
+func NewGenerationalReplayProvider(count int) (*GenerationalReplayProvider, error) {
+	if count < 1 {
+		return nil, errors.New("count must be at least 1")
+	}
+
+	return &GenerationalReplayProvider{count: count}, nil
+}
+
+// GenerationalReplayProvider is a replay provider which groups messages into generations,
+// for example one for each deployed version of an application. Calling Bump starts
+// a new generation and discards all the messages of the previous ones at once, so they
+// can't be replayed anymore.
+//
+// The provider sets the IDs of the messages automatically, in the form
+//
+//	<generation>.<sequence>
+//
+// where both parts are numbers, so the generation of each client is known from its last
+// event ID. Messages which already have an ID get a new one. Clients of the current generation
+// are replayed the messages after their last event ID, as long as they are still buffered.
+// The oldest messages of the current generation are evicted once there are more than
+// the maximum count – generations are never evicted partially in any other way.
+//
+// Clients of previous generations aren't replayed anything, unless ResetOldGenerations is set.
+//
+// Put and Replay are not thread-safe, but Bump can be called concurrently with them.
+type GenerationalReplayProvider struct {
+	messages   []messageWithTopics
+	count      int
+	generation int64
+	// firstSeq is the sequence number of the first buffered message.
+	firstSeq int64
+	nextSeq  int64
+	mu       sync.Mutex
+
+	// If ResetOldGenerations is true, clients whose last event ID is from a previous
+	// generation are replayed all the buffered messages of the current generation,
+	// so they can start over with a clean state.
+	ResetOldGenerations bool
+}
+
+// Put sets the message's ID and adds it to the current generation. If there are more
+// messages than the maximum count, the oldest message is removed.
+func (g *GenerationalReplayProvider) Put(message *Message, topics []string) *Message {
+	if len(topics) == 0 {
+		panic(errors.New(
+			"go-sse: no topics provided for Message.\n" +
+				formatMessagePanicString(message)))
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	message = message.Clone()
+	message.ID = ID(strconv.FormatInt(g.generation, 10) + "." + strconv.FormatInt(g.nextSeq, 10))
+	g.nextSeq++
+
+	if len(g.messages) == g.count {
+		g.messages[0] = messageWithTopics{}
+		g.messages = g.messages[1:]
+		g.firstSeq++
+	}
+	g.messages = append(g.messages, messageWithTopics{message: message, topics: topics})
+
+	return message
+}
+
+// Bump starts a new generation. All the buffered messages are discarded.
+func (g *GenerationalReplayProvider) Bump() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.generation++
+	g.messages = nil
+	g.firstSeq, g.nextSeq = 0, 0
+}
+
+// Generation returns the current generation. The first generation is 0.
+func (g *GenerationalReplayProvider) Generation() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.generation
+}
+
+// Replay replays the messages of the current generation to the subscriber,
+// as described by the documentation of GenerationalReplayProvider.
+func (g *GenerationalReplayProvider) Replay(subscription Subscription) error {
+	g.mu.Lock()
+	messages := g.messagesAfter(subscription.LastEventID)
+	g.mu.Unlock()
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	if err := replay(subscription, nil, messages); err != nil {
+		return err
+	}
+
+	return subscription.Client.Flush()
+}
+
+// messagesAfter returns the buffered messages to replay to a client with the given last event ID.
+func (g *GenerationalReplayProvider) messagesAfter(id EventID) []messageWithTopics {
+	rawGen, rawSeq, ok := strings.Cut(id.String(), ".")
+	if !ok {
+		return nil
+	}
+
+	gen, err := strconv.ParseInt(rawGen, 10, 64)
+	if err != nil || gen > g.generation {
+		return nil
+	}
+	if gen < g.generation {
+		if g.ResetOldGenerations {
+			return g.messages
+		}
+		return nil
+	}
+
+	seq, err := strconv.ParseInt(rawSeq, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	index := seq - g.firstSeq + 1
+	if index < 0 || index > int64(len(g.messages)) {
+		return nil
+	}
+
+	return g.messages[index:]
+}
+
+var _ ReplayProvider = (*GenerationalReplayProvider)(nil)
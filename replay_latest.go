@@ -0,0 +1,91 @@
+package sse
+
+import (
+	"container/list"
+	"errors"
+)
+
+// NewLatestPerKeyReplayProvider creates a replay provider which keeps only the latest
+// message for each key. The key function must not be nil.
+func NewLatestPerKeyReplayProvider(key func(*Message) string) (*LatestPerKeyReplayProvider, error) {
+	if key == nil {
+		return nil, errors.New("key function must not be nil")
+	}
+
+	return &LatestPerKeyReplayProvider{
+		key:      key,
+		messages: list.New(),
+		elements: map[string]*list.Element{},
+	}, nil
+}
+
+// LatestPerKeyReplayProvider is a replay provider suited for state synchronization: for each key,
+// such as an entity's identifier, only the most recently put message is kept. Messages for which
+// the key function returns an empty string are not stored.
+//
+// Given that partial history is meaningless in this case, the provider ignores the
+// Last-Event-ID of the subscribers: every new subscriber receives the full snapshot,
+// that is, the latest message of each key published to the subscribed topics.
+// The messages are replayed in the order they were last put. Messages are not required
+// to have IDs.
+type LatestPerKeyReplayProvider struct {
+	key      func(*Message) string
+	messages *list.List
+	elements map[string]*list.Element
+}
+
+// Put replaces the message previously stored for the same key with the given one.
+func (l *LatestPerKeyReplayProvider) Put(message *Message, topics []string) *Message {
+	if len(topics) == 0 {
+		panic(errors.New(
+			"go-sse: no topics provided for Message.\n" +
+				formatMessagePanicString(message)))
+	}
+
+	key := l.key(message)
+	if key == "" {
+		return message
+	}
+
+	if e, ok := l.elements[key]; ok {
+		l.messages.Remove(e)
+	}
+
+	l.elements[key] = l.messages.PushBack(messageWithTopics{message: message, topics: topics})
+
+	return message
+}
+
+// Replay sends the current snapshot to the subscriber, regardless of its last event ID.
+func (l *LatestPerKeyReplayProvider) Replay(subscription Subscription) error {
+	if l.messages.Len() == 0 {
+		return nil
+	}
+
+	skip := 0
+	if subscription.ReplayLimit > 0 {
+		for e := l.messages.Front(); e != nil; e = e.Next() {
+			if subscription.receives(e.Value.(messageWithTopics).topics) {
+				skip++
+			}
+		}
+
+		skip -= subscription.ReplayLimit
+	}
+
+	for e := l.messages.Front(); e != nil; e = e.Next() {
+		m := e.Value.(messageWithTopics)
+		if !subscription.receives(m.topics) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+		} else if err := subscription.Client.Send(m.message); err != nil {
+			return err
+		}
+	}
+
+	return subscription.Client.Flush()
+}
+
+var _ ReplayProvider = (*LatestPerKeyReplayProvider)(nil)
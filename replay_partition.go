@@ -0,0 +1,116 @@
+package sse
+
+import (
+	"errors"
+	"sync"
+)
+
+// NewPartitionedReplayProvider creates a replay provider which keeps the messages of each partition
+// in a separate replay provider. The partition function returns the partition of each message put and
+// the new function creates the replay provider of a partition, when its first message is put. Neither
+// of them must be nil.
+func NewPartitionedReplayProvider(
+	partition func(message *Message, topics []string) string,
+	newProvider func(partition string) ReplayProvider,
+) (*PartitionedReplayProvider, error) {
+	if partition == nil {
+		return nil, errors.New("partition function must not be nil")
+	}
+	if newProvider == nil {
+		return nil, errors.New("new provider function must not be nil")
+	}
+
+	return &PartitionedReplayProvider{
+		partition:   partition,
+		newProvider: newProvider,
+		providers:   map[string]ReplayProvider{},
+	}, nil
+}
+
+// PartitionedReplayProvider is a replay provider which partitions the messages by a key, such as
+// the tenant they belong to, so subscribers are replayed only the messages of their partition –
+// see Subscription.Partition. Unlike TopicReplayProvider, messages of different partitions can
+// be published to the same topic, and their IDs may collide without one partition's clients
+// being replayed another's messages.
+//
+// Each partition has its own replay provider, whose retention policy applies only to that partition:
+// for example, with FiniteReplayProvider, each partition keeps the given number of messages, so
+// the memory used grows with the number of partitions. A partition's provider is created when its
+// first message is put and it is kept until RemovePartition is called, even if it has no messages
+// left – remove the partitions which are not used anymore, such as those of deleted tenants.
+// Messages whose partition is the empty string belong to the partition of subscriptions without one.
+//
+// RemovePartition is safe to call concurrently with the other methods, for example while Joe uses
+// the provider. Otherwise, PartitionedReplayProvider is as thread-safe as the replay providers it uses.
+type PartitionedReplayProvider struct {
+	partition   func(message *Message, topics []string) string
+	newProvider func(partition string) ReplayProvider
+	providers   map[string]ReplayProvider
+	mu          sync.Mutex
+}
+
+// Put puts the message into the replay provider of its partition. If the partition has no provider
+// yet, it is created; if the new function returns nil, the messages of the partition are not stored.
+func (p *PartitionedReplayProvider) Put(message *Message, topics []string) *Message {
+	if len(topics) == 0 {
+		panic(errors.New(
+			"go-sse: no topics provided for Message.\n" +
+				formatMessagePanicString(message)))
+	}
+
+	key := p.partition(message, topics)
+
+	p.mu.Lock()
+	provider, ok := p.providers[key]
+	if !ok {
+		provider = p.newProvider(key)
+		p.providers[key] = provider
+	}
+	p.mu.Unlock()
+
+	if provider == nil {
+		return message
+	}
+
+	return provider.Put(message, topics)
+}
+
+// Replay replays to the subscriber the messages of its partition.
+func (p *PartitionedReplayProvider) Replay(subscription Subscription) error {
+	p.mu.Lock()
+	provider := p.providers[subscription.Partition]
+	p.mu.Unlock()
+
+	if provider != nil {
+		return provider.Replay(subscription)
+	}
+
+	return nil
+}
+
+// GC calls the GC method of the partitions' providers which have one, such as ValidReplayProvider.
+func (p *PartitionedReplayProvider) GC() {
+	p.mu.Lock()
+	providers := make([]ReplayProvider, 0, len(p.providers))
+	for _, provider := range p.providers {
+		providers = append(providers, provider)
+	}
+	p.mu.Unlock()
+
+	for _, provider := range providers {
+		if gc, ok := provider.(interface{ GC() }); ok {
+			gc.GC()
+		}
+	}
+}
+
+// RemovePartition removes the replay provider of the given partition, together with its messages.
+// If more messages of the partition are put, a new provider is created for it.
+func (p *PartitionedReplayProvider) RemovePartition(partition string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.providers, partition)
+}
+
+var _ ReplayProvider = (*PartitionedReplayProvider)(nil)
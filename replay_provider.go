@@ -2,6 +2,7 @@ package sse
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 	"time"
 )
@@ -13,7 +14,9 @@ import (
 // valid. It must be greater than zero.
 //
 // AutoIDs configures FiniteReplayProvider to automatically set the IDs of
-// events.
+// events. The IDs are increasing numbers, so clients of streams which have no
+// meaningful IDs of their own can still resume using the Last-Event-ID they receive.
+// To keep the IDs set by the application, use RangeFromCursor instead.
 func NewFiniteReplayProvider(
 	count int, autoIDs bool,
 ) (*FiniteReplayProvider, error) {
@@ -29,26 +32,135 @@ func NewFiniteReplayProvider(
 }
 
 // FiniteReplayProvider is a replay provider that replays at maximum a certain number of events.
-// The events must have an ID unless the AutoIDs flag is toggled.
+// The events must have an ID unless the AutoIDs flag is toggled. The events of all topics are kept
+// in a single buffer, so subscribers to multiple topics are replayed them in the order they were published.
 type FiniteReplayProvider struct {
 	buf       []messageWithTopics
 	cap       int
 	head      int
 	tail      int
-	autoIDs   bool
 	currentID int64
+	autoIDs   bool
+	index     idIndex
+	// written is the number of messages put, which is the index position of the next message.
+	written int64
+
+	// If KeepIDs is set and the provider sets IDs automatically, the messages which
+	// already have an ID keep it – only the messages without one get an automatic ID.
+	// This way messages with explicit IDs and messages with automatic IDs can be mixed.
+	// If an explicit ID is a number greater than the automatic IDs, the automatic IDs continue
+	// from it, so they keep increasing. Explicit IDs must be unique, or replays resume from the
+	// wrong message. Either way, messages are replayed in the order they were put.
+	KeepIDs bool
+	// If ReplayFromOldestOnMiss is set, clients whose last event ID is not found, for example
+	// because the message was evicted while they were disconnected, are replayed all
+	// the buffered messages instead of none. This makes recovering after long disconnects
+	// more forgiving, but clients may receive again messages they have already seen –
+	// for example, if their last event ID comes from another server – so they must handle
+	// duplicates. Clients without a last event ID are still not replayed anything.
+	ReplayFromOldestOnMiss bool
+	// DuplicateIDs configures what happens when a message is put with the ID of a message which
+	// is still buffered. By default both messages are kept – see DuplicateIDsAllow.
+	DuplicateIDs DuplicateIDPolicy
+}
+
+// ErrIDNotFound is returned by the CheckID methods of the replay providers
+// for IDs of messages which the provider never had.
+var ErrIDNotFound = errors.New("go-sse: event ID not found")
+
+// ErrIDEvicted is the error EvictedError matches using errors.Is.
+var ErrIDEvicted = errors.New("go-sse: event ID was evicted")
+
+// EvictedError is returned by the CheckID methods of the replay providers for IDs
+// of messages which were removed from the buffer, so clients with that last event ID
+// miss messages. It matches ErrIDEvicted.
+type EvictedError struct {
+	// The ID which was checked.
+	ID EventID
+	// The ID of the oldest message which is replayed. It is unset if there are no messages to replay.
+	Oldest EventID
+}
+
+func (e *EvictedError) Error() string {
+	if !e.Oldest.IsSet() {
+		return fmt.Sprintf("go-sse: event ID %q was evicted, no messages are buffered", e.ID)
+	}
+	return fmt.Sprintf("go-sse: event ID %q was evicted, the oldest buffered ID is %q", e.ID, e.Oldest)
+}
+
+// Is reports whether the target is ErrIDEvicted.
+func (e *EvictedError) Is(target error) bool {
+	return target == ErrIDEvicted //nolint:errorlint // This is the error itself.
+}
+
+// DuplicateIDPolicy configures how replay providers handle messages put with the ID
+// of a buffered message. Event IDs are expected to be unique, so duplicates usually
+// mean there's a bug in the code which sets them. Messages with automatically set IDs
+// never have duplicate IDs.
+type DuplicateIDPolicy int
+
+const (
+	// DuplicateIDsAllow keeps all the messages. Clients whose last event ID is duplicated
+	// are replayed the messages put after the oldest message with that ID, including
+	// the newer messages with the same ID.
+	DuplicateIDsAllow DuplicateIDPolicy = iota
+	// DuplicateIDsReplace removes the buffered message which has the same ID, so clients
+	// whose last event ID is duplicated are replayed the messages put after the newest
+	// message with that ID.
+	DuplicateIDsReplace
+	// DuplicateIDsReject makes Put panic, just like it does for other invalid messages,
+	// so the bug is caught early. Use it in development and tests.
+	DuplicateIDsReject
+)
+
+// checkDuplicate applies the policy to a message about to be put. If a buffered message
+// has the same ID, remove is called with its index, unless the policy is to allow duplicates.
+func (d DuplicateIDPolicy) checkDuplicate(message *Message, index func(EventID) int, remove func(int)) {
+	if d == DuplicateIDsAllow {
+		return
+	}
+
+	i := index(message.ID)
+	if i == -1 {
+		return
+	}
+
+	if d == DuplicateIDsReject {
+		panic(errors.New("go-sse: a Message with the ID of a buffered message was given to a provider that rejects duplicate IDs.\n" + formatMessagePanicString(message)))
+	}
+
+	remove(i)
 }
 
 // Put puts a message into the provider's buffer. If there are more messages than the maximum
 // number, the oldest message is removed.
 func (f *FiniteReplayProvider) Put(message *Message, topics []string) *Message {
+	return f.put(message, topics, f.KeepIDs)
+}
+
+// Seed puts into the provider's buffer a message which was published before, for example
+// one loaded from a database after a restart, so it can be replayed before any new messages
+// are published. Seeded messages must have an ID, which is kept even if the provider sets IDs
+// automatically; if the ID is a number, the automatic IDs continue from it, the same way they do
+// when KeepIDs is set. Seed the messages from the oldest to the newest, before calling Put.
+func (f *FiniteReplayProvider) Seed(message *Message, topics []string) {
+	if !message.ID.IsSet() {
+		panic(errors.New("go-sse: a Message without an ID was given to Seed.\n" + formatMessagePanicString(message)))
+	}
+
+	f.put(message, topics, true)
+}
+
+func (f *FiniteReplayProvider) put(message *Message, topics []string, keepID bool) *Message {
 	if len(topics) == 0 {
 		panic(errors.New(
 			"go-sse: no topics provided for Message.\n" +
 				formatMessagePanicString(message)))
 	}
 
-	if f.autoIDs {
+	if f.autoIDs && keepID && message.ID.IsSet() {
+		f.currentID = nextAutoID(f.currentID+1, message.ID) - 1
+	} else if f.autoIDs {
 		f.currentID++
 
 		message.ID = ID(strconv.FormatInt(f.currentID, 10))
@@ -58,7 +170,18 @@ func (f *FiniteReplayProvider) Put(message *Message, topics []string) *Message {
 		panic(errors.New(panicString))
 	}
 
-	f.buf[f.tail] = messageWithTopics{message: message, topics: topics}
+	if !f.autoIDs || keepID {
+		f.DuplicateIDs.checkDuplicate(message, f.indexOf, f.removeAt)
+	}
+
+	if f.len() == f.cap {
+		// The oldest message is overwritten.
+		f.index.remove(f.buf[f.tail].message.ID, f.written-int64(f.cap))
+	}
+	f.index.add(message.ID, f.written)
+	f.written++
+
+	f.buf[f.tail] = messageWithTopics{message: message, topics: topics, cursor: f.written - 1}
 
 	f.tail++
 	if f.tail >= f.cap {
@@ -83,45 +206,176 @@ func (f *FiniteReplayProvider) Replay(subscription Subscription) error {
 		return nil
 	}
 
-	// Replay head to end and start to tail when head is after tail.
+	// The buffer, in chronological order: head to end and start to tail when head is after tail.
+	first, second := f.buf[0:f.tail], []messageWithTopics(nil)
 	if f.tail < f.head {
-		foundFirst, err := replay(subscription, f.buf[f.tail:], false)
-		if err != nil {
-			return err
-		}
+		first, second = f.buf[f.tail:], f.buf[0:f.tail]
+	}
 
-		_, err = replay(subscription, f.buf[0:f.tail], foundFirst)
-		if err != nil {
-			return err
-		}
-	} else {
-		_, err := replay(subscription, f.buf[0:f.tail], false)
-		if err != nil {
-			return err
-		}
+	if i := f.indexOf(subscription.LastEventID); i != -1 && i < len(first) {
+		first = first[i+1:]
+	} else if i != -1 {
+		first, second = second[i-len(first)+1:], nil
+	} else if !f.ReplayFromOldestOnMiss || !subscription.LastEventID.IsSet() {
+		return subscription.Client.Flush()
+	}
+
+	if err := replay(subscription, nil, first, second); err != nil {
+		return err
 	}
 
 	return subscription.Client.Flush()
 }
 
-func replay(
-	sub Subscription, events []messageWithTopics, foundFirstEvent bool,
-) (hasFoundFirstEvent bool, err error) {
-	for _, e := range events {
-		if !foundFirstEvent && e.message.ID == sub.LastEventID {
-			foundFirstEvent = true
+// CheckID reports whether a client with the given last event ID is replayed the messages
+// it missed. It returns nil if the message with the ID is buffered, an *EvictedError if the
+// message was removed from the buffer and ErrIDNotFound if the provider never had such a message.
+// This way servers can tell clients which were disconnected for too long, which must reset
+// their state, apart from clients with invalid IDs.
+//
+// Evicted messages are detected only if the provider sets all the IDs automatically, as their
+// IDs are sequential. Otherwise the IDs of removed messages are not kept, so all the IDs which
+// are not buffered are reported as not found.
+func (f *FiniteReplayProvider) CheckID(id EventID) error {
+	if f.indexOf(id) != -1 {
+		return nil
+	}
+
+	if f.autoIDs && !f.KeepIDs && wasIssued(id, 1, f.currentID+1) {
+		oldest, _, _ := f.Bounds()
+		return &EvictedError{ID: id, Oldest: oldest}
+	}
+
+	return ErrIDNotFound
+}
+
+// Bounds returns the IDs of the oldest and newest messages in the buffer.
+// The boolean is false if there are no buffered messages.
+//
+// A client whose last event ID is not between these bounds will not receive any replayed
+// messages – servers can use this to detect such clients and, for example, send them
+// an event which instructs them to reset their state.
+func (f *FiniteReplayProvider) Bounds() (oldest, newest EventID, ok bool) {
+	if f.head == f.tail {
+		return EventID{}, EventID{}, false
+	}
+
+	oldestIndex := 0
+	if f.tail < f.head {
+		oldestIndex = f.tail
+	}
+
+	newestIndex := f.tail - 1
+	if newestIndex < 0 {
+		newestIndex = f.cap - 1
+	}
+
+	return f.buf[oldestIndex].message.ID, f.buf[newestIndex].message.ID, true
+}
+
+// ForEach calls fn for each buffered message, from the oldest to the newest, along with the topics
+// the message was published to. It is meant for introspection, such as debug endpoints: the messages
+// must not be modified and the buffer is not changed.
+//
+// Just like the other methods, ForEach is not thread-safe – it must not be called while the provider
+// is used by a server provider, such as Joe.
+func (f *FiniteReplayProvider) ForEach(fn func(message *Message, topics []string)) {
+	if f.head == f.tail {
+		return
+	}
+
+	first, second := f.buf[0:f.tail], []messageWithTopics(nil)
+	if f.tail < f.head {
+		first, second = f.buf[f.tail:], f.buf[0:f.tail]
+	}
+
+	for _, events := range [...][]messageWithTopics{first, second} {
+		for _, e := range events {
+			fn(e.message, e.topics)
+		}
+	}
+}
+
+// chronological returns the buffered messages, from the oldest to the newest.
+func (f *FiniteReplayProvider) chronological() []messageWithTopics {
+	if f.tail < f.head {
+		return append(append([]messageWithTopics(nil), f.buf[f.tail:]...), f.buf[:f.tail]...)
+	}
+
+	return append([]messageWithTopics(nil), f.buf[:f.tail]...)
+}
+
+// len returns the number of buffered messages.
+func (f *FiniteReplayProvider) len() int {
+	if f.tail < f.head {
+		return f.cap
+	}
+
+	return f.tail
+}
+
+// indexOf returns the chronological index of the oldest message with the given ID, or -1.
+func (f *FiniteReplayProvider) indexOf(id EventID) int {
+	if pos, ok := f.index.find(id); ok {
+		return int(pos - (f.written - int64(f.len())))
+	}
+
+	return -1
+}
+
+// removeAt removes the message with the given chronological index.
+// The buffer is rearranged so it starts with the oldest message.
+func (f *FiniteReplayProvider) removeAt(i int) {
+	messages := f.chronological()
+	messages = append(messages[:i], messages[i+1:]...)
+
+	for i := range f.buf {
+		f.buf[i] = messageWithTopics{}
+	}
+	copy(f.buf, messages)
+	f.head, f.tail = 0, len(messages)
+	f.index.rebuild(messages, f.written-int64(len(messages)))
+}
 
-			continue
+// replay sends to the subscriber the given events which it receives and, if isValid
+// is not nil, are valid. The index given to isValid is the position of the event
+// across all the given slices. If the subscription has a replay limit, only the
+// newest events are sent, in chronological order.
+func replay(sub Subscription, isValid func(i int) bool, events ...[]messageWithTopics) error {
+	shouldReplay := func(i int, e *messageWithTopics) bool {
+		return (isValid == nil || isValid(i)) && sub.receives(e.topics)
+	}
+
+	skip := 0
+	if sub.ReplayLimit > 0 {
+		i := 0
+		for _, es := range events {
+			for j := range es {
+				if shouldReplay(i, &es[j]) {
+					skip++
+				}
+				i++
+			}
 		}
 
-		if foundFirstEvent && topicsIntersect(sub.Topics, e.topics) {
-			if err := sub.Client.Send(e.message); err != nil {
-				return false, err
+		skip -= sub.ReplayLimit
+	}
+
+	i := 0
+	for _, es := range events {
+		for j := range es {
+			if shouldReplay(i, &es[j]) {
+				if skip > 0 {
+					skip--
+				} else if err := sub.Client.Send(es[j].message); err != nil {
+					return err
+				}
 			}
+			i++
 		}
 	}
 
-	return foundFirstEvent, nil
+	return nil
 }
 
 // ValidReplayProvider is a ReplayProvider that replays all the buffered non-expired events.
@@ -129,7 +383,8 @@ func replay(
 // expire.
 // The provider removes any expired events when a new event is put and after at least
 // a GCInterval period passed.
-// The events must have an ID unless the AutoIDs flag is toggled.
+// The events must have an ID unless the AutoIDs flag is toggled. Just like with FiniteReplayProvider,
+// events are replayed in the order they were published, regardless of their topics.
 type ValidReplayProvider struct {
 	// The function used to retrieve the current time. Defaults to time.Now.
 	// Useful when testing.
@@ -148,15 +403,60 @@ type ValidReplayProvider struct {
 	// it to -1 – this disables automatic cleanup, enabling you to do it manually
 	// using the GC method.
 	GCInterval time.Duration
+	// The maximum number of expired events removed by a single cleanup. If a large number
+	// of events expire at once, cleaning them all up may block the server provider for too long;
+	// with this limit the work is spread over multiple cleanups, but the buffer may hold
+	// expired events for longer. Expired events are never replayed, regardless of this limit.
+	// If it is <=0, all the expired events are removed at once.
+	MaxEvictPerGC int
 	// AutoIDs configures ValidReplayProvider to automatically set the IDs of events.
+	// See NewFiniteReplayProvider for how they can be used.
 	AutoIDs bool
+	// KeepIDs configures ValidReplayProvider to set IDs automatically only for events
+	// which don't have one. See FiniteReplayProvider.KeepIDs for details.
+	KeepIDs bool
+	// ReplayFromOldestOnMiss configures ValidReplayProvider to replay all the valid messages
+	// to clients whose last event ID is not found. See FiniteReplayProvider.ReplayFromOldestOnMiss
+	// for details.
+	ReplayFromOldestOnMiss bool
+	// DuplicateIDs configures what happens when a message is put with the ID of a message
+	// which is still buffered, even if it expired. See FiniteReplayProvider.DuplicateIDs for details.
+	DuplicateIDs DuplicateIDPolicy
+	// Capacity is the number of messages for which space is allocated when the first message
+	// is put. Set it to about the number of messages valid at a time – for example, the rate
+	// of the messages multiplied by the TTL – so the buffer doesn't grow in many steps,
+	// which reallocates and copies it each time. It is only a hint: the buffer grows
+	// beyond it as needed.
+	Capacity int
 }
 
 // Put puts the message into the provider's buffer.
 func (v *ValidReplayProvider) Put(message *Message, topics []string) *Message {
+	return v.put(message, topics, v.KeepIDs)
+}
+
+// Seed puts into the provider's buffer a message which was published before, for example
+// one loaded from a database after a restart, so it can be replayed before any new messages
+// are published. Seeded messages must have an ID, which is kept even if the provider sets IDs
+// automatically; if the ID is a number, the automatic IDs continue from it. Seed the messages
+// from the oldest to the newest, before calling Put – if AutoIDs is set, the provider then behaves
+// as if KeepIDs were set too. Seeded messages expire after the TTL, starting from when they are seeded.
+func (v *ValidReplayProvider) Seed(message *Message, topics []string) {
+	if !message.ID.IsSet() {
+		panic(errors.New("go-sse: a Message without an ID was given to Seed.\n" + formatMessagePanicString(message)))
+	}
+	if _, ok := v.b.(*bufferAutoID); ok {
+		panic(errors.New("go-sse: Seed called after Put"))
+	}
+
+	v.put(message, topics, true)
+}
+
+func (v *ValidReplayProvider) put(message *Message, topics []string, keepIDs bool) *Message {
 	now := v.now()
 	if v.b == nil {
-		v.b = getBuffer(v.AutoIDs, 0)
+		v.b = getBuffer(v.AutoIDs, keepIDs, v.Capacity)
+		v.expiries = make([]time.Time, 0, v.Capacity)
 		v.lastGC = now
 	}
 
@@ -165,10 +465,19 @@ func (v *ValidReplayProvider) Put(message *Message, topics []string) *Message {
 		v.lastGC = now
 	}
 
-	v.expiries = append(v.expiries, v.now().Add(v.TTL))
+	if _, autoIDs := v.b.(*bufferAutoID); !autoIDs && message.ID.IsSet() {
+		v.DuplicateIDs.checkDuplicate(message, v.b.indexOf, v.removeAt)
+	}
+
+	v.expiries = appendGrow(v.expiries, v.now().Add(v.TTL), v.Capacity)
 	return v.b.queue(message, topics)
 }
 
+func (v *ValidReplayProvider) removeAt(i int) {
+	v.b.removeAt(i)
+	v.expiries = append(v.expiries[:i], v.expiries[i+1:]...)
+}
+
 func (v *ValidReplayProvider) shouldGC(now time.Time) bool {
 	if v.GCInterval < 0 {
 		return false
@@ -182,7 +491,8 @@ func (v *ValidReplayProvider) shouldGC(now time.Time) bool {
 	return now.Sub(v.lastGC) >= gcInterval
 }
 
-// GC removes all the expired messages from the provider's buffer.
+// GC removes the expired messages from the provider's buffer.
+// At most MaxEvictPerGC messages are removed, if the limit is set.
 func (v *ValidReplayProvider) GC() {
 	if v.b != nil {
 		v.doGC(v.now())
@@ -190,7 +500,7 @@ func (v *ValidReplayProvider) GC() {
 }
 
 func (v *ValidReplayProvider) doGC(now time.Time) {
-	for {
+	for evicted := 0; v.MaxEvictPerGC <= 0 || evicted < v.MaxEvictPerGC; evicted++ {
 		e := v.b.front()
 		if e == nil || v.expiries[0].After(now) {
 			break
@@ -208,6 +518,9 @@ func (v *ValidReplayProvider) Replay(subscription Subscription) error {
 	}
 
 	events := v.b.slice(subscription.LastEventID)
+	if events == nil && v.ReplayFromOldestOnMiss && subscription.LastEventID.IsSet() {
+		events = v.b.all()
+	}
 	if len(events) == 0 {
 		return nil
 	}
@@ -215,17 +528,80 @@ func (v *ValidReplayProvider) Replay(subscription Subscription) error {
 	now := v.now()
 	expiriesOffset := v.b.len() - len(events)
 
-	for i, e := range events {
-		if v.expiries[i+expiriesOffset].After(now) && topicsIntersect(subscription.Topics, e.topics) {
-			if err := subscription.Client.Send(e.message); err != nil {
-				return err
-			}
-		}
+	isValid := func(i int) bool { return v.expiries[i+expiriesOffset].After(now) }
+	if err := replay(subscription, isValid, events); err != nil {
+		return err
 	}
 
 	return subscription.Client.Flush()
 }
 
+// CheckID reports whether a client with the given last event ID is replayed the messages
+// it missed, the same way FiniteReplayProvider.CheckID does. Expired messages are reported
+// as evicted, even if they were not removed yet – they are not replayed either way.
+func (v *ValidReplayProvider) CheckID(id EventID) error {
+	if v.b == nil {
+		return ErrIDNotFound
+	}
+
+	if i := v.b.indexOf(id); i != -1 {
+		if v.expiries[i].After(v.now()) {
+			return nil
+		}
+	} else if b, ok := v.b.(*bufferAutoID); !ok || !wasIssued(id, 0, b.upcomingID) {
+		return ErrIDNotFound
+	}
+
+	oldest, _, _ := v.Bounds()
+	return &EvictedError{ID: id, Oldest: oldest}
+}
+
+// Bounds returns the IDs of the oldest and newest messages which are valid for replay.
+// The boolean is false if there are no such messages.
+//
+// A client whose last event ID is not between these bounds will not receive any replayed
+// messages – servers can use this to detect such clients and, for example, send them
+// an event which instructs them to reset their state.
+func (v *ValidReplayProvider) Bounds() (oldest, newest EventID, ok bool) {
+	if v.b == nil || v.b.len() == 0 {
+		return EventID{}, EventID{}, false
+	}
+
+	now := v.now()
+	last := len(v.expiries) - 1
+	if !v.expiries[last].After(now) {
+		return EventID{}, EventID{}, false
+	}
+
+	first := 0
+	for !v.expiries[first].After(now) {
+		first++
+	}
+
+	return v.b.at(first).message.ID, v.b.at(last).message.ID, true
+}
+
+// ForEach calls fn for each message which is valid for replay, from the oldest to the newest,
+// along with the topics the message was published to. It is meant for introspection, such as
+// debug endpoints: the messages must not be modified and the buffer is not changed – expired
+// messages are skipped, but not removed.
+//
+// Just like the other methods, ForEach is not thread-safe – it must not be called while the provider
+// is used by a server provider, such as Joe.
+func (v *ValidReplayProvider) ForEach(fn func(message *Message, topics []string)) {
+	if v.b == nil {
+		return
+	}
+
+	now := v.now()
+	for i, l := 0, v.b.len(); i < l; i++ {
+		if v.expiries[i].After(now) {
+			e := v.b.at(i)
+			fn(e.message, e.topics)
+		}
+	}
+}
+
 func (v *ValidReplayProvider) now() time.Time {
 	if v.Now == nil {
 		return time.Now()
@@ -234,6 +610,12 @@ func (v *ValidReplayProvider) now() time.Time {
 	return v.Now()
 }
 
+// wasIssued reports whether the ID is an automatic ID in the range [first, upcoming).
+func wasIssued(id EventID, first, upcoming int64) bool {
+	n, err := strconv.ParseInt(id.String(), autoIDBase, 64)
+	return err == nil && n >= first && n < upcoming
+}
+
 // topicsIntersect returns true if the given topic slices have at least one topic in common.
 func topicsIntersect(a, b []string) bool {
 	for _, at := range a {
@@ -0,0 +1,82 @@
+package sse
+
+import "errors"
+
+// NewSummarizingReplayProvider creates a replay provider which replays the messages of the given
+// provider after passing them through the summarize function. Neither of them must be nil.
+func NewSummarizingReplayProvider(provider ReplayProvider, summarize func(missed []*Message) []*Message) (*SummarizingReplayProvider, error) {
+	if provider == nil {
+		return nil, errors.New("replay provider must not be nil")
+	}
+	if summarize == nil {
+		return nil, errors.New("summarize function must not be nil")
+	}
+
+	return &SummarizingReplayProvider{provider: provider, summarize: summarize}, nil
+}
+
+// SummarizingReplayProvider is a replay provider which replays a smaller set of messages computed
+// from the ones a client missed, instead of all of them. For example, for a chat-like feed,
+// a client which missed hundreds of messages can be sent a single message telling how many
+// new messages there are, followed by the last few of them.
+//
+// The messages put are stored by the wrapped provider. When replaying, the summarize function
+// is called with all the messages the wrapped provider would replay to the subscriber, in order,
+// and the messages it returns are replayed instead, in the order they are returned. It is not
+// called if there are no messages to replay, and nothing is replayed if it returns no messages.
+//
+// The missed messages are shared with the wrapped provider, so the summarize function must not
+// modify them – create new messages for summaries instead. To keep the IDs continuous, the last
+// returned message which has an ID should have the ID of the last missed message: the client
+// resumes from the ID of the last message it received, so with an older ID it is replayed again
+// the messages it was summarized, and with an ID the wrapped provider doesn't know it may not
+// be replayed anything. Messages without an ID don't change the client's last event ID.
+//
+// SummarizingReplayProvider is as thread-safe as the wrapped provider, given that the summarize
+// function can be called concurrently.
+type SummarizingReplayProvider struct {
+	provider  ReplayProvider
+	summarize func(missed []*Message) []*Message
+}
+
+// Put puts the message into the wrapped provider.
+func (s *SummarizingReplayProvider) Put(message *Message, topics []string) *Message {
+	return s.provider.Put(message, topics)
+}
+
+// Replay replays to the subscriber the summary of the messages it missed.
+func (s *SummarizingReplayProvider) Replay(subscription Subscription) error {
+	c := &collectingClient{}
+
+	sub := subscription
+	sub.Client = c
+	if err := s.provider.Replay(sub); err != nil {
+		return err
+	}
+
+	if len(c.messages) == 0 {
+		return nil
+	}
+
+	summary := s.summarize(c.messages)
+	if len(summary) == 0 {
+		return nil
+	}
+
+	for _, m := range summary {
+		if err := subscription.Client.Send(m); err != nil {
+			return err
+		}
+	}
+
+	return subscription.Client.Flush()
+}
+
+// GC calls the GC method of the wrapped provider, if it has one, such as ValidReplayProvider.
+func (s *SummarizingReplayProvider) GC() {
+	if gc, ok := s.provider.(interface{ GC() }); ok {
+		gc.GC()
+	}
+}
+
+var _ ReplayProvider = (*SummarizingReplayProvider)(nil)
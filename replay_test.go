@@ -1,8 +1,10 @@
 package sse_test
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -107,6 +109,35 @@ func TestValidReplayProvider(t *testing.T) {
 	testReplayError(t, &sse.ValidReplayProvider{Now: tm.Now}, tm)
 }
 
+func TestValidReplayProvider_MaxEvictPerGC(t *testing.T) {
+	t.Parallel()
+
+	tm := &tests.Time{}
+	p := &sse.ValidReplayProvider{
+		TTL:           time.Millisecond * 5,
+		AutoIDs:       true,
+		Now:           tm.Now,
+		GCInterval:    -1,
+		MaxEvictPerGC: 1,
+	}
+
+	now := time.Now()
+	tm.Set(now)
+
+	p.Put(msg(t, "a", ""), []string{sse.DefaultTopic})
+	p.Put(msg(t, "b", ""), []string{sse.DefaultTopic})
+	tm.Add(p.TTL)
+	p.Put(msg(t, "c", ""), []string{sse.DefaultTopic})
+	p.Put(msg(t, "d", ""), []string{sse.DefaultTopic})
+
+	p.GC()
+	tests.Equal(t, len(replay(t, p, sse.ID("0"))), 2, "only one event should be removed")
+
+	p.GC()
+	tests.Equal(t, len(replay(t, p, sse.ID("0"))), 0, "expired events should be removed on subsequent cleanup")
+	tests.Equal(t, len(replay(t, p, sse.ID("1"))), 2, "valid events should still be replayed")
+}
+
 func TestFiniteReplayProvider(t *testing.T) {
 	t.Parallel()
 
@@ -161,6 +192,57 @@ The message is the following:
 	testReplayError(t, tr, nil)
 }
 
+func TestReplayProvider_CheckID(t *testing.T) {
+	t.Parallel()
+
+	f, err := sse.NewFiniteReplayProvider(2, true)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	tm := &tests.Time{}
+	tm.Set(time.Now())
+
+	v := &sse.ValidReplayProvider{TTL: time.Minute, AutoIDs: true, Now: tm.Now}
+
+	tests.ErrorIs(t, v.CheckID(sse.ID("0")), sse.ErrIDNotFound, "provider without messages should not find any ID")
+
+	for i := 0; i < 3; i++ {
+		f.Put(msg(t, "hello", ""), []string{sse.DefaultTopic})
+		v.Put(msg(t, "hello", ""), []string{sse.DefaultTopic})
+	}
+	v.GC()
+
+	tests.Equal(t, f.CheckID(sse.ID("3")), nil, "buffered ID should be found")
+	tests.ErrorIs(t, f.CheckID(sse.ID("4")), sse.ErrIDNotFound, "ID never issued should not be found")
+	tests.ErrorIs(t, f.CheckID(sse.ID("nope")), sse.ErrIDNotFound, "invalid ID should not be found")
+
+	err = f.CheckID(sse.ID("1"))
+	tests.ErrorIs(t, err, sse.ErrIDEvicted, "removed ID should be evicted")
+
+	var evicted *sse.EvictedError
+	tests.Expect(t, errors.As(err, &evicted), "should return an EvictedError")
+	tests.Equal(t, evicted.ID, sse.ID("1"), "invalid evicted ID")
+	tests.Equal(t, evicted.Oldest, sse.ID("2"), "invalid oldest ID")
+	tests.Equal(t, err.Error(), `go-sse: event ID "1" was evicted, the oldest buffered ID is "2"`, "invalid error message")
+
+	tests.Equal(t, v.CheckID(sse.ID("2")), nil, "valid ID should be found")
+	tests.ErrorIs(t, v.CheckID(sse.ID("3")), sse.ErrIDNotFound, "ID never issued should not be found")
+
+	tm.Add(time.Hour)
+
+	err = v.CheckID(sse.ID("1"))
+	tests.Expect(t, errors.As(err, &evicted), "expired ID should be evicted")
+	tests.Equal(t, evicted.Oldest, sse.EventID{}, "no message should be valid for replay")
+	tests.Equal(t, err.Error(), `go-sse: event ID "1" was evicted, no messages are buffered`, "invalid error message")
+
+	k, err := sse.NewFiniteReplayProvider(2, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	k.Put(msg(t, "hello", "a"), []string{sse.DefaultTopic})
+	k.Put(msg(t, "hello", "b"), []string{sse.DefaultTopic})
+	k.Put(msg(t, "hello", "c"), []string{sse.DefaultTopic})
+	tests.ErrorIs(t, k.CheckID(sse.ID("a")), sse.ErrIDNotFound, "removed explicit IDs cannot be told apart")
+}
+
 func TestFiniteReplayProvider_allocations(t *testing.T) {
 	p, err := sse.NewFiniteReplayProvider(3, false)
 	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
@@ -219,3 +301,831 @@ func TestFiniteReplayProvider_allocations(t *testing.T) {
 
 	tests.Equal(t, replayCount, 2, "replay from third last should yield 2 messages")
 }
+
+func TestReplayProvider_Bounds(t *testing.T) {
+	t.Parallel()
+
+	type boundsProvider interface {
+		sse.ReplayProvider
+		Bounds() (sse.EventID, sse.EventID, bool)
+	}
+
+	expectBounds := func(tb testing.TB, p boundsProvider, oldest, newest string) {
+		tb.Helper()
+
+		o, n, ok := p.Bounds()
+		if oldest == "" {
+			tests.Expect(tb, !ok, "provider should not have bounds")
+			return
+		}
+		tests.Expect(tb, ok, "provider should have bounds")
+		tests.Equal(tb, o, sse.ID(oldest), "invalid oldest ID")
+		tests.Equal(tb, n, sse.ID(newest), "invalid newest ID")
+	}
+
+	fin, err := sse.NewFiniteReplayProvider(3, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	expectBounds(t, fin, "", "")
+	fin.Put(msg(t, "a", "1"), []string{sse.DefaultTopic})
+	expectBounds(t, fin, "1", "1")
+	fin.Put(msg(t, "b", "2"), []string{sse.DefaultTopic})
+	fin.Put(msg(t, "c", "3"), []string{sse.DefaultTopic})
+	fin.Put(msg(t, "d", "4"), []string{sse.DefaultTopic})
+	expectBounds(t, fin, "2", "4")
+	fin.Put(msg(t, "e", "5"), []string{sse.DefaultTopic})
+	fin.Put(msg(t, "f", "6"), []string{sse.DefaultTopic})
+	expectBounds(t, fin, "4", "6")
+
+	tm := &tests.Time{}
+	tm.Set(time.Now())
+	val := &sse.ValidReplayProvider{TTL: time.Millisecond * 5, Now: tm.Now, GCInterval: -1}
+
+	expectBounds(t, val, "", "")
+	val.Put(msg(t, "a", "a"), []string{sse.DefaultTopic})
+	tm.Add(val.TTL)
+	val.Put(msg(t, "b", "b"), []string{sse.DefaultTopic})
+	val.Put(msg(t, "c", "c"), []string{sse.DefaultTopic})
+	expectBounds(t, val, "b", "c")
+	tm.Add(val.TTL)
+	expectBounds(t, val, "", "")
+}
+
+func TestLatestPerKeyReplayProvider(t *testing.T) {
+	t.Parallel()
+
+	_, err := sse.NewLatestPerKeyReplayProvider(nil)
+	tests.Expect(t, err != nil, "should not create provider without key function")
+
+	p, err := sse.NewLatestPerKeyReplayProvider(func(m *sse.Message) string { return m.Type.String() })
+	tests.Equal(t, err, nil, "should create new LatestPerKeyReplayProvider")
+
+	put := func(typ, data string, topic string) {
+		m := &sse.Message{}
+		if typ != "" {
+			m.Type = sse.Type(typ)
+		}
+		m.AppendData(data)
+		p.Put(m, []string{topic})
+	}
+
+	put("a", "1", sse.DefaultTopic)
+	put("b", "1", sse.DefaultTopic)
+	put("", "ignored", sse.DefaultTopic)
+	put("c", "1", "other")
+	put("a", "2", sse.DefaultTopic)
+
+	var output string
+	for _, m := range replay(t, p, sse.EventID{}) {
+		output += m.String()
+	}
+
+	// The helper replays four times with different IDs – the snapshot is sent every time.
+	snapshot := "event: b\ndata: 1\n\nevent: a\ndata: 2\n\n"
+	tests.Equal(t, output, strings.Repeat(snapshot, 4), "invalid snapshot replayed")
+}
+
+func TestReplayProvider_ReplayLimit(t *testing.T) {
+	t.Parallel()
+
+	finite, err := sse.NewFiniteReplayProvider(10, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	latest, err := sse.NewLatestPerKeyReplayProvider(func(m *sse.Message) string { return m.ID.String() })
+	tests.Equal(t, err, nil, "should create new LatestPerKeyReplayProvider")
+
+	providers := map[string]sse.ReplayProvider{
+		"finite": finite,
+		"valid":  &sse.ValidReplayProvider{TTL: time.Hour},
+		"latest": latest,
+	}
+
+	for name, p := range providers {
+		p := p
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			for i, data := range []string{"a", "b", "c", "d"} {
+				p.Put(msg(t, data, strconv.Itoa(i+1)), []string{sse.DefaultTopic})
+			}
+			p.Put(msg(t, "other", "5"), []string{"other"})
+
+			var replayed []string
+			_ = p.Replay(sse.Subscription{
+				Client: mockClient(func(m *sse.Message) error {
+					if m != nil {
+						replayed = append(replayed, m.String())
+					}
+					return nil
+				}),
+				LastEventID: sse.ID("1"),
+				Topics:      []string{sse.DefaultTopic},
+				ReplayLimit: 2,
+			})
+
+			// The latest per key provider ignores the last event ID, but the limit still applies.
+			expected := []string{"id: 3\ndata: c\n\n", "id: 4\ndata: d\n\n"}
+			tests.DeepEqual(t, replayed, expected, "newest messages should be replayed in order")
+		})
+	}
+}
+
+func TestReplayProvider_ForEach(t *testing.T) {
+	t.Parallel()
+
+	type forEachProvider interface {
+		sse.ReplayProvider
+		ForEach(func(*sse.Message, []string))
+	}
+
+	collect := func(p forEachProvider) []string {
+		var ids []string
+		p.ForEach(func(m *sse.Message, topics []string) {
+			ids = append(ids, m.ID.String()+"@"+strings.Join(topics, ","))
+		})
+		return ids
+	}
+
+	fin, err := sse.NewFiniteReplayProvider(3, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	tests.Equal(t, len(collect(fin)), 0, "empty provider should have no messages")
+	fin.Put(msg(t, "a", "1"), []string{"x"})
+	fin.Put(msg(t, "b", "2"), []string{"y"})
+	fin.Put(msg(t, "c", "3"), []string{"x", "y"})
+	fin.Put(msg(t, "d", "4"), []string{"x"})
+	tests.DeepEqual(t, collect(fin), []string{"2@y", "3@x,y", "4@x"}, "invalid messages")
+	tests.DeepEqual(t, collect(fin), []string{"2@y", "3@x,y", "4@x"}, "iterating should not change the buffer")
+
+	tm := &tests.Time{}
+	tm.Set(time.Now())
+	val := &sse.ValidReplayProvider{TTL: time.Millisecond * 5, Now: tm.Now, GCInterval: -1}
+
+	tests.Equal(t, len(collect(val)), 0, "empty provider should have no messages")
+	val.Put(msg(t, "a", "a"), []string{"x"})
+	tm.Add(val.TTL)
+	val.Put(msg(t, "b", "b"), []string{"x"})
+	val.Put(msg(t, "c", "c"), []string{"y"})
+	tests.DeepEqual(t, collect(val), []string{"b@x", "c@y"}, "expired messages should be skipped")
+}
+
+func TestReplayProvider_RangeFromCursor(t *testing.T) {
+	t.Parallel()
+
+	now := &tests.Time{}
+	now.Set(time.Now())
+
+	finite, err := sse.NewFiniteReplayProvider(3, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	type ranger interface {
+		sse.ReplayProvider
+		RangeFromCursor(cursor string, fn func(*sse.Message, []string)) (string, error)
+	}
+	rangeIDs := func(p ranger, cursor string) ([]string, string, error) {
+		var ids []string
+		next, err := p.RangeFromCursor(cursor, func(m *sse.Message, _ []string) { ids = append(ids, m.ID.String()) })
+		return ids, next, err
+	}
+
+	valid := &sse.ValidReplayProvider{TTL: time.Minute, GCInterval: -1, Now: now.Now}
+	providers := map[string]ranger{"Finite": finite, "Valid": valid}
+
+	for name, p := range providers {
+		collect := func(cursor string) ([]string, string, error) { return rangeIDs(p, cursor) }
+
+		ids, start, err := collect("")
+		tests.Equal(t, err, nil, "%s: unexpected error for empty provider", name)
+		tests.Equal(t, len(ids), 0, "%s: nothing should be ranged over", name)
+
+		// The IDs are not sequential, nor unique, so they can't be used to resume.
+		for _, id := range []string{"x", "x", "a"} {
+			p.Put(msg(t, "", id), []string{sse.DefaultTopic})
+		}
+
+		ids, next, err := collect(start)
+		tests.Equal(t, err, nil, "%s: unexpected error", name)
+		tests.DeepEqual(t, ids, []string{"x", "x", "a"}, "%s: all the messages should be ranged over", name)
+
+		ids, _, _ = collect(next)
+		tests.Equal(t, len(ids), 0, "%s: there should be nothing after the last cursor", name)
+
+		p.Put(msg(t, "", "b"), []string{sse.DefaultTopic})
+		ids, _, err = collect(next)
+		tests.Equal(t, err, nil, "%s: unexpected error", name)
+		tests.DeepEqual(t, ids, []string{"b"}, "%s: only the new messages should be ranged over", name)
+
+		_, _, err = collect("-1")
+		tests.ErrorIs(t, err, sse.ErrInvalidCursor, "%s: negative cursors should be invalid", name)
+		_, _, err = collect("100")
+		tests.ErrorIs(t, err, sse.ErrInvalidCursor, "%s: future cursors should be invalid", name)
+		_, _, err = collect("c")
+		tests.ErrorIs(t, err, sse.ErrInvalidCursor, "%s: malformed cursors should be invalid", name)
+	}
+
+	// The first message was overwritten.
+	ids, oldest, err := rangeIDs(finite, "0")
+	tests.ErrorIs(t, err, sse.ErrCursorEvicted, "evicted messages should be reported")
+	tests.Equal(t, len(ids), 0, "nothing should be ranged over after eviction")
+	tests.Equal(t, oldest, "1", "the cursor of the oldest message should be returned")
+
+	now.Add(time.Minute)
+	ids, _, err = rangeIDs(valid, "")
+	tests.Equal(t, err, nil, "unexpected error")
+	tests.Equal(t, len(ids), 0, "expired messages should be skipped")
+}
+
+func TestTopicReplayProvider(t *testing.T) {
+	t.Parallel()
+
+	finite, err := sse.NewFiniteReplayProvider(2, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	p := &sse.TopicReplayProvider{
+		Providers: map[string]sse.ReplayProvider{"busy": finite},
+		Default:   &sse.ValidReplayProvider{TTL: time.Hour},
+	}
+
+	p.Put(msg(t, "", "1"), []string{"busy"})
+	p.Put(msg(t, "", "2"), []string{"quiet"})
+	p.Put(msg(t, "", "3"), []string{"busy"})
+	p.Put(msg(t, "", "4"), []string{"busy", "quiet"})
+	p.Put(msg(t, "", "5"), []string{"busy"})
+	p.Put(msg(t, "", "6"), []string{"quiet"})
+
+	replayed := func(sub sse.Subscription) []string {
+		var ids []string
+		sub.Client = mockClient(func(m *sse.Message) error {
+			if m != nil {
+				ids = append(ids, m.ID.String())
+			}
+			return nil
+		})
+		tests.Equal(t, p.Replay(sub), nil, "replay should succeed")
+		return ids
+	}
+
+	tests.DeepEqual(t, replayed(sse.Subscription{LastEventID: sse.ID("4"), Topics: []string{"busy"}}), []string{"5"}, "invalid busy topic replay")
+	// The quiet topic's messages are kept, even if the busy topic's provider evicts its own.
+	tests.DeepEqual(t, replayed(sse.Subscription{LastEventID: sse.ID("2"), Topics: []string{"quiet"}}), []string{"4", "6"}, "invalid quiet topic replay")
+	tests.DeepEqual(t, replayed(sse.Subscription{LastEventID: sse.ID("2"), Topics: []string{"other"}}), []string(nil), "unknown topics should use the default provider")
+	tests.DeepEqual(t, replayed(sse.Subscription{LastEventID: sse.ID("4"), Topics: []string{"quiet", "busy"}}), []string{"6", "5"}, "each provider should replay its topics")
+	tests.DeepEqual(t, replayed(sse.Subscription{LastEventID: sse.ID("4"), AllTopics: true}), []string{"5", "6"}, "all topics subscribers should be replayed from all providers")
+}
+
+func TestUnionReplayProvider(t *testing.T) {
+	t.Parallel()
+
+	tm := &tests.Time{}
+	finite, err := sse.NewFiniteReplayProvider(2, true)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	p := &sse.UnionReplayProvider{Providers: []sse.ReplayProvider{
+		finite,
+		&sse.ValidReplayProvider{TTL: time.Minute, Now: tm.Now, GCInterval: -1},
+	}}
+
+	tm.Set(time.Now())
+	for i := 0; i < 4; i++ {
+		m := p.Put(&sse.Message{}, []string{sse.DefaultTopic})
+		tests.Equal(t, m.ID, sse.ID(strconv.Itoa(i+1)), "first provider should set IDs")
+	}
+
+	replayed := func(lastEventID string) []string {
+		var ids []string
+		sub := sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					ids = append(ids, m.ID.String())
+				}
+				return nil
+			}),
+			LastEventID: sse.ID(lastEventID),
+			Topics:      []string{sse.DefaultTopic},
+		}
+		tests.Equal(t, p.Replay(sub), nil, "replay should succeed")
+		return ids
+	}
+
+	tests.DeepEqual(t, replayed("1"), []string{"2", "3", "4"}, "recent messages should be replayed")
+
+	tm.Add(time.Hour)
+	p.GC()
+	tests.DeepEqual(t, replayed("3"), []string{"4"}, "last messages should be replayed")
+
+	tests.Equal(t, (&sse.UnionReplayProvider{}).Replay(sse.Subscription{}), nil, "empty union should not replay")
+}
+
+// fixedReplayProvider replays the messages with the given IDs.
+type fixedReplayProvider []string
+
+func (f fixedReplayProvider) Put(m *sse.Message, _ []string) *sse.Message { return m }
+
+func (f fixedReplayProvider) Replay(sub sse.Subscription) error {
+	for _, id := range f {
+		if err := sub.Client.Send(&sse.Message{ID: sse.ID(id)}); err != nil {
+			return err
+		}
+	}
+	return sub.Client.Flush()
+}
+
+func TestReplayProvider_combined(t *testing.T) {
+	t.Parallel()
+
+	replayed := func(p sse.ReplayProvider, lastEventID string) []string {
+		var ids []string
+		sub := sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					ids = append(ids, m.ID.String())
+				}
+				return nil
+			}),
+			LastEventID: sse.ID(lastEventID),
+			Topics:      []string{sse.DefaultTopic},
+		}
+		tests.Equal(t, p.Replay(sub), nil, "replay should succeed")
+		return ids
+	}
+
+	providers := []sse.ReplayProvider{
+		fixedReplayProvider{"2", "4", "5"},
+		fixedReplayProvider{"1", "3", "4", "6"},
+		fixedReplayProvider{"3", "4", "5", "6"},
+	}
+
+	union := &sse.UnionReplayProvider{Providers: providers}
+	tests.DeepEqual(t, replayed(union, ""), []string{"2", "1", "3", "4", "5", "6"}, "all messages should be replayed once")
+
+	intersect := &sse.IntersectReplayProvider{Providers: providers}
+	tests.DeepEqual(t, replayed(intersect, ""), []string{"4"}, "only common messages should be replayed")
+	tests.Equal(t, (&sse.IntersectReplayProvider{}).Replay(sse.Subscription{}), nil, "empty intersection should not replay")
+
+	tm := &tests.Time{}
+	tm.Set(time.Now())
+	finite, err := sse.NewFiniteReplayProvider(3, true)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+	finite.ReplayFromOldestOnMiss = true
+	valid := &sse.ValidReplayProvider{TTL: time.Minute, Now: tm.Now, GCInterval: -1}
+
+	union = &sse.UnionReplayProvider{Providers: []sse.ReplayProvider{finite, valid}}
+	intersect = &sse.IntersectReplayProvider{Providers: []sse.ReplayProvider{finite, valid}}
+	for i := 0; i < 5; i++ {
+		if i == 3 {
+			tm.Add(30 * time.Second)
+		}
+		union.Put(&sse.Message{}, []string{sse.DefaultTopic})
+	}
+	tm.Add(45 * time.Second)
+
+	tests.DeepEqual(t, replayed(union, "1"), []string{"3", "4", "5"}, "last messages and recent messages should be replayed")
+	tests.DeepEqual(t, replayed(intersect, "1"), []string{"4", "5"}, "only recent last messages should be replayed")
+}
+
+func TestReplayProvider_KeepIDs(t *testing.T) {
+	t.Parallel()
+
+	finite, err := sse.NewFiniteReplayProvider(10, true)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+	finite.KeepIDs = true
+
+	providers := map[string]struct {
+		provider sse.ReplayProvider
+		ids      []string
+	}{
+		"Finite": {provider: finite, ids: []string{"1", "custom", "2", "10", "11"}},
+		"Valid":  {provider: &sse.ValidReplayProvider{TTL: time.Hour, AutoIDs: true, KeepIDs: true}, ids: []string{"0", "custom", "1", "10", "11"}},
+	}
+
+	for name, p := range providers {
+		var ids []string
+		for _, id := range []string{"", "custom", "", "10", ""} {
+			ids = append(ids, p.provider.Put(msg(t, "", id), []string{sse.DefaultTopic}).ID.String())
+		}
+		tests.DeepEqual(t, ids, p.ids, "%s: invalid IDs", name)
+
+		var replayed []string
+		_ = p.provider.Replay(sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					replayed = append(replayed, m.ID.String())
+				}
+				return nil
+			}),
+			LastEventID: sse.ID("custom"),
+			Topics:      []string{sse.DefaultTopic},
+		})
+		tests.DeepEqual(t, replayed, p.ids[2:], "%s: invalid replay after explicit ID", name)
+	}
+}
+
+func TestGenerationalReplayProvider(t *testing.T) {
+	t.Parallel()
+
+	_, err := sse.NewGenerationalReplayProvider(0)
+	tests.Expect(t, err != nil, "should not create provider with invalid count")
+
+	p, err := sse.NewGenerationalReplayProvider(3)
+	tests.Equal(t, err, nil, "should create new GenerationalReplayProvider")
+
+	replayed := func(lastEventID string) []string {
+		var ids []string
+		_ = p.Replay(sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					ids = append(ids, m.ID.String())
+				}
+				return nil
+			}),
+			LastEventID: sse.ID(lastEventID),
+			Topics:      []string{sse.DefaultTopic},
+		})
+		return ids
+	}
+
+	for i := 0; i < 4; i++ {
+		p.Put(msg(t, "", ""), []string{sse.DefaultTopic})
+	}
+
+	tests.DeepEqual(t, replayed("0.1"), []string{"0.2", "0.3"}, "invalid replay in current generation")
+	tests.DeepEqual(t, replayed("0.0"), []string{"0.1", "0.2", "0.3"}, "invalid replay after last evicted message")
+	tests.DeepEqual(t, replayed("0.3"), []string(nil), "nothing should be replayed to up to date clients")
+	tests.DeepEqual(t, replayed("1.0"), []string(nil), "nothing should be replayed for future generations")
+	tests.DeepEqual(t, replayed("mama"), []string(nil), "nothing should be replayed for invalid IDs")
+
+	p.Bump()
+	tests.Equal(t, p.Generation(), int64(1), "generation should be bumped")
+	tests.DeepEqual(t, replayed("0.1"), []string(nil), "old generation should not be replayed")
+
+	m := p.Put(msg(t, "", "explicit"), []string{sse.DefaultTopic})
+	tests.Equal(t, m.ID, sse.ID("1.0"), "IDs should be set for the new generation")
+	tests.DeepEqual(t, replayed("0.3"), []string(nil), "clients of old generations should receive nothing")
+
+	p.ResetOldGenerations = true
+	tests.DeepEqual(t, replayed("0.3"), []string{"1.0"}, "clients of old generations should be reset")
+}
+
+func TestReplayProvider_ReplayFromOldestOnMiss(t *testing.T) {
+	t.Parallel()
+
+	finite, err := sse.NewFiniteReplayProvider(2, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+	finite.ReplayFromOldestOnMiss = true
+
+	providers := map[string]sse.ReplayProvider{
+		"Finite": finite,
+		"Valid":  &sse.ValidReplayProvider{TTL: time.Hour, ReplayFromOldestOnMiss: true},
+	}
+
+	for name, p := range providers {
+		p.Put(msg(t, "", "1"), []string{sse.DefaultTopic})
+		p.Put(msg(t, "", "2"), []string{sse.DefaultTopic})
+		p.Put(msg(t, "", "3"), []string{sse.DefaultTopic})
+
+		replayed := func(lastEventID sse.EventID) []string {
+			var ids []string
+			_ = p.Replay(sse.Subscription{
+				Client: mockClient(func(m *sse.Message) error {
+					if m != nil {
+						ids = append(ids, m.ID.String())
+					}
+					return nil
+				}),
+				LastEventID: lastEventID,
+				Topics:      []string{sse.DefaultTopic},
+			})
+			return ids
+		}
+
+		expected := []string{"2", "3"}
+		if name == "Valid" {
+			expected = []string{"1", "2", "3"}
+		}
+
+		tests.DeepEqual(t, replayed(sse.ID("unknown")), expected, "%s: all messages should be replayed on miss", name)
+		tests.DeepEqual(t, replayed(sse.ID("2")), []string{"3"}, "%s: found IDs should be replayed as usual", name)
+		tests.DeepEqual(t, replayed(sse.ID("3")), []string(nil), "%s: up to date clients should receive nothing", name)
+		tests.DeepEqual(t, replayed(sse.EventID{}), []string(nil), "%s: clients without ID should receive nothing", name)
+	}
+}
+
+func TestReplayProvider_Seed(t *testing.T) {
+	t.Parallel()
+
+	finite, err := sse.NewFiniteReplayProvider(10, true)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	providers := map[string]interface {
+		sse.ReplayProvider
+		Seed(*sse.Message, []string)
+	}{
+		"Finite": finite,
+		"Valid":  &sse.ValidReplayProvider{TTL: time.Hour, AutoIDs: true},
+	}
+
+	for name, p := range providers {
+		p.Seed(msg(t, "", "7"), []string{sse.DefaultTopic})
+		p.Seed(msg(t, "", "8"), []string{sse.DefaultTopic})
+		tests.Equal(t, p.Put(msg(t, "", ""), []string{sse.DefaultTopic}).ID, sse.ID("9"), "%s: automatic IDs should continue from seeded IDs", name)
+
+		var replayed []string
+		_ = p.Replay(sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					replayed = append(replayed, m.ID.String())
+				}
+				return nil
+			}),
+			LastEventID: sse.ID("7"),
+			Topics:      []string{sse.DefaultTopic},
+		})
+		tests.DeepEqual(t, replayed, []string{"8", "9"}, "%s: invalid replay after seeded ID", name)
+
+		tests.Panics(t, func() { p.Seed(msg(t, "", ""), []string{sse.DefaultTopic}) }, "%s: seeding a message without ID should panic", name)
+	}
+}
+
+func TestReplayProvider_topicsOrder(t *testing.T) {
+	t.Parallel()
+
+	finite, err := sse.NewFiniteReplayProvider(10, false)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	providers := map[string]sse.ReplayProvider{
+		"Finite": finite,
+		"Valid":  &sse.ValidReplayProvider{TTL: time.Hour},
+	}
+
+	for name, p := range providers {
+		p.Put(msg(t, "", "1"), []string{"a"})
+		p.Put(msg(t, "", "2"), []string{"b"})
+		p.Put(msg(t, "", "3"), []string{"c"})
+		p.Put(msg(t, "", "4"), []string{"b"})
+		p.Put(msg(t, "", "5"), []string{"a", "b"})
+
+		var replayed []string
+		_ = p.Replay(sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					replayed = append(replayed, m.ID.String())
+				}
+				return nil
+			}),
+			LastEventID: sse.ID("1"),
+			Topics:      []string{"b", "a"},
+		})
+		tests.DeepEqual(t, replayed, []string{"2", "4", "5"}, "%s: messages should be replayed in publish order", name)
+	}
+}
+
+func BenchmarkValidReplayProvider(b *testing.B) {
+	const valid = 10_000
+
+	for _, capacity := range []int{0, valid} {
+		b.Run(fmt.Sprintf("Capacity=%d", capacity), func(b *testing.B) {
+			b.ReportAllocs()
+
+			m := &sse.Message{ID: sse.ID("1")}
+			topics := []string{sse.DefaultTopic}
+
+			for i := 0; i < b.N; i++ {
+				now := time.Time{}
+				p := &sse.ValidReplayProvider{
+					TTL:        valid * time.Millisecond,
+					GCInterval: time.Millisecond,
+					Capacity:   capacity,
+					Now:        func() time.Time { return now },
+				}
+
+				// Fill the buffer and then keep putting for as long, so the
+				// steady state is measured too.
+				for j := 0; j < 4*valid; j++ {
+					p.Put(m, topics)
+					now = now.Add(time.Millisecond)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReplayProvider_Replay(b *testing.B) {
+	const buffered = 50_000
+
+	finite, err := sse.NewFiniteReplayProvider(buffered, false)
+	tests.Equal(b, err, nil, "should create new FiniteReplayProvider")
+
+	providers := map[string]sse.ReplayProvider{
+		"Finite": finite,
+		"Valid":  &sse.ValidReplayProvider{TTL: time.Hour, Capacity: buffered},
+	}
+
+	topics := []string{sse.DefaultTopic}
+	for i := 0; i < buffered; i++ {
+		m := &sse.Message{ID: sse.ID(strconv.Itoa(i))}
+		finite.Put(m, topics)
+		providers["Valid"].Put(m, topics)
+	}
+
+	// Send only one message, so finding the ID and selecting the messages are measured.
+	sub := sse.Subscription{
+		Client:      mockClient(func(*sse.Message) error { return nil }),
+		Topics:      topics,
+		ReplayLimit: 1,
+	}
+
+	for _, name := range []string{"Finite", "Valid"} {
+		for _, from := range []int{10, buffered - 10} {
+			p := providers[name]
+			sub.LastEventID = sse.ID(strconv.Itoa(from))
+
+			b.Run(fmt.Sprintf("%s/From=%d", name, from), func(b *testing.B) {
+				b.ReportAllocs()
+
+				for i := 0; i < b.N; i++ {
+					_ = p.Replay(sub)
+				}
+			})
+		}
+	}
+}
+
+func TestReplayProvider_DuplicateIDs(t *testing.T) {
+	t.Parallel()
+
+	type provider interface {
+		sse.ReplayProvider
+		Seed(*sse.Message, []string)
+	}
+
+	newProviders := func(policy sse.DuplicateIDPolicy) map[string]provider {
+		finite, err := sse.NewFiniteReplayProvider(10, false)
+		tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+		finite.DuplicateIDs = policy
+
+		return map[string]provider{
+			"Finite": finite,
+			"Valid":  &sse.ValidReplayProvider{TTL: time.Hour, DuplicateIDs: policy},
+		}
+	}
+
+	replayed := func(p sse.ReplayProvider, lastEventID string) []string {
+		var data []string
+		_ = p.Replay(sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					data = append(data, m.String())
+				}
+				return nil
+			}),
+			LastEventID: sse.ID(lastEventID),
+			Topics:      []string{sse.DefaultTopic},
+		})
+		return data
+	}
+
+	put := func(p sse.ReplayProvider) {
+		for _, m := range [][2]string{{"a", "1"}, {"b", "2"}, {"c", "1"}, {"d", "3"}} {
+			p.Put(msg(t, m[0], m[1]), []string{sse.DefaultTopic})
+		}
+	}
+
+	for name, p := range newProviders(sse.DuplicateIDsAllow) {
+		put(p)
+		tests.DeepEqual(t, replayed(p, "1"), []string{"id: 2\ndata: b\n\n", "id: 1\ndata: c\n\n", "id: 3\ndata: d\n\n"}, "%s: replay should start after the oldest duplicate", name)
+	}
+
+	for name, p := range newProviders(sse.DuplicateIDsReplace) {
+		put(p)
+		tests.DeepEqual(t, replayed(p, "1"), []string{"id: 3\ndata: d\n\n"}, "%s: replay should start after the newest duplicate", name)
+		tests.DeepEqual(t, replayed(p, "0"), []string(nil), "%s: unknown ID should not be replayed", name)
+		tests.Equal(t, len(replayed(p, "2")), 2, "%s: replaced message should be removed", name)
+	}
+
+	wrapped, _ := sse.NewFiniteReplayProvider(3, false)
+	wrapped.DuplicateIDs = sse.DuplicateIDsReplace
+	for _, id := range []string{"1", "2", "3", "4", "5", "4"} {
+		wrapped.Put(msg(t, "", id), []string{sse.DefaultTopic})
+	}
+	tests.DeepEqual(t, replayed(wrapped, "3"), []string{"id: 5\n\n", "id: 4\n\n"}, "duplicates should be replaced in a full buffer")
+
+	tm := &tests.Time{}
+	tm.Set(time.Now())
+	evicted, _ := sse.NewFiniteReplayProvider(3, false)
+	valid := &sse.ValidReplayProvider{TTL: time.Hour, GCInterval: -1, Now: tm.Now}
+	for _, p := range []sse.ReplayProvider{evicted, valid} {
+		p.Put(msg(t, "a", "1"), []string{sse.DefaultTopic})
+		p.Put(msg(t, "x", "0"), []string{sse.DefaultTopic})
+	}
+	tm.Add(time.Minute)
+	for _, m := range [][2]string{{"b", "1"}, {"c", "2"}, {"d", "1"}} {
+		for _, p := range []sse.ReplayProvider{evicted, valid} {
+			p.Put(msg(t, m[0], m[1]), []string{sse.DefaultTopic})
+		}
+	}
+	tm.Add(time.Hour - time.Second)
+	valid.GC()
+	for name, p := range map[string]sse.ReplayProvider{"Finite": evicted, "Valid": valid} {
+		tests.DeepEqual(t, replayed(p, "1"), []string{"id: 2\ndata: c\n\n", "id: 1\ndata: d\n\n"}, "%s: replay should start after the oldest buffered duplicate", name)
+	}
+
+	for name, p := range newProviders(sse.DuplicateIDsReject) {
+		p.Put(msg(t, "a", "1"), []string{sse.DefaultTopic})
+		tests.Panics(t, func() { p.Put(msg(t, "b", "1"), []string{sse.DefaultTopic}) }, "%s: duplicate ID should be rejected", name)
+		tests.Panics(t, func() { p.Seed(msg(t, "b", "1"), []string{sse.DefaultTopic}) }, "%s: duplicate seeded ID should be rejected", name)
+		tests.DeepEqual(t, replayed(p, "1"), []string(nil), "%s: rejected message should not be buffered", name)
+	}
+}
+
+func TestSummarizingReplayProvider(t *testing.T) {
+	t.Parallel()
+
+	_, err := sse.NewSummarizingReplayProvider(nil, func(m []*sse.Message) []*sse.Message { return m })
+	tests.Expect(t, err != nil, "should not create provider without replay provider")
+
+	finite, err := sse.NewFiniteReplayProvider(10, true)
+	tests.Equal(t, err, nil, "should create new FiniteReplayProvider")
+
+	_, err = sse.NewSummarizingReplayProvider(finite, nil)
+	tests.Expect(t, err != nil, "should not create provider without summarize function")
+
+	calls := 0
+	p, err := sse.NewSummarizingReplayProvider(finite, func(missed []*sse.Message) []*sse.Message {
+		calls++
+
+		summary := &sse.Message{}
+		summary.AppendData(strconv.Itoa(len(missed)) + " new messages")
+		return []*sse.Message{summary, missed[len(missed)-1]}
+	})
+	tests.Equal(t, err, nil, "should create new SummarizingReplayProvider")
+
+	for i := 0; i < 4; i++ {
+		p.Put(msg(t, "hello", ""), []string{sse.DefaultTopic})
+	}
+
+	replayed := replay(t, p, sse.ID("1"))
+	tests.Equal(t, len(replayed), 2, "summary should be replayed")
+	tests.Equal(t, replayed[0].String(), "data: 3 new messages\n\n", "invalid summary")
+	tests.Equal(t, replayed[1].String(), "id: 4\ndata: hello\n\n", "last message should be replayed")
+
+	replay(t, p, sse.ID("4"))
+	tests.Equal(t, calls, 1, "summarize should not be called without missed messages")
+}
+
+func TestPartitionedReplayProvider(t *testing.T) {
+	t.Parallel()
+
+	_, err := sse.NewPartitionedReplayProvider(nil, func(string) sse.ReplayProvider { return nil })
+	tests.Expect(t, err != nil, "should not create provider without partition function")
+
+	partition := func(m *sse.Message, _ []string) string { return m.Type.String() }
+	_, err = sse.NewPartitionedReplayProvider(partition, nil)
+	tests.Expect(t, err != nil, "should not create provider without new provider function")
+
+	p, err := sse.NewPartitionedReplayProvider(partition, func(partition string) sse.ReplayProvider {
+		if partition == "ignored" {
+			return nil
+		}
+		f, _ := sse.NewFiniteReplayProvider(2, false)
+		return f
+	})
+	tests.Equal(t, err, nil, "should create new PartitionedReplayProvider")
+
+	put := func(partition, id string) {
+		m := msg(t, "hello", id)
+		m.Type = sse.Type(partition)
+		p.Put(m, []string{sse.DefaultTopic})
+	}
+	put("a", "1")
+	put("b", "1")
+	put("a", "2")
+	put("b", "2")
+	put("b", "3")
+	put("ignored", "1")
+
+	replayed := func(partition string) []string {
+		var ids []string
+		sub := sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					ids = append(ids, m.Type.String()+m.ID.String())
+				}
+				return nil
+			}),
+			LastEventID: sse.ID("1"),
+			Topics:      []string{sse.DefaultTopic},
+			Partition:   partition,
+		}
+		tests.Equal(t, p.Replay(sub), nil, "replay should succeed")
+		return ids
+	}
+
+	tests.DeepEqual(t, replayed("a"), []string{"a2"}, "only the partition's messages should be replayed")
+	tests.Equal(t, len(replayed("b")), 0, "partitions should be evicted separately")
+	tests.Equal(t, len(replayed("ignored")), 0, "partitions without provider should not be replayed")
+	tests.Equal(t, len(replayed("")), 0, "unknown partitions should not be replayed")
+
+	p.RemovePartition("a")
+	tests.Equal(t, len(replayed("a")), 0, "removed partition should not be replayed")
+}
@@ -0,0 +1,134 @@
+package sse
+
+import (
+	"errors"
+	"sort"
+)
+
+// TopicReplayProvider is a replay provider which buffers the messages of each topic
+// using a different replay provider – for example, a topic with many messages can use
+// a FiniteReplayProvider with a large count, while another uses a ValidReplayProvider
+// with a long TTL. A subscriber is replayed only messages from the providers of the topics
+// it is subscribed to.
+//
+// A message published to topics of different providers is put into each of those providers,
+// with the topics that belong to it. If the providers set IDs automatically, the message returned
+// by Put is the one returned by the provider of the first topic, so in this case make sure
+// each message is published only to topics of a single provider.
+//
+// If a subscriber receives topics of different providers, the providers replay the messages
+// one after the other, so the messages aren't replayed in chronological order, the subscription's
+// ReplayLimit applies to each provider separately and messages published to topics of multiple
+// providers are replayed multiple times. Subscribers to all topics are replayed the messages
+// of all the providers.
+//
+// TopicReplayProvider is as thread-safe as the replay providers it uses.
+type TopicReplayProvider struct {
+	// The replay providers of each topic. Multiple topics can use the same provider.
+	Providers map[string]ReplayProvider
+	// The replay provider used for topics which have no provider in Providers.
+	// If nil, the messages of those topics are not replayed.
+	Default ReplayProvider
+}
+
+type topicReplayGroup struct {
+	provider ReplayProvider
+	topics   []string
+}
+
+// groups returns the providers of the given topics, in the order the topics are given,
+// together with the topics that belong to each of them.
+func (t *TopicReplayProvider) groups(topics []string) []topicReplayGroup {
+	var groups []topicReplayGroup
+
+outer:
+	for _, topic := range topics {
+		p, ok := t.Providers[topic]
+		if !ok {
+			p = t.Default
+		}
+		if p == nil {
+			continue
+		}
+
+		for i := range groups {
+			if groups[i].provider == p {
+				groups[i].topics = append(groups[i].topics, topic)
+				continue outer
+			}
+		}
+
+		groups = append(groups, topicReplayGroup{provider: p, topics: []string{topic}})
+	}
+
+	return groups
+}
+
+// Put puts the message into the replay providers of the given topics.
+func (t *TopicReplayProvider) Put(message *Message, topics []string) *Message {
+	if len(topics) == 0 {
+		panic(errors.New(
+			"go-sse: no topics provided for Message.\n" +
+				formatMessagePanicString(message)))
+	}
+
+	groups := t.groups(topics)
+	if len(groups) == 0 {
+		return message
+	}
+
+	put := groups[0].provider.Put(message, groups[0].topics)
+	for _, g := range groups[1:] {
+		g.provider.Put(message, g.topics)
+	}
+
+	return put
+}
+
+// Replay replays to the subscriber the messages from the providers of the topics it is subscribed to.
+func (t *TopicReplayProvider) Replay(subscription Subscription) error {
+	if subscription.AllTopics {
+		topics := make([]string, 0, len(t.Providers))
+		for topic := range t.Providers {
+			topics = append(topics, topic)
+		}
+		// Replay in a deterministic order.
+		sort.Strings(topics)
+
+		groups := t.groups(topics)
+		if t.Default != nil && !containsProvider(groups, t.Default) {
+			groups = append(groups, topicReplayGroup{provider: t.Default})
+		}
+
+		for _, g := range groups {
+			if err := g.provider.Replay(subscription); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, g := range t.groups(subscription.Topics) {
+		sub := subscription
+		sub.Topics = g.topics
+
+		if err := g.provider.Replay(sub); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsProvider(groups []topicReplayGroup, p ReplayProvider) bool {
+	for _, g := range groups {
+		if g.provider == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+var _ ReplayProvider = (*TopicReplayProvider)(nil)
@@ -0,0 +1,208 @@
+package sse
+
+// UnionReplayProvider is a replay provider which puts the messages into multiple replay
+// providers and replays to each client the messages replayed by any of them. This way their
+// retention policies are combined – for example, using a FiniteReplayProvider and
+// a ValidReplayProvider, clients are replayed at least the last messages up to a count and
+// all the messages from the last minutes, whichever are more.
+//
+// The messages replayed by the providers are merged by their IDs: each message is replayed once,
+// even if more providers replay it, and the messages are replayed in the order they were put.
+// The replay providers which come with this package keep the newest messages, so the messages
+// they replay are always ordered relative to each other. If a custom provider also replays
+// older messages the others don't have, these are replayed before the next message the providers
+// have in common, after the ones replayed by the providers before it.
+//
+// The message is put into the first provider, which may set its ID. The message returned
+// is then put into the other providers, so these must not set IDs automatically.
+//
+// UnionReplayProvider is as thread-safe as the replay providers it uses.
+type UnionReplayProvider struct {
+	// The replay providers to put the messages into. Messages are replayed
+	// only if there is at least one provider.
+	Providers []ReplayProvider
+}
+
+// Put puts the message into all the providers.
+func (u *UnionReplayProvider) Put(message *Message, topics []string) *Message {
+	return putAll(u.Providers, message, topics)
+}
+
+// Replay replays the messages replayed by any of the providers, in the order they were put.
+func (u *UnionReplayProvider) Replay(subscription Subscription) error {
+	replays, err := collectReplays(u.Providers, subscription)
+	if err != nil || len(replays) == 0 {
+		return err
+	}
+
+	union := replays[0]
+	for _, r := range replays[1:] {
+		union = mergeReplays(union, r)
+	}
+
+	return sendReplay(subscription.Client, union)
+}
+
+// GC calls the GC method of the providers which have one, such as ValidReplayProvider.
+func (u *UnionReplayProvider) GC() {
+	gcAll(u.Providers)
+}
+
+// IntersectReplayProvider is a replay provider which puts the messages into multiple replay
+// providers and replays to each client only the messages replayed by all of them. This way
+// their retention policies are combined – for example, using a FiniteReplayProvider and
+// a ValidReplayProvider, clients are replayed at most the last messages up to a count which
+// are also from the last minutes.
+//
+// A message is considered to be replayed by multiple providers if it has the same ID.
+// The messages are replayed in the order they are replayed by the first provider.
+//
+// The message is put into the first provider, which may set its ID. The message returned
+// is then put into the other providers, so these must not set IDs automatically.
+//
+// IntersectReplayProvider is as thread-safe as the replay providers it uses.
+type IntersectReplayProvider struct {
+	// The replay providers to put the messages into. Messages are replayed
+	// only if there is at least one provider.
+	Providers []ReplayProvider
+}
+
+// Put puts the message into all the providers.
+func (i *IntersectReplayProvider) Put(message *Message, topics []string) *Message {
+	return putAll(i.Providers, message, topics)
+}
+
+// Replay replays the messages replayed by all the providers.
+func (i *IntersectReplayProvider) Replay(subscription Subscription) error {
+	replays, err := collectReplays(i.Providers, subscription)
+	if err != nil || len(replays) == 0 {
+		return err
+	}
+
+	intersection := replays[0]
+	for _, r := range replays[1:] {
+		ids := make(map[EventID]struct{}, len(r))
+		for _, m := range r {
+			ids[m.ID] = struct{}{}
+		}
+
+		kept := intersection[:0:0]
+		for _, m := range intersection {
+			if _, ok := ids[m.ID]; ok {
+				kept = append(kept, m)
+			}
+		}
+		intersection = kept
+	}
+
+	return sendReplay(subscription.Client, intersection)
+}
+
+// GC calls the GC method of the providers which have one, such as ValidReplayProvider.
+func (i *IntersectReplayProvider) GC() {
+	gcAll(i.Providers)
+}
+
+func putAll(providers []ReplayProvider, message *Message, topics []string) *Message {
+	if len(providers) == 0 {
+		return message
+	}
+
+	message = providers[0].Put(message, topics)
+	for _, p := range providers[1:] {
+		p.Put(message, topics)
+	}
+
+	return message
+}
+
+func gcAll(providers []ReplayProvider) {
+	for _, p := range providers {
+		if gc, ok := p.(interface{ GC() }); ok {
+			gc.GC()
+		}
+	}
+}
+
+// collectReplays returns the messages each of the providers replays to the subscription.
+func collectReplays(providers []ReplayProvider, subscription Subscription) ([][]*Message, error) {
+	replays := make([][]*Message, 0, len(providers))
+
+	for _, p := range providers {
+		c := &collectingClient{}
+
+		sub := subscription
+		sub.Client = c
+		if err := p.Replay(sub); err != nil {
+			return nil, err
+		}
+
+		replays = append(replays, c.messages)
+	}
+
+	return replays, nil
+}
+
+// mergeReplays merges the messages of b into a, without duplicates. The messages of b
+// which are not in a are placed before the next message which is in both.
+func mergeReplays(a, b []*Message) []*Message {
+	positions := make(map[EventID]int, len(a))
+	for i, m := range a {
+		positions[m.ID] = i
+	}
+
+	merged := make([]*Message, 0, len(a)+len(b))
+	next := 0
+	var pending []*Message
+
+	for _, m := range b {
+		p, ok := positions[m.ID]
+		if !ok {
+			pending = append(pending, m)
+			continue
+		}
+		if p < next {
+			// Already merged – the providers replayed the messages in a different order.
+			continue
+		}
+
+		merged = append(merged, a[next:p]...)
+		merged = append(merged, pending...)
+		merged = append(merged, a[p])
+		next, pending = p+1, pending[:0]
+	}
+
+	merged = append(merged, a[next:]...)
+	return append(merged, pending...)
+}
+
+func sendReplay(client MessageWriter, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	for _, m := range messages {
+		if err := client.Send(m); err != nil {
+			return err
+		}
+	}
+
+	return client.Flush()
+}
+
+// collectingClient is a MessageWriter which keeps the messages sent to it.
+type collectingClient struct {
+	messages []*Message
+}
+
+func (c *collectingClient) Send(m *Message) error {
+	c.messages = append(c.messages, m)
+	return nil
+}
+
+func (c *collectingClient) Flush() error { return nil }
+
+var (
+	_ ReplayProvider = (*UnionReplayProvider)(nil)
+	_ ReplayProvider = (*IntersectReplayProvider)(nil)
+)
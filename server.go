@@ -20,7 +20,11 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // The Subscription struct is used to subscribe to a given provider.
@@ -32,9 +36,52 @@ type Subscription struct {
 	// The events will replay starting from the first valid event sent after the one with the given ID.
 	// If the ID is invalid replaying events will be omitted and new events will be sent as normal.
 	LastEventID EventID
-	// The topics to receive message from. Must be a non-empty list.
+	// The topics to receive message from. Must be a non-empty list, unless AllTopics is set.
 	// Topics are orthogonal to event types. They are used to filter what the server sends to each client.
 	Topics []string
+	// If AllTopics is true, the client receives the messages published to any topic, including
+	// messages replayed from any topic. The Topics list is ignored in this case.
+	AllTopics bool
+	// The maximum duration of sending a message to this client. It overrides the provider's
+	// default timeout, if the provider supports timeouts. It applies to both replayed and live
+	// messages. If <0, sends to this client never time out.
+	SendTimeout time.Duration
+	// The maximum number of messages replayed to this client. If more messages were missed,
+	// only the newest ones are replayed, still in chronological order. If <=0, all the missed
+	// messages are replayed. The replay providers in this package respect this limit.
+	ReplayLimit int
+	// The maximum number of live messages per second sent to this client, if the provider
+	// supports rate limiting. Messages over the limit are dropped for this client only – they
+	// are not buffered. Replayed messages are not limited. If <=0, there is no limit.
+	RateLimit float64
+	// The maximum number of messages sent to this client in a burst when RateLimit is set.
+	// If <=0, it defaults to RateLimit, rounded up.
+	RateBurst int
+	// An optional message sent to this client right after the missed messages are replayed,
+	// before any live message, if the provider supports it. It is sent even if there was nothing
+	// to replay, so clients can use it to detect that they are up to date – for example,
+	// to hide a loading indicator. Give it an event type or a comment your clients can recognize.
+	// Joe and Pool send it.
+	ReplayDone *Message
+	// An optional identifier of this client, used to send messages only to it – see Joe.SendTo.
+	// Multiple subscriptions can have the same ID, for example one for each browser tab of a user,
+	// in which case all of them receive the messages sent to that ID.
+	ID string
+	// An optional key of the replay partition this client belongs to, such as the ID of its tenant.
+	// Replay providers which partition their messages, such as PartitionedReplayProvider, replay
+	// only the messages of this partition. Other replay providers ignore it. It doesn't affect
+	// which live messages the client receives – use topics for that.
+	Partition string
+	// An optional duration after which the client is unsubscribed, counted from when the provider
+	// receives the subscription, regardless of the subscription's context – for example, for ephemeral
+	// widgets. When it elapses, the client is removed just as if the context were done, and Subscribe
+	// returns nil. Joe supports it; other providers may ignore it. If <=0, the subscription doesn't expire.
+	TTL time.Duration
+}
+
+// receives reports whether the subscription should receive a message published to the given topics.
+func (s *Subscription) receives(topics []string) bool {
+	return s.AllTopics || topicsIntersect(s.Topics, topics)
 }
 
 // A Provider is a publish-subscribe system that can be used to implement a HTML5 server-sent events
@@ -47,13 +94,23 @@ type Subscription struct {
 // providers.
 type Provider interface {
 	// Subscribe to the provider. The context is used to remove the subscriber automatically
-	// when it is done. Errors returned by the subscription's callback function must be returned
-	// by Subscribe.
+	// when it is done – for example, when a deadline set on it is exceeded. Subscribe must
+	// return only after the subscriber was removed, so nothing is sent to it afterwards.
+	// Errors returned by the subscription's callback function must be returned by Subscribe.
 	//
-	// Providers can assume that the topics list for a subscription has at least one topic.
+	// The error returned tells why the subscriber was removed: nil if the context is done,
+	// ErrProviderClosed if the provider is shut down, or the error returned by the client.
+	// Exactly one of these is returned, even if they happen at the same time.
+	//
+	// Providers can assume that the topics list for a subscription has at least one topic,
+	// unless the subscription is to all topics.
 	Subscribe(ctx context.Context, subscription Subscription) error
 	// Publish a message to all the subscribers that are subscribed to the given topics.
 	// The topics slice must be non-empty, or ErrNoTopic will be raised.
+	//
+	// Publish may be called concurrently from multiple goroutines. Subscribers must receive
+	// the messages published by a single goroutine in the order they were published;
+	// there is no ordering guarantee for messages published concurrently.
 	Publish(message *Message, topics []string) error
 	// Shutdown stops the provider. Calling Shutdown will clean up all the provider's resources
 	// and make Subscribe and Publish fail with an error. All the listener channels will be
@@ -105,6 +162,9 @@ type Logger interface {
 	// is of type sse.EventID; there will also be a "topics" key, with a value of
 	// type []string, which contains all the topics the client is being
 	// subscribed to.
+	//
+	// When the session ends, the data map contains a "bytesWritten" key,
+	// with a value of type int64: the number of bytes sent to the client.
 	Log(ctx context.Context, level LogLevel, msg string, data map[string]any)
 }
 
@@ -112,6 +172,10 @@ type Logger interface {
 // It implements the http.Handler interface and has some methods
 // for calling the underlying provider's methods.
 //
+// The Server is the glue between a Provider and an HTTP endpoint: mount it on a route
+// and each request is upgraded, subscribed to the provider using its Last-Event-ID and the
+// topics chosen by OnSession, and unsubscribed when the client disconnects.
+//
 // When creating a server, if no provider is specified using the WithProvider
 // option, the Joe provider found in this package with no replay provider is used.
 type Server struct {
@@ -135,6 +199,49 @@ type Server struct {
 	// If Logger is not nil, the Server will log various information about
 	// the request lifecycle. See the documentation of Logger for more info.
 	Logger Logger
+	// FlushInterval and FlushBatch configure how often the sessions created
+	// by the server flush messages. See the Session fields with the same
+	// name for more information. By default messages are flushed immediately.
+	FlushInterval time.Duration
+	FlushBatch    int
+	// If NDJSONFallback is true, clients which can't receive server-sent events can request
+	// messages to be streamed as newline-delimited JSON, either by setting the "format" query
+	// parameter to "ndjson" or by accepting application/x-ndjson but not text/event-stream.
+	// See the Session.NDJSON field for the exact format. SSE clients are unaffected.
+	NDJSONFallback bool
+	// Variants are the content types of the message variants the server offers, in order of
+	// preference – see Message.AppendVariantData. Each session is sent the variant the client
+	// asks for using the "variant" query parameter, if it is one of these, or the first of these
+	// which the client accepts, as listed in its Accept header. If the client negotiates none
+	// of them, it is sent the messages' own data. See the Session.Variant field, which OnSession
+	// can also change.
+	Variants []string
+	// If SessionTimeout is set, sessions end after this duration: the client is unsubscribed,
+	// the pending messages are flushed and the handler returns. Use it for request-scoped
+	// streaming. Clients will reconnect afterwards, as with any other ended stream, unless
+	// a CloseMessage is sent to them. Deadlines set on the request's context are respected, too.
+	SessionTimeout time.Duration
+	// If ReplayDone is not nil, it is sent to subscriptions which don't set their own
+	// replay done message. See the Subscription field with the same name.
+	ReplayDone *Message
+	// WriteTimeout configures the sessions created by the server. See the Session
+	// field with the same name for more information. Clients whose writes time out
+	// are considered disconnected. By default there is no timeout, apart from
+	// the http.Server's WriteTimeout, if set.
+	WriteTimeout time.Duration
+	// If Sequence is true, the messages sent to each session are numbered.
+	// See the Session field with the same name for more information.
+	Sequence bool
+	// If Timestamp is true, the messages sent to each session are stamped with the time they
+	// are written. See the Session field with the same name for more information.
+	Timestamp bool
+	// If MaxIdle is set, sessions on which nothing was written for this long end: the client
+	// is unsubscribed and the handler returns, just like when the SessionTimeout passes.
+	// Use it to reclaim the resources of clients which receive messages too seldom to
+	// notice they're disconnected. Any message written, including ones with only comments,
+	// such as keep-alive heartbeats, resets the idle duration – if heartbeats are published
+	// more often than MaxIdle, sessions never become idle.
+	MaxIdle time.Duration
 
 	provider Provider
 	initDone sync.Once
@@ -149,7 +256,8 @@ type Server struct {
 // If the request isn't upgradeable, it writes a message to the client along with
 // an 500 Internal Server ConnectionError response code. If on subscribe the provider returns
 // an error, it writes the error message to the client and a 500 Internal Server ConnectionError
-// response code.
+// response code, a 503 Service Unavailable response code if the error is ErrSubscribeTimeout or
+// ErrTopicFull, or a 400 Bad Request response code if the error is ErrUnknownTopic.
 //
 // To customize behavior, use the OnSession callback or create your custom handler.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -172,6 +280,14 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	sess.FlushInterval = s.FlushInterval
+	sess.FlushBatch = s.FlushBatch
+	sess.NDJSON = s.NDJSONFallback && prefersNDJSON(r)
+	sess.Variant = negotiateVariant(r, s.Variants)
+	sess.WriteTimeout = s.WriteTimeout
+	sess.Sequence = s.Sequence
+	sess.Timestamp = s.Timestamp
+
 	sub, ok := s.getSubscription(sess)
 	if !ok {
 		if l != nil {
@@ -180,21 +296,66 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if sub.ReplayDone == nil {
+		sub.ReplayDone = s.ReplayDone
+	}
+
 	if l != nil {
 		l.Log(r.Context(), LogLevelInfo, "sse: subscribing session", map[string]any{"topics": slicesClone(sub.Topics), "lastEventID": sub.LastEventID})
 	}
 
-	if err = s.provider.Subscribe(r.Context(), sub); err != nil {
+	ctx := r.Context()
+	if s.SessionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.SessionTimeout)
+		defer cancel()
+	}
+	if s.MaxIdle > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+
+		go endIdle(ctx, cancel, sess, s.MaxIdle)
+	}
+
+	// When the context is done, the provider removes the subscriber and Subscribe returns –
+	// see the documentation of Provider.Subscribe.
+	err = s.provider.Subscribe(ctx, sub)
+	_ = sess.Close()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		// The client is too slow, which is handled as a disconnect. Nothing can be
+		// written to the response anymore.
+		if l != nil {
+			l.Log(r.Context(), LogLevelWarn, "sse: write timed out", map[string]any{"err": err, "bytesWritten": sess.BytesWritten()})
+		}
+		return
+	}
+	if errors.Is(err, ErrProviderClosed) && sess.didUpgrade {
+		// The provider was shut down after messages were sent, so the response
+		// can't be changed anymore. Clients will reconnect to another server.
+		if l != nil {
+			l.Log(r.Context(), LogLevelInfo, "sse: provider shut down", map[string]any{"bytesWritten": sess.BytesWritten()})
+		}
+		return
+	}
+	if err != nil {
 		if l != nil {
 			l.Log(r.Context(), LogLevelError, "sse: subscribe error", map[string]any{"err": err})
 		}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrSubscribeTimeout) || errors.Is(err, ErrTopicFull) {
+			code = http.StatusServiceUnavailable
+		} else if errors.Is(err, ErrUnknownTopic) {
+			code = http.StatusBadRequest
+		}
+
+		http.Error(w, err.Error(), code)
 		return
 	}
 
 	if l != nil {
-		l.Log(r.Context(), LogLevelInfo, "sse: session ended", nil)
+		l.Log(r.Context(), LogLevelInfo, "sse: session ended", map[string]any{"bytesWritten": sess.BytesWritten()})
 	}
 }
 
@@ -230,8 +391,8 @@ func (s *Server) init() {
 func (s *Server) getSubscription(sess *Session) (Subscription, bool) {
 	if s.OnSession != nil {
 		sub, ok := s.OnSession(sess)
-		if ok && len(sub.Topics) == 0 {
-			panic("go-sse: session handlers should return a Subscription to at least 1 topic")
+		if ok && len(sub.Topics) == 0 && !sub.AllTopics {
+			panic("go-sse: session handlers should return a Subscription to at least 1 topic or to all topics")
 		}
 
 		return sub, ok
@@ -244,6 +405,94 @@ func (s *Server) getSubscription(sess *Session) (Subscription, bool) {
 	}, true
 }
 
+// endIdle cancels the context once nothing was written to the session for maxIdle.
+func endIdle(ctx context.Context, cancel context.CancelFunc, sess *Session, maxIdle time.Duration) {
+	start := time.Now()
+	t := time.NewTimer(maxIdle)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		last := start
+		if ts := sess.lastWrite.Load(); ts != 0 {
+			last = time.Unix(0, ts)
+		}
+
+		idle := time.Since(last)
+		if idle >= maxIdle {
+			cancel()
+			return
+		}
+
+		t.Reset(maxIdle - idle)
+	}
+}
+
+func prefersNDJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "ndjson" {
+		return true
+	}
+
+	accept := r.Header.Values("Accept")
+	return acceptsMediaType(accept, "application/x-ndjson") && !acceptsMediaType(accept, "text/event-stream")
+}
+
+func negotiateVariant(r *http.Request, variants []string) string {
+	if len(variants) == 0 {
+		return ""
+	}
+
+	if requested := r.URL.Query().Get("variant"); requested != "" {
+		for _, v := range variants {
+			if v == requested {
+				return v
+			}
+		}
+	}
+
+	accept := r.Header.Values("Accept")
+	for _, v := range variants {
+		if acceptsMediaType(accept, v) {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// acceptsMediaType reports whether the Accept header values explicitly list the given media type
+// with a non-zero quality value. Wildcard media ranges are not taken into account.
+func acceptsMediaType(accept []string, mediaType string) bool {
+	for _, v := range accept {
+		for _, mediaRange := range strings.Split(v, ",") {
+			params := strings.Split(mediaRange, ";")
+			if !strings.EqualFold(strings.TrimSpace(params[0]), mediaType) {
+				continue
+			}
+
+			acceptable := true
+			for _, p := range params[1:] {
+				name, value, _ := strings.Cut(p, "=")
+				if strings.EqualFold(strings.TrimSpace(name), "q") {
+					q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+					acceptable = err == nil && q > 0
+				}
+			}
+
+			if acceptable {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 var defaultTopicSlice = []string{DefaultTopic}
 
 func getTopics(initial []string) []string {
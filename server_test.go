@@ -9,12 +9,15 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/tmaxmax/go-sse"
 	"github.com/tmaxmax/go-sse/internal/tests"
+	"github.com/tmaxmax/go-sse/ssetest"
 )
 
 type mockProvider struct {
@@ -199,6 +202,202 @@ func TestServer_ServeHTTP_subscribeError(t *testing.T) {
 	tests.Equal(t, sb.String(), "level=INFO msg=\"sse: starting new session\"\nlevel=INFO msg=\"sse: subscribing session\" topics=\"\" lastEventID=\"\"\nlevel=ERROR msg=\"sse: subscribe error\" err=\"can't subscribe\"\n", "invalid log output")
 }
 
+func TestServer_ServeHTTP_sessionTimeout(t *testing.T) {
+	t.Parallel()
+
+	p := &ssetest.FakeProvider{}
+	s := &sse.Server{Provider: p, SessionTimeout: time.Millisecond * 20, FlushInterval: time.Hour}
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		s.ServeHTTP(rec, httptest.NewRequest("", "/", http.NoBody))
+	}()
+
+	tests.Equal(t, p.WaitForSubscribers(context.Background(), 1), nil, "session should be subscribed")
+	tests.Equal(t, p.Deliver(msg(t, "hello", "")), 1, "message should be delivered")
+
+	<-done
+	tests.Equal(t, len(p.Subscriptions()), 0, "session should be unsubscribed")
+	tests.Equal(t, rec.Body.String(), "data: hello\n\n", "pending messages should be flushed when the session ends")
+	tests.Expect(t, rec.Flushed, "pending messages should be flushed when the session ends")
+}
+
+func TestServer_ServeHTTP_maxIdle(t *testing.T) {
+	t.Parallel()
+
+	p := &ssetest.FakeProvider{}
+	s := &sse.Server{Provider: p, MaxIdle: time.Millisecond * 40}
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		s.ServeHTTP(rec, httptest.NewRequest("", "/", http.NoBody))
+	}()
+
+	tests.Equal(t, p.WaitForSubscribers(context.Background(), 1), nil, "session should be subscribed")
+	time.Sleep(time.Millisecond * 20)
+	delivered := time.Now()
+	tests.Equal(t, p.Deliver(msg(t, "hello", "")), 1, "message should be delivered")
+
+	<-done
+	tests.Expect(t, time.Since(delivered) >= s.MaxIdle, "writes should reset the idle duration")
+	tests.Equal(t, len(p.Subscriptions()), 0, "idle session should be unsubscribed")
+	tests.Equal(t, rec.Body.String(), "data: hello\n\n", "message should be sent")
+}
+
+func TestServer_ServeHTTP_shutdown(t *testing.T) {
+	t.Parallel()
+
+	p := &ssetest.FakeProvider{}
+	sb := &strings.Builder{}
+	s := &sse.Server{Provider: p, Logger: newMockLogger(sb)}
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		s.ServeHTTP(rec, httptest.NewRequest("", "/", http.NoBody))
+	}()
+
+	tests.Equal(t, p.WaitForSubscribers(context.Background(), 1), nil, "session should be subscribed")
+	tests.Equal(t, p.Deliver(msg(t, "hello", "")), 1, "message should be delivered")
+	tests.Equal(t, s.Shutdown(context.Background()), nil, "unexpected shutdown error")
+
+	<-done
+	tests.Equal(t, rec.Body.String(), "data: hello\n\n", "no error should be written after messages were sent")
+	tests.Expect(t, strings.Contains(sb.String(), `level=INFO msg="sse: provider shut down"`), "shutdown should be logged")
+}
+
+func TestServer_ServeHTTP_writeTimeout(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("", "http://localhost", http.NoBody)
+	p := newMockProvider(t, fmt.Errorf("write: %w", os.ErrDeadlineExceeded))
+	sb := &strings.Builder{}
+
+	(&sse.Server{Provider: p, Logger: newMockLogger(sb)}).ServeHTTP(rec, req)
+
+	tests.Equal(t, rec.Body.String(), "", "timeouts should be handled as disconnects")
+	tests.Expect(t, strings.Contains(sb.String(), `level=WARN msg="sse: write timed out"`), "timeout should be logged")
+}
+
+func TestServer_ServeHTTP_subscribeTimeout(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("", "http://localhost", http.NoBody)
+	p := newMockProvider(t, sse.ErrSubscribeTimeout)
+
+	(&sse.Server{Provider: p}).ServeHTTP(rec, req)
+
+	tests.Equal(t, rec.Code, http.StatusServiceUnavailable, "invalid response code")
+}
+
+func TestServer_ServeHTTP_unknownTopic(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("", "http://localhost", http.NoBody)
+	p := newMockProvider(t, fmt.Errorf("%w: %q", sse.ErrUnknownTopic, "typo"))
+
+	(&sse.Server{Provider: p}).ServeHTTP(rec, req)
+
+	tests.Equal(t, rec.Code, http.StatusBadRequest, "invalid response code")
+}
+
+func TestServer_ServeHTTP_sequenceAndTimestamp(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	// The mock provider returns after sending a message if the request is already done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("", "/", http.NoBody).WithContext(ctx)
+
+	before := time.Now().UnixMilli()
+	(&sse.Server{Provider: newMockProvider(t, nil), Sequence: true, Timestamp: true}).ServeHTTP(rec, req)
+	after := time.Now().UnixMilli()
+
+	var seq, ts int64
+	_, err := fmt.Sscanf(rec.Body.String(), "seq: %d\nts: %d\ndata: hello\n\n", &seq, &ts)
+	tests.Equal(t, err, nil, "message should be numbered and stamped, got %q", rec.Body.String())
+	tests.Equal(t, seq, int64(1), "invalid sequence number")
+	tests.Expect(t, ts >= before && ts <= after, "invalid timestamp %d", ts)
+}
+
+func TestServer_ServeHTTP_variants(t *testing.T) {
+	t.Parallel()
+
+	negotiate := func(target, accept string) string {
+		var variant string
+		s := &sse.Server{
+			Provider: newMockProvider(t, nil),
+			Variants: []string{"application/json", "application/msgpack"},
+			OnSession: func(sess *sse.Session) (sse.Subscription, bool) {
+				variant = sess.Variant
+				return sse.Subscription{}, false
+			},
+		}
+
+		req := httptest.NewRequest("", target, http.NoBody)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		s.ServeHTTP(httptest.NewRecorder(), req)
+		return variant
+	}
+
+	tests.Equal(t, negotiate("/", ""), "", "no variant should be negotiated by default")
+	tests.Equal(t, negotiate("/?variant=application/msgpack", "application/json"), "application/msgpack", "query parameter should take precedence")
+	tests.Equal(t, negotiate("/?variant=text/plain", ""), "", "unknown variants should not be negotiated")
+	tests.Equal(t, negotiate("/", "text/event-stream, application/msgpack, application/json"), "application/json", "server preference should be used")
+	tests.Equal(t, negotiate("/", "application/json;q=0, application/msgpack"), "application/msgpack", "media types with zero quality should not be accepted")
+}
+
+func TestServer_ServeHTTP_ndjson(t *testing.T) {
+	t.Parallel()
+
+	serve := func(s *sse.Server, target, accept string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		// The mock provider returns after sending a message if the request is already done.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest("", target, http.NoBody).WithContext(ctx)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(&sse.Server{Provider: newMockProvider(t, nil), NDJSONFallback: true}, "/?format=ndjson", "")
+	tests.Equal(t, rec.Header().Get("Content-Type"), "application/x-ndjson", "invalid content type")
+	tests.Equal(t, rec.Body.String(), `{"chunks":[{"data":"hello"}]}`+"\n", "invalid response body")
+
+	rec = serve(&sse.Server{Provider: newMockProvider(t, nil), NDJSONFallback: true}, "/", "application/x-ndjson")
+	tests.Equal(t, rec.Header().Get("Content-Type"), "application/x-ndjson", "accept header should be negotiated")
+
+	rec = serve(&sse.Server{Provider: newMockProvider(t, nil), NDJSONFallback: true}, "/", "text/event-stream, application/x-ndjson")
+	tests.Equal(t, rec.Header().Get("Content-Type"), "text/event-stream", "SSE clients should be unaffected")
+
+	rec = serve(&sse.Server{Provider: newMockProvider(t, nil), NDJSONFallback: true}, "/", "application/x-ndjson, text/event-stream;q=0")
+	tests.Equal(t, rec.Header().Get("Content-Type"), "application/x-ndjson", "media types with zero quality should not be accepted")
+
+	rec = serve(&sse.Server{Provider: newMockProvider(t, nil), NDJSONFallback: true}, "/", "application/x-ndjson;q=0")
+	tests.Equal(t, rec.Header().Get("Content-Type"), "text/event-stream", "media types with zero quality should not be accepted")
+
+	rec = serve(&sse.Server{Provider: newMockProvider(t, nil), NDJSONFallback: true}, "/", "application/x-ndjsonfoo")
+	tests.Equal(t, rec.Header().Get("Content-Type"), "text/event-stream", "media types should match exactly")
+
+	rec = serve(&sse.Server{Provider: newMockProvider(t, nil)}, "/?format=ndjson", "")
+	tests.Equal(t, rec.Header().Get("Content-Type"), "text/event-stream", "fallback should be opt-in")
+	tests.Equal(t, rec.Body.String(), "data: hello\n\n", "invalid response body")
+}
+
 func TestServer_OnSession(t *testing.T) {
 	t.Parallel()
 
@@ -3,6 +3,10 @@ package sse
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ResponseWriter is a http.ResponseWriter augmented with a Flush method.
@@ -13,6 +17,10 @@ type ResponseWriter interface {
 
 // MessageWriter is a special kind of response writer used by providers to
 // send Messages to clients.
+//
+// Providers don't depend on the transport: implement MessageWriter on top of
+// another connection type to send messages to those clients using the same providers
+// and replay providers. For WebSockets, use WebSocketWriter.
 type MessageWriter interface {
 	// Sens sends the message to the client.
 	// To make sure it is sent, call Flush.
@@ -37,39 +45,276 @@ type Session struct {
 	// request header.
 	LastEventID EventID
 
+	// FlushInterval, if set, makes Flush send the buffered messages to the client only if
+	// at least this much time passed since the last flush.
+	FlushInterval time.Duration
+	// FlushBatch, if greater than 1, makes Flush send the buffered messages to the client
+	// only if at least this many messages were sent since the last flush.
+	//
+	// If both FlushInterval and FlushBatch are set, the messages are flushed when any
+	// of the conditions is met. By default messages are flushed on every Flush call.
+	// Flushing less often sends fewer network packets, at the cost of some latency:
+	// if no subsequent Flush call meets the conditions, the buffered messages are flushed
+	// in the background once the FlushInterval passes – or, if only FlushBatch is set,
+	// after at most 100 milliseconds. Handlers using these fields must call Close before
+	// returning, so the remaining messages are flushed and the background flush is stopped.
+	FlushBatch int
+	// If NDJSON is true, messages are sent as newline-delimited JSON instead of the event
+	// stream format: the Content-Type is application/x-ndjson and each message is written on
+	// a single line, using the representation described by Message.MarshalJSON:
+	//
+	//	{"id":"1","event":"update","chunks":[{"data":"hello"}]}
+	//
+	// This is a fallback for clients that can't receive server-sent events, for example
+	// because a proxy strips the text/event-stream responses. It must be set before
+	// sending the first message.
+	NDJSON bool
+	// WriteTimeout, if set, is the maximum duration of each write to the client: a write
+	// deadline is set on the connection before each message is sent and before each flush.
+	// If a client reads too slowly, the write fails with an error which wraps
+	// os.ErrDeadlineExceeded, so the provider removes it, just like a disconnected client.
+	// The Server handles these errors as disconnects.
+	//
+	// If the response writer doesn't support deadlines (see SetWriteDeadline), writes
+	// are done without a deadline. If the provider also sets deadlines, for example
+	// through Joe.SendTimeout, the WriteTimeout takes precedence for each write.
+	WriteTimeout time.Duration
+	// If Sequence is true, each message sent is numbered using a non-standard field:
+	//
+	//	seq: 1
+	//	id: 5
+	//	data: hello
+	//
+	// The sequence starts at 1 and is incremented for each message written to this session,
+	// so clients can detect that they missed messages – for example, because of a rate limit –
+	// and reconnect to have them replayed. Unlike the event ID, it is specific to the connection.
+	// Messages which have only comments are not numbered. The sequence is not written in
+	// NDJSON mode.
+	Sequence bool
+	// If Timestamp is true, each message sent is stamped with the time it is written,
+	// in Unix milliseconds, using a non-standard field, after the sequence, if any:
+	//
+	//	ts: 1700000000000
+	//	id: 5
+	//	data: hello
+	//
+	// so clients can measure the latency of the events. The time is taken by Send, right before
+	// the message is written to the response – with Joe, in his goroutine, when the message is sent
+	// to this client, after it waited in the publish queue, if any, and after it was sent to the other
+	// subscribers before this one. If messages are flushed in batches, the time they are buffered for
+	// is not included. The field adds about 18 bytes to each event. Messages which have only comments
+	// are not stamped. The timestamp is not written in NDJSON mode.
+	Timestamp bool
+	// Variant is the content type negotiated by the client for the data of the messages:
+	// messages which have a variant for it are sent with the variant's data instead of
+	// their own – see Message.AppendVariantData. If it is empty, or if a message has
+	// no variant for it, the message's own data is sent. The Server sets it using
+	// its Variants field; it can also be set in OnSession, before subscribing.
+	Variant string
+
+	seq        uint64
+	lastFlush  time.Time
+	flushTimer *time.Timer
+	// flushGen identifies the current flush timer, so callbacks of stopped timers are ignored.
+	flushGen uint64
+	written  atomic.Int64
+	// lastWrite is the Unix time in nanoseconds of the last message written.
+	lastWrite  atomic.Int64
+	mu         sync.Mutex
+	pending    int
 	didUpgrade bool
+	closed     bool
+	// noDeadlines is set if the response writer doesn't support write deadlines.
+	noDeadlines bool
 }
 
+const (
+	sequenceFieldName  = "seq"
+	timestampFieldName = "ts"
+)
+
+// maxFlushDelay is the maximum duration messages are buffered for when only FlushBatch is set.
+const maxFlushDelay = 100 * time.Millisecond
+
 // Send sends the given event to the client. It returns any errors that occurred while writing the event.
 func (s *Session) Send(e *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if err := s.doUpgrade(); err != nil {
 		return err
 	}
-	if _, err := e.WriteTo(s.Res); err != nil {
+	s.setWriteTimeout()
+
+	e = e.Variant(s.Variant)
+
+	var n int64
+	var err error
+	if s.NDJSON {
+		n, err = e.WriteNDJSON(s.Res)
+	} else {
+		var buf [2]extensionField
+		fields := buf[:0]
+		if !e.isHeartbeat() {
+			if s.Sequence {
+				s.seq++
+				fields = append(fields, extensionField{name: sequenceFieldName, value: strconv.FormatUint(s.seq, 10)})
+			}
+			if s.Timestamp {
+				fields = append(fields, extensionField{name: timestampFieldName, value: strconv.FormatInt(time.Now().UnixMilli(), 10)})
+			}
+		}
+		n, err = e.writeTo(s.Res, fields, fieldBytesData)
+	}
+	s.written.Add(n)
+	s.lastWrite.Store(time.Now().UnixNano())
+	if err != nil {
 		return err
 	}
+	s.pending++
 	return nil
 }
 
-// Flush sends any buffered messages to the client.
+// BytesWritten returns the number of bytes of the messages sent to the client until now,
+// including the bytes of messages whose writing failed midway. It can be called
+// concurrently with Send, and also after the session ended, for example to account
+// for the bandwidth used by each client.
+func (s *Session) BytesWritten() int64 {
+	return s.written.Load()
+}
+
+// Flush sends any buffered messages to the client, taking into account the
+// FlushInterval and FlushBatch settings. If the messages are not flushed now,
+// they are flushed later in the background.
 func (s *Session) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	prevDidUpgrade := s.didUpgrade
 	if err := s.doUpgrade(); err != nil {
 		return err
 	}
-	if prevDidUpgrade == s.didUpgrade {
-		return s.Res.Flush()
+	if prevDidUpgrade != s.didUpgrade {
+		return nil
+	}
+	if s.closed || s.shouldFlush() {
+		return s.flush()
 	}
+
+	s.scheduleFlush()
+	return nil
+}
+
+// Close flushes any pending messages and stops the background flushing done when
+// FlushInterval or FlushBatch are set. Subsequent calls to Flush flush the messages
+// immediately. The Server calls Close when the session ends; custom handlers must call
+// it before returning, given that the response writer must not be used afterwards.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+	if s.pending > 0 {
+		return s.flush()
+	}
+	s.stopFlushTimer()
 	return nil
 }
 
+func (s *Session) flush() error {
+	s.stopFlushTimer()
+	s.pending = 0
+	s.lastFlush = time.Now()
+	s.setWriteTimeout()
+	return s.Res.Flush()
+}
+
+func (s *Session) scheduleFlush() {
+	if s.pending == 0 || s.flushTimer != nil {
+		return
+	}
+
+	delay := maxFlushDelay
+	if s.FlushInterval > 0 {
+		delay = s.FlushInterval - time.Since(s.lastFlush)
+	}
+
+	gen := s.flushGen
+	s.flushTimer = time.AfterFunc(delay, func() { s.delayedFlush(gen) })
+}
+
+func (s *Session) stopFlushTimer() {
+	if s.flushTimer != nil {
+		s.flushTimer.Stop()
+		s.flushTimer = nil
+		s.flushGen++
+	}
+}
+
+func (s *Session) delayedFlush(gen uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if gen != s.flushGen {
+		return
+	}
+
+	s.flushTimer = nil
+	s.flushGen++
+	if !s.closed && s.pending > 0 {
+		// A failed flush means the connection is broken – the error
+		// is returned by the next Send or Flush call.
+		_ = s.flush()
+	}
+}
+
+func (s *Session) shouldFlush() bool {
+	if s.FlushBatch <= 1 && s.FlushInterval <= 0 {
+		return true
+	}
+
+	return (s.FlushBatch > 1 && s.pending >= s.FlushBatch) ||
+		(s.FlushInterval > 0 && time.Since(s.lastFlush) >= s.FlushInterval)
+}
+
+// SetWriteDeadline sets the deadline for writing to the client's connection.
+// A zero value means no deadline. If the response writer does not support
+// setting deadlines, http.ErrNotSupported is returned.
+//
+// See http.ResponseController.SetWriteDeadline for more information.
+func (s *Session) SetWriteDeadline(t time.Time) error {
+	return http.NewResponseController(s.Res).SetWriteDeadline(t)
+}
+
+// setWriteTimeout sets the write deadline for the next write, if there is a WriteTimeout.
+func (s *Session) setWriteTimeout() {
+	if s.WriteTimeout <= 0 || s.noDeadlines {
+		return
+	}
+
+	if err := s.SetWriteDeadline(time.Now().Add(s.WriteTimeout)); errors.Is(err, http.ErrNotSupported) {
+		s.noDeadlines = true
+	}
+}
+
 func (s *Session) doUpgrade() error {
 	if !s.didUpgrade {
-		s.Res.Header()[headerContentType] = headerContentTypeValue
+		s.setWriteTimeout()
+		h := s.Res.Header()
+		h[headerContentType] = headerContentTypeValue
+		if s.NDJSON {
+			h[headerContentType] = headerContentTypeNDJSONValue
+		}
+		h[headerCacheControl] = headerCacheControlValue
 		if err := s.Res.Flush(); err != nil {
 			return err
 		}
 		s.didUpgrade = true
+		s.lastFlush = time.Now()
 	}
 	return nil
 }
@@ -78,9 +323,15 @@ func (s *Session) doUpgrade() error {
 // It returns a Session that's used to send events to the client, or an
 // error if the upgrade failed.
 //
-// The headers required by the SSE protocol are only sent when calling
+// The headers required by the SSE protocol (Content-Type: text/event-stream and
+// Cache-Control: no-cache, so proxies don't cache the stream) are only sent when calling
 // the Send method for the first time. If other operations are done before
 // sending messages, other headers and status codes can safely be set.
+//
+// The response writer must implement http.Flusher or have an Unwrap method which
+// returns such a writer, so messages can be sent to the client as soon as possible –
+// otherwise ErrUpgradeUnsupported is returned. This also ensures events are delivered
+// promptly when running behind HTTP/2, where responses are buffered.
 func Upgrade(w http.ResponseWriter, r *http.Request) (*Session, error) {
 	rw := getResponseWriter(w)
 	if rw == nil {
@@ -104,12 +355,17 @@ var ErrUpgradeUnsupported = errors.New("go-sse.server: upgrade unsupported")
 
 // Canonicalized header keys.
 const (
-	headerLastEventID = "Last-Event-Id"
-	headerContentType = "Content-Type"
+	headerLastEventID  = "Last-Event-Id"
+	headerContentType  = "Content-Type"
+	headerCacheControl = "Cache-Control"
 )
 
-// Pre-allocated header value.
-var headerContentTypeValue = []string{"text/event-stream"}
+// Pre-allocated header values.
+var (
+	headerContentTypeValue       = []string{"text/event-stream"}
+	headerContentTypeNDJSONValue = []string{"application/x-ndjson"}
+	headerCacheControlValue      = []string{"no-cache"}
+)
 
 // Logic below is similar to Go 1.20's ResponseController.
 // We can't use that because we need to check if the request supports
@@ -153,8 +409,12 @@ func (f flusherWrapper) Flush() error {
 	return nil
 }
 
+func (f flusherWrapper) Unwrap() http.ResponseWriter { return f.writeFlusher }
+
 type flusherErrorWrapper struct {
 	writeFlusherError
 }
 
 func (f flusherErrorWrapper) Flush() error { return f.FlushError() }
+
+func (f flusherErrorWrapper) Unwrap() http.ResponseWriter { return f.writeFlusherError }
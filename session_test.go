@@ -2,10 +2,12 @@ package sse_test
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/tmaxmax/go-sse"
 	"github.com/tmaxmax/go-sse/internal/tests"
@@ -28,7 +30,8 @@ func TestUpgrade(t *testing.T) {
 	t.Cleanup(func() { _ = r.Body.Close() })
 
 	expectedHeaders := http.Header{
-		"Content-Type": []string{"text/event-stream"},
+		"Content-Type":  []string{"text/event-stream"},
+		"Cache-Control": []string{"no-cache"},
 	}
 	expectedBody := "id: hello\n\n"
 
@@ -85,3 +88,231 @@ func TestUpgradedRequest_Send_error(t *testing.T) {
 	tests.ErrorIs(t, conn.Send(&sse.Message{ID: sse.ID("")}), errWriteFailed, "invalid Send error")
 	tests.Expect(t, rec.Flushed, "writer wasn't flushed")
 }
+
+func TestSession_SetWriteDeadline(t *testing.T) {
+	t.Parallel()
+
+	sess, err := sse.Upgrade(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	tests.Equal(t, err, nil, "unexpected Upgrade error")
+	tests.ErrorIs(t, sess.SetWriteDeadline(time.Now()), http.ErrNotSupported, "recorder should not support deadlines")
+
+	var deadlineErr error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, _ := sse.Upgrade(w, r)
+		deadlineErr = sess.SetWriteDeadline(time.Now().Add(time.Second))
+	}))
+	defer srv.Close()
+
+	res, err := srv.Client().Get(srv.URL)
+	tests.Equal(t, err, nil, "unexpected request error")
+	_ = res.Body.Close()
+	tests.Equal(t, deadlineErr, nil, "deadline should be set on real connections")
+}
+
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	deadlines []time.Time
+}
+
+func (d *deadlineRecorder) SetWriteDeadline(t time.Time) error {
+	d.deadlines = append(d.deadlines, t)
+	return nil
+}
+
+func TestSession_WriteTimeout(t *testing.T) {
+	t.Parallel()
+
+	rec := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+	sess, err := sse.Upgrade(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	tests.Equal(t, err, nil, "unexpected Upgrade error")
+	sess.WriteTimeout = time.Minute
+
+	start := time.Now()
+	tests.Equal(t, sess.Send(&sse.Message{ID: sse.ID("1")}), nil, "unexpected Send error")
+	tests.Equal(t, sess.Flush(), nil, "unexpected Flush error")
+	// The upgrade, the message and the flush are written with a deadline.
+	tests.Equal(t, len(rec.deadlines), 3, "invalid number of deadlines")
+	for _, d := range rec.deadlines {
+		tests.Expect(t, !d.Before(start.Add(time.Minute)), "deadline should be after the timeout")
+	}
+
+	sess, err = sse.Upgrade(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	tests.Equal(t, err, nil, "unexpected Upgrade error")
+	sess.WriteTimeout = time.Minute
+	tests.Equal(t, sess.Send(&sse.Message{ID: sse.ID("1")}), nil, "writes should work without deadline support")
+}
+
+func TestSession_Sequence(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	sess, err := sse.Upgrade(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	tests.Equal(t, err, nil, "unexpected Upgrade error")
+	sess.Sequence = true
+
+	heartbeat := &sse.Message{}
+	heartbeat.AppendComment("ping")
+
+	tests.Equal(t, sess.Send(&sse.Message{ID: sse.ID("5")}), nil, "unexpected Send error")
+	tests.Equal(t, sess.Send(heartbeat), nil, "unexpected Send error")
+	tests.Equal(t, sess.Send(&sse.Message{}), nil, "unexpected Send error")
+	tests.Equal(t, sess.Send(&sse.Message{Type: sse.Type("x")}), nil, "unexpected Send error")
+
+	expected := "seq: 1\nid: 5\n\n: ping\n\nseq: 2\nevent: x\n\n"
+	tests.Equal(t, rec.Body.String(), expected, "invalid sequence numbers")
+	tests.Equal(t, sess.BytesWritten(), int64(len(expected)), "sequence fields should be counted")
+}
+
+func TestSession_Timestamp(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	sess, err := sse.Upgrade(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	tests.Equal(t, err, nil, "unexpected Upgrade error")
+	sess.Sequence = true
+	sess.Timestamp = true
+
+	heartbeat := &sse.Message{}
+	heartbeat.AppendComment("ping")
+
+	before := time.Now().UnixMilli()
+	tests.Equal(t, sess.Send(&sse.Message{ID: sse.ID("5")}), nil, "unexpected Send error")
+	tests.Equal(t, sess.Send(heartbeat), nil, "unexpected Send error")
+	after := time.Now().UnixMilli()
+
+	var ts int64
+	_, err = fmt.Sscanf(rec.Body.String(), "seq: 1\nts: %d\nid: 5\n\n: ping\n\n", &ts)
+	tests.Equal(t, err, nil, "invalid timestamp fields:\n%s", rec.Body.String())
+	tests.Expect(t, ts >= before && ts <= after, "timestamp should be the send time")
+}
+
+func TestSession_Flush_batch(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	sess, err := sse.Upgrade(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	tests.Equal(t, err, nil, "unexpected Upgrade error")
+	sess.FlushBatch = 2
+
+	send := func() {
+		t.Helper()
+
+		rec.Flushed = false
+		tests.Equal(t, sess.Send(&sse.Message{ID: sse.ID("1")}), nil, "unexpected Send error")
+		tests.Equal(t, sess.Flush(), nil, "unexpected Flush error")
+	}
+
+	send()
+	tests.Expect(t, rec.Flushed, "headers should be flushed on upgrade")
+	send()
+	tests.Expect(t, rec.Flushed, "writer should be flushed when batch is full")
+	send()
+	tests.Expect(t, !rec.Flushed, "writer should not be flushed before batch is full")
+	send()
+	tests.Expect(t, rec.Flushed, "writer should be flushed when batch is full")
+
+	sess.FlushBatch = 0
+	sess.FlushInterval = time.Hour
+	send()
+	tests.Expect(t, !rec.Flushed, "writer should not be flushed before the interval passes")
+
+	sess.FlushInterval = time.Nanosecond
+	send()
+	tests.Expect(t, rec.Flushed, "writer should be flushed after the interval passes")
+}
+
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes chan struct{}
+}
+
+func (f flushRecorder) Flush() { f.flushes <- struct{}{} }
+
+func TestSession_Flush_delayed(t *testing.T) {
+	t.Parallel()
+
+	upgrade := func(t *testing.T) (*sse.Session, flushRecorder) {
+		t.Helper()
+
+		rec := flushRecorder{ResponseRecorder: httptest.NewRecorder(), flushes: make(chan struct{}, 4)}
+		sess, err := sse.Upgrade(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+		tests.Equal(t, err, nil, "unexpected Upgrade error")
+
+		tests.Equal(t, sess.Send(&sse.Message{ID: sse.ID("1")}), nil, "unexpected Send error")
+		<-rec.flushes // the headers are flushed on upgrade
+
+		return sess, rec
+	}
+
+	send := func(t *testing.T, sess *sse.Session) {
+		t.Helper()
+
+		tests.Equal(t, sess.Send(&sse.Message{ID: sse.ID("2")}), nil, "unexpected Send error")
+		tests.Equal(t, sess.Flush(), nil, "unexpected Flush error")
+	}
+
+	waitFlush := func(t *testing.T, rec flushRecorder, msg string) {
+		t.Helper()
+
+		select {
+		case <-rec.flushes:
+		case <-time.After(time.Second / 2):
+			t.Fatal(msg)
+		}
+	}
+
+	t.Run("Interval", func(t *testing.T) {
+		t.Parallel()
+
+		sess, rec := upgrade(t)
+		sess.FlushBatch = 5
+		sess.FlushInterval = time.Millisecond * 50
+
+		// The last message of the batch is not followed by another one.
+		send(t, sess)
+		tests.Equal(t, len(rec.flushes), 0, "writer should not be flushed before the interval passes")
+		waitFlush(t, rec, "pending messages should be flushed after the interval")
+		tests.Equal(t, rec.Body.String(), "id: 1\n\nid: 2\n\n", "invalid body")
+		tests.Equal(t, sess.Close(), nil, "unexpected Close error")
+		tests.Equal(t, len(rec.flushes), 0, "nothing should be flushed on close")
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		t.Parallel()
+
+		sess, rec := upgrade(t)
+		sess.FlushBatch = 5
+
+		send(t, sess)
+		waitFlush(t, rec, "pending messages should be flushed even if the batch is not full")
+		tests.Equal(t, sess.Close(), nil, "unexpected Close error")
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		t.Parallel()
+
+		sess, rec := upgrade(t)
+		sess.FlushInterval = time.Hour
+
+		send(t, sess)
+		tests.Equal(t, len(rec.flushes), 0, "writer should not be flushed before the interval passes")
+		tests.Equal(t, sess.Close(), nil, "unexpected Close error")
+		tests.Equal(t, len(rec.flushes), 1, "pending messages should be flushed on close")
+		tests.Equal(t, sess.Close(), nil, "closing multiple times should be a no-op")
+	})
+}
+
+func TestSession_BytesWritten(t *testing.T) {
+	t.Parallel()
+
+	sess, err := sse.Upgrade(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	tests.Equal(t, err, nil, "unexpected Upgrade error")
+
+	m := &sse.Message{ID: sse.ID("1")}
+	m.AppendData("hello")
+
+	tests.Equal(t, sess.BytesWritten(), 0, "no bytes should be written initially")
+	_ = sess.Send(m)
+	_ = sess.Send(m)
+	tests.Equal(t, sess.BytesWritten(), int64(2*len(m.String())), "invalid bytes written count")
+}
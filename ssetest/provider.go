@@ -0,0 +1,225 @@
+// Package ssetest provides utilities for testing code that uses go-sse.
+package ssetest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tmaxmax/go-sse"
+)
+
+// A Publication is a message published to a FakeProvider.
+type Publication struct {
+	Message *sse.Message
+	Topics  []string
+}
+
+type fakeSubscriber struct {
+	done chan error
+	sse.Subscription
+}
+
+// FakeProvider is an sse.Provider meant to be used in tests. It records all the
+// published messages and lets the tests decide when and what messages are delivered
+// to the subscribers. The zero value is ready to use.
+//
+// Subscribe blocks until the subscription's context is done, the provider is shut down
+// or delivering a message to the subscriber fails, in which case the error is returned.
+type FakeProvider struct {
+	subscribers map[*fakeSubscriber]struct{}
+	changed     chan struct{}
+	published   []Publication
+
+	mu sync.Mutex
+
+	// If AutoDeliver is true, published messages are also delivered to the subscribers.
+	AutoDeliver bool
+
+	closed bool
+}
+
+// Subscribe registers the subscription and blocks until it is removed.
+func (f *FakeProvider) Subscribe(ctx context.Context, sub sse.Subscription) error {
+	s := &fakeSubscriber{done: make(chan error, 1), Subscription: sub}
+
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return sse.ErrProviderClosed
+	}
+	if f.subscribers == nil {
+		f.subscribers = map[*fakeSubscriber]struct{}{}
+	}
+	f.subscribers[s] = struct{}{}
+	f.notify()
+	f.mu.Unlock()
+
+	select {
+	case err := <-s.done:
+		return err
+	case <-ctx.Done():
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	select {
+	case err := <-s.done:
+		return err
+	default:
+		f.remove(s, nil)
+		return nil
+	}
+}
+
+// Publish records the message. If AutoDeliver is set, the message is also delivered
+// to the subscribers.
+func (f *FakeProvider) Publish(msg *sse.Message, topics []string) error {
+	if len(topics) == 0 {
+		return sse.ErrNoTopic
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return sse.ErrProviderClosed
+	}
+
+	f.published = append(f.published, Publication{Message: msg, Topics: topics})
+	if f.AutoDeliver {
+		f.deliver(msg, topics)
+	}
+
+	return nil
+}
+
+// Shutdown removes all the subscribers, whose Subscribe calls return sse.ErrProviderClosed.
+// Subsequent calls return sse.ErrProviderClosed.
+func (f *FakeProvider) Shutdown(_ context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return sse.ErrProviderClosed
+	}
+
+	f.closed = true
+	for s := range f.subscribers {
+		f.remove(s, sse.ErrProviderClosed)
+	}
+
+	return nil
+}
+
+// Published returns all the messages published until now, in the order they were published.
+func (f *FakeProvider) Published() []Publication {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Publication(nil), f.published...)
+}
+
+// Subscriptions returns the subscriptions which are currently active, in no particular order.
+func (f *FakeProvider) Subscriptions() []sse.Subscription {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subs := make([]sse.Subscription, 0, len(f.subscribers))
+	for s := range f.subscribers {
+		subs = append(subs, s.Subscription)
+	}
+
+	return subs
+}
+
+// Deliver sends the message to all the subscribers of the given topics and flushes it.
+// Subscribers for which sending fails are removed and the error is returned by their
+// Subscribe call. It returns the number of subscribers that successfully received the message.
+func (f *FakeProvider) Deliver(msg *sse.Message, topics ...string) int {
+	if len(topics) == 0 {
+		topics = []string{sse.DefaultTopic}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.deliver(msg, topics)
+}
+
+// WaitForSubscribers blocks until the provider has at least n active subscribers
+// or the context is done, in which case the context's error is returned.
+func (f *FakeProvider) WaitForSubscribers(ctx context.Context, n int) error {
+	for {
+		f.mu.Lock()
+		if len(f.subscribers) >= n {
+			f.mu.Unlock()
+			return nil
+		}
+		if f.changed == nil {
+			f.changed = make(chan struct{})
+		}
+		changed := f.changed
+		f.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (f *FakeProvider) deliver(msg *sse.Message, topics []string) int {
+	var delivered int
+
+	for s := range f.subscribers {
+		if !receives(&s.Subscription, topics) {
+			continue
+		}
+
+		err := s.Client.Send(msg)
+		if err == nil {
+			err = s.Client.Flush()
+		}
+
+		if err != nil {
+			f.remove(s, err)
+		} else {
+			delivered++
+		}
+	}
+
+	return delivered
+}
+
+func (f *FakeProvider) remove(s *fakeSubscriber, err error) {
+	delete(f.subscribers, s)
+	s.done <- err
+	f.notify()
+}
+
+func (f *FakeProvider) notify() {
+	if f.changed != nil {
+		close(f.changed)
+		f.changed = nil
+	}
+}
+
+func receives(sub *sse.Subscription, topics []string) bool {
+	if sub.AllTopics {
+		return true
+	}
+
+	for _, st := range sub.Topics {
+		for _, t := range topics {
+			if st == t {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+var _ sse.Provider = (*FakeProvider)(nil)
@@ -0,0 +1,84 @@
+package ssetest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/internal/tests"
+	"github.com/tmaxmax/go-sse/ssetest"
+)
+
+type mockClient func(m *sse.Message) error
+
+func (c mockClient) Send(m *sse.Message) error { return c(m) }
+func (c mockClient) Flush() error              { return nil }
+
+func TestFakeProvider(t *testing.T) {
+	t.Parallel()
+
+	p := &ssetest.FakeProvider{}
+
+	tests.Equal(t, p.Publish(&sse.Message{}, nil), sse.ErrNoTopic, "topics should be required")
+
+	m := &sse.Message{ID: sse.ID("1")}
+	tests.Equal(t, p.Publish(m, []string{sse.DefaultTopic}), nil, "unexpected publish error")
+	tests.DeepEqual(t, p.Published(), []ssetest.Publication{{Message: m, Topics: []string{sse.DefaultTopic}}}, "publication not recorded")
+
+	received := make(chan *sse.Message, 1)
+	sendErr := errors.New("send failed")
+	done := make(chan error, 2)
+
+	go func() {
+		done <- p.Subscribe(context.Background(), sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error { received <- m; return nil }),
+			Topics: []string{"a"},
+		})
+	}()
+	go func() {
+		done <- p.Subscribe(context.Background(), sse.Subscription{
+			Client:    mockClient(func(*sse.Message) error { return sendErr }),
+			AllTopics: true,
+		})
+	}()
+
+	tests.Equal(t, p.WaitForSubscribers(context.Background(), 2), nil, "subscribers should be registered")
+	tests.Equal(t, len(p.Subscriptions()), 2, "invalid subscriptions count")
+	tests.Equal(t, len(received), 0, "published message should not be delivered")
+
+	tests.Equal(t, p.Deliver(m, "a"), 1, "message should be delivered to a single subscriber")
+	tests.Equal(t, <-received, m, "invalid message received")
+	tests.ErrorIs(t, <-done, sendErr, "send error should be returned")
+
+	tests.Equal(t, p.Shutdown(context.Background()), nil, "unexpected shutdown error")
+	tests.Equal(t, <-done, sse.ErrProviderClosed, "subscribe should return after shutdown")
+	tests.Equal(t, p.Shutdown(context.Background()), sse.ErrProviderClosed, "provider should be closed")
+	tests.Equal(t, p.Publish(m, []string{sse.DefaultTopic}), sse.ErrProviderClosed, "provider should be closed")
+}
+
+func TestFakeProvider_AutoDeliver(t *testing.T) {
+	t.Parallel()
+
+	p := &ssetest.FakeProvider{AutoDeliver: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan *sse.Message, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- p.Subscribe(ctx, sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error { received <- m; return nil }),
+			Topics: []string{sse.DefaultTopic},
+		})
+	}()
+
+	tests.Equal(t, p.WaitForSubscribers(context.Background(), 1), nil, "subscriber should be registered")
+
+	m := &sse.Message{ID: sse.ID("1")}
+	tests.Equal(t, p.Publish(m, []string{sse.DefaultTopic}), nil, "unexpected publish error")
+	tests.Equal(t, <-received, m, "published message should be delivered")
+
+	cancel()
+	tests.Equal(t, <-done, nil, "subscribe should return after the context is done")
+	tests.Equal(t, len(p.Subscriptions()), 0, "subscriber should be removed")
+}
@@ -0,0 +1,160 @@
+package ssetest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tmaxmax/go-sse/internal/parser"
+)
+
+// A ValidationError is a problem found by Validate in an event stream.
+type ValidationError struct {
+	// The number of the line the problem was found on, starting from 1.
+	Line int
+	// What is wrong with the line.
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+const bom = "\xEF\xBB\xBF"
+
+// Validate reads the whole event stream and returns all the problems found in it, in the order
+// of their lines, instead of stopping at the first one. It is meant for verifying the output of
+// servers, including servers which don't use this package, in tests. The problems are of type
+// *ValidationError, except for errors returned by the reader, which are returned as they are,
+// after the problems found until then. A valid stream has no problems, so nil is returned.
+//
+// These are reported as problems, as clients ignore or misinterpret them:
+//   - lines which are not valid UTF-8;
+//   - lines with a field name other than data, event, id and retry;
+//   - retry values which are not made only of ASCII digits, and IDs which contain a NULL character;
+//   - line endings different from those of the first line – CR, LF and CRLF are all valid,
+//     but mixing them usually means that some lines end with a stray CR. It is reported only once;
+//   - byte order marks anywhere else than at the start of the stream;
+//   - a stream which ends before its last event is terminated by a blank line, as clients don't
+//     dispatch such events. Comments don't need to be terminated.
+//
+// Comment lines and a byte order mark at the start of the stream are valid.
+func Validate(r io.Reader) []error {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, math.MaxInt32)
+	s.Split(splitLines)
+
+	var errs []error
+	report := func(line int, format string, args ...any) {
+		errs = append(errs, &ValidationError{Line: line, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	var firstEnding string
+	mixedEndings, pending := false, false
+	lineNum := 0
+
+	for s.Scan() {
+		lineNum++
+		line, ending := cutLineEnding(s.Text())
+		if lineNum == 1 {
+			line = strings.TrimPrefix(line, bom)
+		}
+
+		switch {
+		case ending == "":
+		case firstEnding == "":
+			firstEnding = ending
+		case ending != firstEnding && !mixedEndings:
+			mixedEndings = true
+			report(lineNum, "line ends with %q, but the previous lines end with %q", ending, firstEnding)
+		}
+
+		if !utf8.ValidString(line) {
+			report(lineNum, "line is not valid UTF-8")
+		}
+		if strings.HasPrefix(line, bom) {
+			report(lineNum, "byte order mark is not at the start of the stream")
+		}
+
+		if line == "" {
+			pending = false
+			continue
+		}
+		if line[0] == ':' {
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(line, ":")
+		if hasValue {
+			value = strings.TrimPrefix(value, " ")
+		}
+
+		switch parser.FieldName(name) { //nolint:exhaustive // Comments were handled above.
+		case parser.FieldNameData, parser.FieldNameEvent:
+		case parser.FieldNameID:
+			if strings.IndexByte(value, 0) != -1 {
+				report(lineNum, "id contains a NULL character, so it is ignored")
+			}
+		case parser.FieldNameRetry:
+			if value == "" || strings.Trim(value, "0123456789") != "" {
+				report(lineNum, "retry value %q is not made only of ASCII digits, so it is ignored", value)
+			}
+		default:
+			report(lineNum, "unknown field name %q", name)
+		}
+
+		pending = true
+	}
+
+	if err := s.Err(); err != nil {
+		return append(errs, err)
+	}
+
+	if pending {
+		report(lineNum, "stream ends before the last event is terminated by a blank line, so the event is not dispatched")
+	}
+
+	return errs
+}
+
+// splitLines splits the stream into lines, keeping their line endings.
+// A line which is not terminated is returned as it is at EOF.
+func splitLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i != -1 {
+		if data[i] == '\r' {
+			if i+1 == len(data) && !atEOF {
+				// The line may end with CRLF.
+				return 0, nil, nil
+			}
+			if i+1 < len(data) && data[i+1] == '\n' {
+				return i + 2, data[:i+2], nil
+			}
+		}
+
+		return i + 1, data[:i+1], nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+func cutLineEnding(line string) (content, ending string) {
+	for _, e := range [...]string{"\r\n", "\n", "\r"} {
+		if strings.HasSuffix(line, e) {
+			return line[:len(line)-len(e)], e
+		}
+	}
+
+	return line, ""
+}
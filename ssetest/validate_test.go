@@ -0,0 +1,51 @@
+package ssetest_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/internal/tests"
+	"github.com/tmaxmax/go-sse/ssetest"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	valid := "\xEF\xBB\xBF: comment\nid: 1\nevent: update\ndata: hello\ndata\nretry: 1000\n\n: keep-alive\n"
+	tests.Equal(t, len(ssetest.Validate(strings.NewReader(valid))), 0, "valid stream should have no problems")
+	tests.Equal(t, len(ssetest.Validate(strings.NewReader("data: a\r\r"))), 0, "CR line endings should be valid")
+
+	m := &sse.Message{ID: sse.ID("1")}
+	m.AppendData("multi\nline")
+	m.AppendComment("note")
+	tests.Equal(t, len(ssetest.Validate(strings.NewReader(m.String()+m.String()))), 0, "messages written by this package should be valid")
+
+	invalid := "data: a\n" +
+		"retry: 1.5\n" +
+		"Data: b\r\n" +
+		"id: x\x00\n" +
+		"\xEF\xBB\xBFdata: c\n" +
+		"data: \xff\n" +
+		"\n" +
+		"data: unterminated"
+
+	var lines []int
+	var reasons []string
+	for _, err := range ssetest.Validate(strings.NewReader(invalid)) {
+		var verr *ssetest.ValidationError
+		tests.Expect(t, errors.As(err, &verr), "problems should be validation errors, got %v", err)
+		lines = append(lines, verr.Line)
+		reasons = append(reasons, verr.Reason)
+	}
+
+	tests.DeepEqual(t, lines, []int{2, 3, 3, 4, 5, 5, 6, 8}, "all the problems should be reported, in order; got %q", reasons)
+
+	readErr := errors.New("read failed")
+	errs := ssetest.Validate(io.MultiReader(strings.NewReader("retry: x\n"), iotest.ErrReader(readErr)))
+	tests.Equal(t, len(errs), 2, "problems should be reported before reader errors")
+	tests.Equal(t, errs[1], readErr, "reader errors should be returned")
+}
@@ -0,0 +1,161 @@
+package sse
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// Tee is a Provider which mirrors the messages published to another provider to a sink,
+// for example to write them to an audit log or an analytics pipeline, without changing
+// the code which publishes them.
+//
+// The sink is called from a separate goroutine, one message at a time, in the order the messages
+// were published, so a slow sink never delays the delivery to the subscribers. Messages are buffered
+// until the sink handles them; if the buffer is full, the messages are dropped for the sink – they
+// are still delivered to the subscribers – and counted by Dropped. The sink's panics are logged and
+// do not affect the delivery either.
+type Tee struct {
+	// The provider to which messages are published. Defaults to Joe.
+	Provider Provider
+	// The function called for every message published successfully, with the topics it was published to.
+	// It receives a copy of the message, as it was given to Publish – IDs set automatically by the
+	// provider's replay provider are not included. Required.
+	Sink func(message *Message, topics []string)
+	// The number of messages buffered for the sink. Defaults to 256.
+	BufferSize int
+
+	provider Provider
+	queue    chan teeMessage
+	done     chan struct{}
+	dropped  atomic.Uint64
+
+	mu       sync.RWMutex
+	closed   bool
+	initDone sync.Once
+}
+
+// NewTee creates a Tee which publishes to the given provider and mirrors the messages to the sink.
+func NewTee(provider Provider, sink func(message *Message, topics []string)) *Tee {
+	return &Tee{Provider: provider, Sink: sink}
+}
+
+type teeMessage struct {
+	message *Message
+	topics  []string
+}
+
+const defaultTeeBufferSize = 256
+
+// Subscribe subscribes to the provider.
+func (t *Tee) Subscribe(ctx context.Context, sub Subscription) error {
+	t.init()
+	return t.provider.Subscribe(ctx, sub)
+}
+
+// Publish publishes the message to the provider and then queues it for the sink.
+// If the message couldn't be published, it is not given to the sink.
+func (t *Tee) Publish(msg *Message, topics []string) error {
+	t.init()
+
+	// The message must be copied before it is published: once published, the provider
+	// may modify it concurrently, for example when its replay provider sets the ID.
+	m := teeMessage{message: msg.Clone(), topics: append([]string(nil), topics...)}
+
+	if err := t.provider.Publish(msg, topics); err != nil {
+		return err
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.closed {
+		return nil
+	}
+
+	select {
+	case t.queue <- m:
+	default:
+		t.dropped.Add(1)
+	}
+
+	return nil
+}
+
+// Dropped returns the number of messages which were not given to the sink because the buffer was full.
+func (t *Tee) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+// Shutdown shuts down the provider and then waits for the sink to handle the buffered messages.
+// Messages published afterwards are not given to the sink. If the context is done before the
+// sink handles them, the context error is returned and the remaining messages are handled
+// in the background.
+func (t *Tee) Shutdown(ctx context.Context) error {
+	t.init()
+
+	err := t.provider.Shutdown(ctx)
+
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tee) run() {
+	defer close(t.done)
+
+	for m := range t.queue {
+		t.sink(m)
+	}
+}
+
+func (t *Tee) sink(m teeMessage) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	t.Sink(m.message, m.topics)
+}
+
+func (t *Tee) init() {
+	t.initDone.Do(func() {
+		if t.Sink == nil {
+			panic("go-sse: a Tee requires a Sink")
+		}
+
+		t.provider = t.Provider
+		if t.provider == nil {
+			t.provider = &Joe{}
+		}
+
+		size := t.BufferSize
+		if size <= 0 {
+			size = defaultTeeBufferSize
+		}
+
+		t.queue = make(chan teeMessage, size)
+		t.done = make(chan struct{})
+
+		go t.run()
+	})
+}
+
+var _ Provider = (*Tee)(nil)
@@ -0,0 +1,51 @@
+package sse_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/internal/tests"
+)
+
+func TestTee(t *testing.T) {
+	t.Parallel()
+
+	started, unblock := make(chan struct{}), make(chan struct{})
+	var sunk []string
+
+	tee := sse.NewTee(&sse.Joe{}, func(m *sse.Message, topics []string) {
+		if len(sunk) == 0 {
+			close(started)
+			<-unblock
+		}
+
+		sunk = append(sunk, m.ID.String())
+		if m.ID.String() == "2" {
+			panic("sink failed")
+		}
+	})
+	tee.BufferSize = 2
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	sub := subscribe(t, tee, ctx)
+	<-ctx.waitingOnDone
+
+	topics := []string{sse.DefaultTopic}
+
+	tests.Equal(t, tee.Publish(msg(t, "hello", "1"), topics), nil, "unexpected publish error")
+	<-started
+	for _, id := range []string{"2", "3", "4"} {
+		tests.Equal(t, tee.Publish(msg(t, "hello", id), topics), nil, "unexpected publish error")
+	}
+	tests.Equal(t, tee.Dropped(), uint64(1), "message should be dropped for the full sink")
+
+	close(unblock)
+	tests.Equal(t, tee.Shutdown(context.Background()), nil, "unexpected shutdown error")
+	tests.ErrorIs(t, tee.Publish(msg(t, "hello", "5"), topics), sse.ErrProviderClosed, "publish should fail after shutdown")
+
+	tests.DeepEqual(t, sunk, []string{"1", "2", "3"}, "messages should be sunk in order, despite panics")
+	tests.Equal(t, len(<-sub), 4, "all the messages should be delivered to the subscriber")
+}
@@ -0,0 +1,117 @@
+package sse
+
+import (
+	"bytes"
+	"context"
+)
+
+// WebSocketConn is the part of a WebSocket connection used by WebSocketWriter. It is small enough
+// to be implemented on top of the connection type of any WebSocket library, so this package
+// doesn't depend on one. For example, with github.com/gorilla/websocket:
+//
+//	type conn struct{ *websocket.Conn }
+//
+//	func (c conn) ReadText() ([]byte, error) {
+//		_, p, err := c.ReadMessage()
+//		return p, err
+//	}
+//
+//	func (c conn) WriteText(p []byte) error { return c.WriteMessage(websocket.TextMessage, p) }
+//
+// ReadText and WriteText are called concurrently, but each of them is never called concurrently
+// with itself – most WebSocket libraries support this.
+type WebSocketConn interface {
+	// ReadText reads the payload of the next message sent by the client.
+	// It must return an error once the connection is closed.
+	ReadText() ([]byte, error)
+	// WriteText sends the payload to the client as a text message.
+	WriteText(p []byte) error
+}
+
+// WebSocketWriter is a MessageWriter which sends each message as a WebSocket text message,
+// for clients which can't use EventSource but can use WebSockets. This way the same providers
+// and replay providers serve both kinds of clients. Use Serve to subscribe a connection.
+//
+// Each message is sent in the event stream format, as written by Message.WriteTo, so the clients
+// can parse it just like an event stream, or in its JSON representation, if JSON is set.
+// Empty messages are not sent.
+type WebSocketWriter struct {
+	// The connection to which the messages are sent. Required.
+	Conn WebSocketConn
+	// If JSON is true, the messages are sent in the representation returned by Message.MarshalJSON.
+	JSON bool
+
+	buf bytes.Buffer
+}
+
+// Send sends the message to the client as a text message.
+func (w *WebSocketWriter) Send(m *Message) error {
+	var p []byte
+	if w.JSON {
+		var err error
+		if p, err = m.MarshalJSON(); err != nil {
+			return err
+		}
+		if string(p) == "{}" {
+			return nil
+		}
+	} else {
+		w.buf.Reset()
+		if _, err := m.WriteTo(&w.buf); err != nil {
+			return err
+		}
+		p = w.buf.Bytes()
+	}
+
+	if len(p) == 0 {
+		return nil
+	}
+
+	return w.Conn.WriteText(p)
+}
+
+// Flush does nothing, as each message is sent right away.
+func (w *WebSocketWriter) Flush() error {
+	return nil
+}
+
+// Serve subscribes the connection to the provider using the given subscription, whose client
+// and last event ID are set by Serve, and sends the messages to the client until the context
+// is done, the client disconnects or sending a message fails.
+//
+// The first message sent by the client is the handshake: its payload is the client's last event ID,
+// which takes the place of the Last-Event-ID header of event streams, so the client is replayed the
+// messages it missed. If the client doesn't have one, it sends an empty message; invalid IDs are
+// ignored. The messages the client sends afterwards are discarded.
+//
+// When the client disconnects, ReadText fails and the client is unsubscribed, just like when the
+// request of an event stream ends – the error returned is then nil. Otherwise, the errors are those
+// returned by the provider's Subscribe method, or by ReadText, if the handshake fails. The connection
+// is not closed by Serve: close it after Serve returns, which also stops the goroutine reading from it.
+func (w *WebSocketWriter) Serve(ctx context.Context, p Provider, sub Subscription) error {
+	handshake, err := w.Conn.ReadText()
+	if err != nil {
+		return err
+	}
+	if len(handshake) > 0 {
+		// Invalid IDs are unset, so they are ignored by providers, just like in Upgrade.
+		sub.LastEventID, _ = NewID(string(handshake))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		defer cancel()
+		for {
+			if _, err := w.Conn.ReadText(); err != nil {
+				return
+			}
+		}
+	}()
+
+	sub.Client = w
+	return p.Subscribe(ctx, sub)
+}
+
+var _ MessageWriter = (*WebSocketWriter)(nil)
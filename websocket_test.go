@@ -0,0 +1,90 @@
+package sse_test
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/internal/tests"
+)
+
+type mockWebSocketConn struct {
+	reads  chan []byte
+	mu     sync.Mutex
+	writes []string
+}
+
+func (c *mockWebSocketConn) ReadText() ([]byte, error) {
+	p, ok := <-c.reads
+	if !ok {
+		return nil, io.EOF
+	}
+	return p, nil
+}
+
+func (c *mockWebSocketConn) WriteText(p []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writes = append(c.writes, string(p))
+	return nil
+}
+
+func TestWebSocketWriter_Serve(t *testing.T) {
+	t.Parallel()
+
+	rp, err := sse.NewFiniteReplayProvider(10, false)
+	tests.Equal(t, err, nil, "should create replay provider")
+
+	subscribed := make(chan struct{}, 1)
+	j := &sse.Joe{ReplayProvider: rp, OnSubscribe: func(sse.Subscription) { subscribed <- struct{}{} }}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	topics := []string{sse.DefaultTopic}
+	tests.Equal(t, j.Publish(msg(t, "missed", "1"), topics), nil, "unexpected publish error")
+	tests.Equal(t, j.Publish(msg(t, "missed", "2"), topics), nil, "unexpected publish error")
+
+	conn := &mockWebSocketConn{reads: make(chan []byte)}
+	w := &sse.WebSocketWriter{Conn: conn}
+
+	done := make(chan error, 1)
+	go func() { done <- w.Serve(context.Background(), j, sse.Subscription{Topics: topics}) }()
+
+	conn.reads <- []byte("1")
+	<-subscribed
+	conn.reads <- []byte("ignored")
+
+	tests.Equal(t, j.Publish(msg(t, "live", "3"), topics), nil, "unexpected publish error")
+	tests.Equal(t, j.Publish(&sse.Message{}, topics), nil, "unexpected publish error")
+	tests.Equal(t, j.Publish(msg(t, "live", "4"), topics), nil, "unexpected publish error")
+
+	close(conn.reads)
+	tests.Equal(t, <-done, nil, "disconnecting should unsubscribe without error")
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	tests.DeepEqual(t, conn.writes, []string{
+		"id: 2\ndata: missed\n\n",
+		"id: 3\ndata: live\n\n",
+		"id: 4\ndata: live\n\n",
+	}, "missed messages should be replayed, followed by the live ones")
+}
+
+func TestWebSocketWriter_JSON(t *testing.T) {
+	t.Parallel()
+
+	conn := &mockWebSocketConn{}
+	w := &sse.WebSocketWriter{Conn: conn, JSON: true}
+
+	tests.Equal(t, w.Send(msg(t, "hello", "1")), nil, "unexpected send error")
+	tests.Equal(t, w.Send(&sse.Message{}), nil, "unexpected send error")
+	tests.Equal(t, w.Flush(), nil, "unexpected flush error")
+	tests.DeepEqual(t, conn.writes, []string{`{"id":"1","chunks":[{"data":"hello"}]}`}, "messages should be sent as JSON")
+
+	conn = &mockWebSocketConn{reads: make(chan []byte)}
+	close(conn.reads)
+	err := (&sse.WebSocketWriter{Conn: conn}).Serve(context.Background(), &sse.Joe{}, sse.Subscription{Topics: []string{sse.DefaultTopic}})
+	tests.ErrorIs(t, err, io.EOF, "handshake errors should be returned")
+}